@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"geospatial-web-scraper/internal/crawler"
+)
+
+// main is the entry point for the standalone downloader. It fetches the URLs
+// (or manifests) given with -u into the directory given with -d.
+func main() {
+	os.Exit(crawler.RunDownloader(os.Args[1:]))
+}
@@ -0,0 +1,458 @@
+package crawler
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HNSW parameters. annM is the number of links a node keeps on the upper
+// layers (twice as many on layer 0), annEfBuild the size of the candidate
+// list used while inserting.
+const (
+	annM       = 16
+	annEfBuild = 100
+)
+
+// annEf is the size of the candidate list searched per query: higher values
+// find more of the true nearest neighbours at the cost of latency. Caches
+// with fewer than annExactBelow entries are searched exhaustively instead.
+// Both are replaced by Config.Apply.
+var annEf = activeConfig.ANNEf
+var annExactBelow = activeConfig.ExactBelow
+
+// annPath returns the path of the nearest neighbour index stored alongside
+// the embedding cache.
+func annPath() string {
+	return strings.TrimSuffix(dataPath, filepath.Ext(dataPath)) + ".hnsw"
+}
+
+// annNode is one vector of the graph. Deleted nodes stay in the graph so
+// searches can pass through them, but are never returned.
+type annNode struct {
+	URL     string
+	Vec     []float64 // unit length
+	Links   [][]int32 // neighbours per layer, layer 0 first
+	Deleted bool
+}
+
+// annFile is the on-disk form of an ANNIndex.
+type annFile struct {
+	Entry int
+	Top   int
+	Nodes []annNode
+}
+
+// ANNIndex is an HNSW (hierarchical navigable small world) graph over cached
+// embeddings, answering approximate top-k cosine similarity queries in
+// roughly logarithmic time. It supports incremental inserts and deletes and
+// is safe for concurrent use.
+type ANNIndex struct {
+	mu      sync.RWMutex
+	nodes   []annNode
+	ids     map[string]int // URL -> live node
+	entry   int            // entry point; -1 while empty
+	top     int            // layer of the entry point
+	deleted int
+	rng     *rand.Rand
+}
+
+// ANNHit is a result of ANNIndex.Search.
+type ANNHit struct {
+	URL        string
+	Similarity float64 // cosine similarity to the query
+}
+
+// NewANNIndex returns an empty index.
+func NewANNIndex() *ANNIndex {
+	return &ANNIndex{ids: make(map[string]int), entry: -1, rng: rand.New(rand.NewSource(1))}
+}
+
+// LoadANN reads an index written by Save.
+func LoadANN(p string) (*ANNIndex, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var f annFile
+	if err := gob.NewDecoder(file).Decode(&f); err != nil {
+		return nil, fmt.Errorf("decoding index %s: %w", p, err)
+	}
+	ix := NewANNIndex()
+	ix.nodes, ix.entry, ix.top = f.Nodes, f.Entry, f.Top
+	if len(ix.nodes) == 0 {
+		ix.entry = -1
+	}
+	for i, n := range ix.nodes {
+		if n.Deleted {
+			ix.deleted++
+		} else {
+			ix.ids[n.URL] = i
+		}
+	}
+	return ix, nil
+}
+
+// Save writes the index to p.
+func (ix *ANNIndex) Save(p string) error {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return WriteToGob(p, annFile{Entry: ix.entry, Top: ix.top, Nodes: ix.nodes})
+}
+
+// Len returns the number of live vectors.
+func (ix *ANNIndex) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.ids)
+}
+
+// unit returns v scaled to unit length.
+func unit(v []float64) ([]float64, error) {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	if sum == 0 || math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return nil, errors.New("cannot index a zero or non-finite vector")
+	}
+	norm := math.Sqrt(sum)
+	u := make([]float64, len(v))
+	for i, x := range v {
+		u[i] = x / norm
+	}
+	return u, nil
+}
+
+func (ix *ANNIndex) dist(q []float64, id int) float64 {
+	v := ix.nodes[id].Vec
+	if len(v) != len(q) {
+		return math.Inf(1)
+	}
+	var dot float64
+	for i := range q {
+		dot += q[i] * v[i]
+	}
+	return 1 - dot
+}
+
+// Insert adds the embedding of rawURL, replacing a previous one.
+func (ix *ANNIndex) Insert(rawURL string, embedding []float64) error {
+	vec, err := unit(embedding)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rawURL, err)
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if old, ok := ix.ids[rawURL]; ok {
+		if sameVec(ix.nodes[old].Vec, vec) {
+			return nil
+		}
+		ix.delete(rawURL)
+	}
+	ix.insert(rawURL, vec)
+	return nil
+}
+
+func (ix *ANNIndex) insert(rawURL string, vec []float64) {
+	level := int(-math.Log(1-ix.rng.Float64()) / math.Log(annM))
+	id := len(ix.nodes)
+	ix.nodes = append(ix.nodes, annNode{URL: rawURL, Vec: vec, Links: make([][]int32, level+1)})
+	ix.ids[rawURL] = id
+	if ix.entry < 0 {
+		ix.entry, ix.top = id, level
+		return
+	}
+
+	ep := []int{ix.entry}
+	for l := ix.top; l > level; l-- {
+		ep = []int{ix.searchLayer(vec, ep, 1, l)[0].id}
+	}
+	for l := min(level, ix.top); l >= 0; l-- {
+		found := ix.searchLayer(vec, ep, annEfBuild, l)
+		for _, nb := range ix.selectNeighbors(found, annM) {
+			ix.nodes[id].Links[l] = append(ix.nodes[id].Links[l], int32(nb))
+			ix.link(nb, id, l)
+		}
+		ep = ep[:0]
+		for _, c := range found {
+			ep = append(ep, c.id)
+		}
+	}
+	if level > ix.top {
+		ix.entry, ix.top = id, level
+	}
+}
+
+// link adds to as a neighbour of from on layer l, pruning from's links back
+// to the layer's limit.
+func (ix *ANNIndex) link(from, to, l int) {
+	links := append(ix.nodes[from].Links[l], int32(to))
+	limit := annM
+	if l == 0 {
+		limit = 2 * annM
+	}
+	if len(links) > limit {
+		cands := make([]annCand, len(links))
+		for i, nb := range links {
+			cands[i] = annCand{id: int(nb), d: ix.dist(ix.nodes[from].Vec, int(nb))}
+		}
+		sort.Slice(cands, func(i, j int) bool { return cands[i].d < cands[j].d })
+		links = links[:0]
+		for _, nb := range ix.selectNeighbors(cands, limit) {
+			links = append(links, int32(nb))
+		}
+	}
+	ix.nodes[from].Links[l] = links
+}
+
+// selectNeighbors picks up to m of the candidates (sorted nearest first),
+// preferring ones that are closer to the new node than to any neighbour
+// already picked, so links spread out in different directions.
+func (ix *ANNIndex) selectNeighbors(cands []annCand, m int) []int {
+	picked := make([]int, 0, m)
+	var skipped []int
+	for _, c := range cands {
+		if len(picked) == m {
+			break
+		}
+		diverse := true
+		for _, p := range picked {
+			if ix.dist(ix.nodes[c.id].Vec, p) < c.d {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			picked = append(picked, c.id)
+		} else {
+			skipped = append(skipped, c.id)
+		}
+	}
+	for _, id := range skipped {
+		if len(picked) == m {
+			break
+		}
+		picked = append(picked, id)
+	}
+	return picked
+}
+
+// Delete removes rawURL from the results. Once more than half of the graph
+// is deleted it is rebuilt from the live vectors.
+func (ix *ANNIndex) Delete(rawURL string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.delete(rawURL)
+	if ix.deleted > len(ix.ids) {
+		ix.compact()
+	}
+}
+
+func (ix *ANNIndex) delete(rawURL string) {
+	id, ok := ix.ids[rawURL]
+	if !ok {
+		return
+	}
+	delete(ix.ids, rawURL)
+	ix.nodes[id].Deleted = true
+	ix.deleted++
+}
+
+// compact rebuilds the graph without deleted nodes.
+func (ix *ANNIndex) compact() {
+	old := ix.nodes
+	ix.nodes, ix.ids, ix.entry, ix.top, ix.deleted = nil, make(map[string]int), -1, 0, 0
+	for _, n := range old {
+		if !n.Deleted {
+			ix.insert(n.URL, n.Vec)
+		}
+	}
+}
+
+// Sync makes the index hold exactly the embeddings of cache, and reports
+// whether anything changed.
+func (ix *ANNIndex) Sync(cache map[string]DataContext) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	changed := false
+	for u := range ix.ids {
+		if _, ok := cache[u]; !ok {
+			ix.delete(u)
+			changed = true
+		}
+	}
+	urls := make([]string, 0, len(cache))
+	for u := range cache {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls) // insert in a stable order so the graph is reproducible
+	for _, u := range urls {
+		vec, err := unit(cache[u].Embedding)
+		if err != nil {
+			if _, ok := ix.ids[u]; ok {
+				ix.delete(u)
+				changed = true
+			}
+			continue
+		}
+		if id, ok := ix.ids[u]; ok {
+			if sameVec(ix.nodes[id].Vec, vec) {
+				continue
+			}
+			ix.delete(u)
+		}
+		ix.insert(u, vec)
+		changed = true
+	}
+	if ix.deleted > len(ix.ids) {
+		ix.compact()
+	}
+	return changed
+}
+
+func sameVec(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the k vectors most similar to query, most similar first.
+// ef is the size of the candidate list; it is raised to k if smaller.
+func (ix *ANNIndex) Search(query []float64, k, ef int) []ANNHit {
+	q, err := unit(query)
+	if err != nil || k <= 0 {
+		return nil
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if ix.entry < 0 || len(ix.ids) == 0 {
+		return nil
+	}
+	ef = max(ef, k)
+	ep := []int{ix.entry}
+	for l := ix.top; l > 0; l-- {
+		ep = []int{ix.searchLayer(q, ep, 1, l)[0].id}
+	}
+	var hits []ANNHit
+	for _, c := range ix.searchLayer(q, ep, ef+min(ix.deleted, ef), 0) {
+		if ix.nodes[c.id].Deleted {
+			continue
+		}
+		hits = append(hits, ANNHit{URL: ix.nodes[c.id].URL, Similarity: 1 - c.d})
+		if len(hits) == k {
+			break
+		}
+	}
+	return hits
+}
+
+// annCand is a node and its distance to the query.
+type annCand struct {
+	id int
+	d  float64
+}
+
+// searchLayer is the best-first search of HNSW on one layer: it returns up to
+// ef nodes closest to q reachable from the entry points, nearest first.
+func (ix *ANNIndex) searchLayer(q []float64, entry []int, ef, l int) []annCand {
+	visited := make(map[int]bool, ef*4)
+	var cands nearHeap // to expand, nearest on top
+	var found farHeap  // best so far, farthest on top
+	for _, id := range entry {
+		visited[id] = true
+		c := annCand{id: id, d: ix.dist(q, id)}
+		heap.Push(&cands, c)
+		heap.Push(&found, c)
+	}
+	for cands.Len() > 0 {
+		c := heap.Pop(&cands).(annCand)
+		if found.Len() >= ef && c.d > found[0].d {
+			break
+		}
+		if l >= len(ix.nodes[c.id].Links) {
+			continue
+		}
+		for _, nb := range ix.nodes[c.id].Links[l] {
+			id := int(nb)
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			d := ix.dist(q, id)
+			if found.Len() < ef || d < found[0].d {
+				heap.Push(&cands, annCand{id: id, d: d})
+				heap.Push(&found, annCand{id: id, d: d})
+				if found.Len() > ef {
+					heap.Pop(&found)
+				}
+			}
+		}
+	}
+	out := make([]annCand, found.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(&found).(annCand)
+	}
+	return out
+}
+
+type nearHeap []annCand
+
+func (h nearHeap) Len() int            { return len(h) }
+func (h nearHeap) Less(i, j int) bool  { return h[i].d < h[j].d }
+func (h nearHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nearHeap) Push(x interface{}) { *h = append(*h, x.(annCand)) }
+func (h *nearHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type farHeap []annCand
+
+func (h farHeap) Len() int            { return len(h) }
+func (h farHeap) Less(i, j int) bool  { return h[i].d > h[j].d }
+func (h farHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *farHeap) Push(x interface{}) { *h = append(*h, x.(annCand)) }
+func (h *farHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// openANN loads the index stored alongside the cache, or starts a new one,
+// and brings it up to date with cache. The index is written back if it
+// changed.
+func openANN(cache map[string]DataContext) *ANNIndex {
+	ix, err := LoadANN(annPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("rebuilding nearest neighbour index: %v", err)
+		}
+		ix = NewANNIndex()
+	}
+	if ix.Sync(cache) {
+		if err := ix.Save(annPath()); err != nil {
+			log.Printf("failed to write nearest neighbour index to %s: %v", annPath(), err)
+		}
+	}
+	return ix
+}
@@ -0,0 +1,141 @@
+package crawler
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func randomCache(n, dim int, seed int64) map[string]DataContext {
+	rng := rand.New(rand.NewSource(seed))
+	cache := make(map[string]DataContext, n)
+	for i := 0; i < n; i++ {
+		v := make([]float64, dim)
+		for j := range v {
+			v[j] = rng.NormFloat64()
+		}
+		cache[fmt.Sprintf("https://example.com/%d", i)] = DataContext{Embedding: v}
+	}
+	return cache
+}
+
+// exactTop returns the k URLs of cache most similar to q.
+func exactTop(cache map[string]DataContext, q []float64, k int) []string {
+	type scored struct {
+		url string
+		sim float64
+	}
+	var all []scored
+	for u, ctx := range cache {
+		c, _ := Cosine(q, ctx.Embedding)
+		all = append(all, scored{u, c})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].sim > all[j].sim })
+	urls := make([]string, k)
+	for i := range urls {
+		urls[i] = all[i].url
+	}
+	return urls
+}
+
+func TestANNIndexRecall(t *testing.T) {
+	cache := randomCache(3000, 24, 1)
+	ix := NewANNIndex()
+	ix.Sync(cache)
+	if ix.Len() != len(cache) {
+		t.Fatalf("indexed %d of %d vectors", ix.Len(), len(cache))
+	}
+
+	queries := randomCache(50, 24, 2)
+	found, total := 0, 0
+	for _, q := range queries {
+		want := map[string]bool{}
+		for _, u := range exactTop(cache, q.Embedding, 10) {
+			want[u] = true
+		}
+		hits := ix.Search(q.Embedding, 10, 64)
+		for i, h := range hits {
+			if want[h.URL] {
+				found++
+			}
+			if i > 0 && h.Similarity > hits[i-1].Similarity {
+				t.Fatalf("hits not ordered by similarity: %v", hits)
+			}
+		}
+		total += len(want)
+	}
+	if recall := float64(found) / float64(total); recall < 0.9 {
+		t.Fatalf("recall@10 = %.2f, want at least 0.9", recall)
+	}
+}
+
+func TestANNIndexDeleteAndPersist(t *testing.T) {
+	cache := randomCache(500, 8, 3)
+	ix := NewANNIndex()
+	ix.Sync(cache)
+
+	target := "https://example.com/7"
+	q := cache[target].Embedding
+	if hits := ix.Search(q, 1, 32); len(hits) != 1 || hits[0].URL != target {
+		t.Fatalf("nearest to its own vector: %v", hits)
+	}
+	ix.Delete(target)
+	for _, h := range ix.Search(q, 20, 32) {
+		if h.URL == target {
+			t.Fatal("deleted vector returned")
+		}
+	}
+
+	p := filepath.Join(t.TempDir(), "data.hnsw")
+	if err := ix.Save(p); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadANN(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != ix.Len() {
+		t.Fatalf("loaded %d vectors, saved %d", loaded.Len(), ix.Len())
+	}
+	other := cache["https://example.com/8"].Embedding
+	if a, b := ix.Search(other, 5, 32), loaded.Search(other, 5, 32); fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Fatalf("results differ after reload:\n%v\n%v", a, b)
+	}
+
+	// Sync restores the deleted entry and drops ones no longer cached.
+	delete(cache, "https://example.com/9")
+	if !loaded.Sync(cache) {
+		t.Fatal("Sync reported no change")
+	}
+	if loaded.Len() != len(cache) || loaded.Sync(cache) {
+		t.Fatalf("index holds %d vectors, cache %d", loaded.Len(), len(cache))
+	}
+	if hits := loaded.Search(q, 1, 32); len(hits) != 1 || hits[0].URL != target {
+		t.Fatalf("re-inserted vector not found: %v", hits)
+	}
+}
+
+func TestRankCachedUsesIndex(t *testing.T) {
+	cache := randomCache(200, 8, 4)
+	ix := NewANNIndex()
+	ix.Sync(cache)
+	// Drop one entry from the index only, to tell which path answered.
+	q := cache["https://example.com/3"].Embedding
+	ix.Delete("https://example.com/3")
+	m := &Manager{CachedURLEmbeddings: cache, ann: ix}
+
+	old := annExactBelow
+	t.Cleanup(func() { annExactBelow = old })
+
+	annExactBelow = 1000
+	if got := m.RankCached(q, 1); got[0].Url != "https://example.com/3" {
+		t.Fatalf("exact search: got %s", got[0].Url)
+	}
+	annExactBelow = 100
+	got := m.RankCached(q, 3)
+	if len(got) != 3 || got[0].Url == "https://example.com/3" || got[0].CosineSimilarity <= got[1].CosineSimilarity {
+		t.Fatalf("indexed search: %+v", got)
+	}
+}
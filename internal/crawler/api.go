@@ -4,17 +4,19 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strings"
 	"sync"
+	"time"
 )
 
-var dataPath = "/Users/thorbthorb/Downloads/geospatial-web-scraper/data.gob"
-var findLinksLogPath = "/Users/thorbthorb/Downloads/geospatial-web-scraper/logs/findLinks.log"
+// dataPath and findLinksLogPath locate the embedding cache and the crawl log.
+// They default to the XDG cache and state directories and are replaced by
+// Config.Apply.
+var dataPath = activeConfig.CachePath
+var findLinksLogPath = activeConfig.LogPath
 
 // GetBatchedEmbeddings sends a slice of strings to the local embedding service
 // and returns the resulting embeddings. The function performs a single HTTP
@@ -22,9 +24,9 @@ var findLinksLogPath = "/Users/thorbthorb/Downloads/geospatial-web-scraper/logs/
 func GetBatchedEmbeddings(texts []string) (EmbeddingResponse, error) {
 	var buf bytes.Buffer
 	newPayload := TextPayload{Texts: texts}
-	fmt.Println("Batch-Payload: ")
+	log.Println("Batch-Payload: ")
 	for _, item := range newPayload.Texts {
-		fmt.Println("	", item)
+		log.Println("	", item)
 	}
 	if err := json.NewEncoder(&buf).Encode(newPayload); err != nil {
 		log.Printf("	Error occured while encoding data JSON payload: %v", err)
@@ -32,7 +34,7 @@ func GetBatchedEmbeddings(texts []string) (EmbeddingResponse, error) {
 	}
 
 	resp, err := http.Post(
-		"http://localhost:8000/embed",
+		embedEndpoint,
 		"application/json",
 		&buf,
 	)
@@ -56,15 +58,15 @@ func WriteToLog(filepath string) (*os.File, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error opening log file: %w", err)
 	}
-	log.SetOutput(logFile)
+	log.SetOutput(&redactWriter{w: logFile})
 	return logFile, nil
 }
 
 // WriteToGob serializes the provided data value to a gob file at the given
-// path. Existing data is appended to, allowing the cache to persist between
-// runs.
+// path. The file is replaced, so it always holds exactly one value and the
+// cache read back by Init is the one written last.
 func WriteToGob(filepath string, data interface{}) error {
-	file, err := os.OpenFile(filepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filepath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("error opening log file: %w", err)
 	}
@@ -104,7 +106,7 @@ func GenerateEmbeddings() ([][]float64, error) {
 	}
 
 	resp, err := http.Post(
-		"http://localhost:8000/embed",
+		embedEndpoint,
 		"application/json",
 		&buf,
 	)
@@ -122,7 +124,8 @@ func GenerateEmbeddings() ([][]float64, error) {
 // Init prepares the Manager by loading or creating the embedding cache stored
 // on disk. If no cache exists, all seed URLs are embedded and written to the
 // gob file. Loaded or generated embeddings are stored in
-// m.CachedURLEmbeddings.
+// m.CachedURLEmbeddings and indexed for lexical and nearest neighbour search,
+// and the page cache of earlier crawls is loaded.
 func (m *Manager) Init() {
 	data := make(map[string]DataContext)
 	//data is a map of URL : embedding
@@ -148,23 +151,62 @@ func (m *Manager) Init() {
 		}
 		WriteToGob(dataPath, data)
 		m.CachedURLEmbeddings = data
+		m.lexical = NewLexicalIndex(data)
+		m.ann = openANN(data)
+		m.pages = openPageCache()
 		return
 	}
 	//read searchFrom .gob file
-	file, err := os.Open(dataPath)
+	data, err := LoadCache(dataPath)
 	if err != nil {
 		log.Fatalf("An error occured while reading the .gob file at %s: %v", dataPath, err)
 	}
-	defer file.Close()
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
-		log.Fatalf("An error occured while decoding .gob file to cache: %v", err)
-	}
 	m.CachedURLEmbeddings = data
+	m.lexical = NewLexicalIndex(data)
+	m.ann = openANN(data)
+	m.pages = openPageCache()
 	log.Println("Cached URL-embeddings loaded")
 }
 
-// Close stores any newly discovered URLs and persists the cache.
+// LoadCache reads the embedding cache stored at p.
+func LoadCache(p string) (map[string]DataContext, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data := make(map[string]DataContext)
+	if err := gob.NewDecoder(file).Decode(&data); err != nil {
+		return nil, fmt.Errorf("decoding cache %s: %w", p, err)
+	}
+	return data, nil
+}
+
+// Close stores any newly discovered URLs and persists the cache, its nearest
+// neighbour index and the page cache.
+func (m *Manager) Close(newURLs []WebNode) {
+	m.Learn(newURLs)
+
+	// By now the consumer has flushed everything and exited.
+	// Persist the whole cache.
+	if err := WriteToGob(dataPath, m.CachedURLEmbeddings); err != nil {
+		log.Printf("failed to write cache to %s: %v", dataPath, err)
+	}
+	if m.ann != nil {
+		if err := m.ann.Save(annPath()); err != nil {
+			log.Printf("failed to write nearest neighbour index to %s: %v", annPath(), err)
+		}
+	}
+	if m.pages != nil {
+		if err := m.pages.Save(pagesPath()); err != nil {
+			log.Printf("failed to write page cache to %s: %v", pagesPath(), err)
+		}
+	}
+}
+
+// Learn embeds newly discovered URLs into m.CachedURLEmbeddings, records when
+// each dataset was found and last seen, and attaches the extents recorded in
+// the manifest, without writing the cache to disk.
 //
 //  1. Each producer goroutine decides whether a URL is new.
 //  2. All brand-new URLs go down a channel to a single consumer.
@@ -172,13 +214,14 @@ func (m *Manager) Init() {
 //     GetBatchedEmbeddings once per batch.
 //  4. It writes the finished embeddings into m.CachedURLEmbeddings
 //     under a mutex so there are no data races.
-//  5. When all producers are done the channel is closed, any
-//     leftover batch is flushed, and the whole cache is written to
-//     data.gob.
-func (m *Manager) Close(newURLs []WebNode) {
+//  5. When all producers are done the channel is closed and any
+//     leftover batch is flushed.
+func (m *Manager) Learn(newURLs []WebNode) {
 	const batchSize = 50
 
+	now := time.Now().UTC()
 	embedCh := make(chan WebNode, batchSize)
+	flushed := make(chan struct{})
 	var (
 		wgProducers sync.WaitGroup
 		mu          sync.Mutex // protects m.CachedURLEmbeddings
@@ -188,6 +231,7 @@ func (m *Manager) Close(newURLs []WebNode) {
 	// 1. CONSUMER – runs once, processes batches from embedCh
 	//------------------------------------------------------------------
 	go func() {
+		defer close(flushed)
 		var (
 			nodes []WebNode // URLs waiting for an embedding
 			descs []string  // matching descriptions
@@ -210,6 +254,9 @@ func (m *Manager) Close(newURLs []WebNode) {
 				m.CachedURLEmbeddings[n.Url] = DataContext{
 					Description: n.context.Description,
 					Embedding:   emb.Embeddings[i],
+					Extent:      n.context.Extent,
+					Found:       now,
+					Checked:     now,
 				}
 			}
 			mu.Unlock()
@@ -238,7 +285,24 @@ func (m *Manager) Close(newURLs []WebNode) {
 			defer wgProducers.Done()
 
 			mu.Lock()
-			_, seen := m.CachedURLEmbeddings[n.Url]
+			ctx, seen := m.CachedURLEmbeddings[n.Url]
+			switch {
+			case seen && n.context.Found.IsZero() && IsDataset(n.Url, ctx):
+				// found again by this crawl rather than taken from the
+				// catalog: the dataset is still there
+				if ctx.Found.IsZero() {
+					ctx.Found = now
+				}
+				ctx.Checked = now
+				m.CachedURLEmbeddings[n.Url] = ctx
+			case seen:
+			case n.context.Embedding != nil:
+				// already embedded while ranking
+				ctx = n.context
+				ctx.Found, ctx.Checked = now, now
+				m.CachedURLEmbeddings[n.Url] = ctx
+				seen = true
+			}
 			mu.Unlock()
 			if seen {
 				return
@@ -253,72 +317,105 @@ func (m *Manager) Close(newURLs []WebNode) {
 	//------------------------------------------------------------------
 	wgProducers.Wait() // wait until all sends are finished
 	close(embedCh)     // tells consumer to finish
+	<-flushed
 
-	// By now the consumer must have flushed everything and exited.
-	// Persist the whole cache.
-	if err := WriteToGob(dataPath, m.CachedURLEmbeddings); err != nil {
-		log.Printf("failed to write cache to %s: %v", dataPath, err)
-	}
-	for _, context := range m.CachedURLEmbeddings {
-		fmt.Println(" extract description: ", context.Description)
-	}
-}
-
-// Run executes the CLI application.
-func Run() {
-	logFile, logErr := WriteToLog(findLinksLogPath)
-	if logErr != nil {
-		log.Fatalf("An error occured while making log-file: %v", logErr)
-	}
-	defer logFile.Close()
-	// Flags
-	searchPtr := flag.String("s", "", "Search query for dataset. Required.")
-	downloadDir := flag.String("download", "", "Directory to download datasets to. If empty, only prints URLs.")
-	noSec := flag.Bool("nosec", false, "Disable security sandboxing (enabled by default).")
-
-	flag.Parse()
-
-	// Validate search query
-	if strings.TrimSpace(*searchPtr) == "" {
-		fmt.Println("ERROR: Search query (-s) is required.")
-		flag.Usage()
-		os.Exit(1)
+	for _, n := range newURLs {
+		ctx, ok := m.CachedURLEmbeddings[n.Url]
+		if !ok {
+			continue
+		}
+		if m.lexical != nil && !m.lexical.Has(n.Url) {
+			m.lexical.Add(n.Url, ctx)
+		}
+		if m.ann != nil {
+			if err := m.ann.Insert(n.Url, ctx.Embedding); err != nil {
+				log.Printf("not indexing for nearest neighbour search: %v", err)
+			}
+		}
 	}
 
-	mg := Manager{
-		secure:       *noSec,
-		downloadPath: downloadDir,
-		searchQuery:  searchPtr,
-		downloadURLs: []WebNode{},
-		searchFrom:   PublicGeospatialDataSeeds,
-		linkChan:     make(chan struct{}, 1),
-		smTokens:     make(chan struct{}, 40),
-		dlTokens:     make(chan struct{}, 40),
-		worklist:     make(chan []WebNode),
-		done:         make(chan bool),
-		seen:         make(map[string]bool),
-	}
-	mg.Init()
-	// Begin search
-	var downloadableLinks []WebNode
-	fmt.Printf("Searching for: \"%s\"\n", *searchPtr)
-
-	if *downloadDir != "" {
-		if _, err := os.Stat(*downloadDir); os.IsNotExist(err) {
-			if err := os.MkdirAll(*downloadDir, 0755); err != nil {
-				log.Fatalf("Failed to create directory %s: %v", *downloadDir, err)
+	// Attach the extents read from downloaded files so later searches can
+	// check coverage without downloading again.
+	if m.manifest != nil {
+		for i := range m.manifest.Entries {
+			e := &m.manifest.Entries[i]
+			ctx, ok := m.CachedURLEmbeddings[e.SourceURL]
+			if !ok {
+				continue
+			}
+			if box, ok := e.Extent(); ok {
+				ctx.Extent = &box
+				m.CachedURLEmbeddings[e.SourceURL] = ctx
 			}
 		}
 	}
+}
 
-	downloadableLinks = mg.FindLinks()
-	log.Printf("For searchQuery '%v'", *searchPtr)
-	log.Printf("	found %v URLs:", len(downloadableLinks))
+// Options configures a Manager created with NewManager.
+type Options struct {
+	Query       string      // search query
+	DownloadDir string      // directory downloads are saved to; empty to only list URLs
+	Secure      bool        // run downloads in the sandboxed helper
+	Inspect     bool        // list and classify downloaded zip archives
+	Extract     bool        // safely extract downloaded zip archives
+	Args        []string    // command line recorded in the manifest
+	OnEvent     func(Event) // receives candidates and progress as the crawl runs
+	// CatalogFirst answers from datasets found by earlier crawls and crawls
+	// only when too few of them match the query or they are stale.
+	CatalogFirst bool
+	// Checkpoint periodically saves the crawl state so an interrupted
+	// session can be resumed.
+	Checkpoint bool
+}
 
-	for _, node := range downloadableLinks {
-		log.Println("		URL: ", node.Url)
+// NewManager returns a Manager ready for Init and FindLinks, with a fresh
+// session manifest. Its limits come from the active configuration.
+func NewManager(opts Options) *Manager {
+	query, dir := opts.Query, opts.DownloadDir
+	now := time.Now().UTC()
+	session := now.Format("20060102T150405Z")
+	var ckPath string
+	if opts.Checkpoint {
+		ckPath = checkpointPath(session)
+	}
+	return &Manager{
+		secure:          opts.Secure,
+		downloadPath:    &dir,
+		searchQuery:     &query,
+		downloadURLs:    []WebNode{},
+		searchFrom:      PublicGeospatialDataSeeds,
+		linkChan:        make(chan struct{}, 1),
+		smTokens:        make(chan struct{}, activeConfig.CrawlWorkers),
+		dlTokens:        make(chan struct{}, activeConfig.DownloadWorkers),
+		maxCrawl:        activeConfig.MaxCrawl,
+		topSeeds:        activeConfig.TopSeeds,
+		worklist:        make(chan []WebNode),
+		done:            make(chan bool),
+		seen:            make(map[string]bool),
+		inspectArchives: opts.Inspect || opts.Extract,
+		extractArchives: opts.Extract,
+		onEvent:         opts.OnEvent,
+		catalogFirst:    opts.CatalogFirst,
+		checkpointPath:  ckPath,
+		manifest: &Manifest{
+			Session:     session,
+			Query:       query,
+			Args:        opts.Args,
+			DownloadDir: dir,
+			StartedAt:   now,
+		},
 	}
-	mg.Close(downloadableLinks)
-	return
+}
 
+// EmbedQuery sends a single text to the embedding service and returns its
+// embedding.
+func EmbedQuery(text string) ([]float64, error) {
+	res, err := GetBatchedEmbeddings([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Embeddings) == 0 {
+		return nil, fmt.Errorf("embedding service returned no embedding for %q", text)
+	}
+	return res.Embeddings[0], nil
 }
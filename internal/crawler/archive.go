@@ -0,0 +1,353 @@
+package crawler
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ArchiveReport summarises the geodata found inside a zip archive. It is built
+// from the central directory only, so inspecting a large archive is cheap.
+type ArchiveReport struct {
+	Path         string           `json:"path"`
+	Entries      int              `json:"entries"`
+	TotalSize    uint64           `json:"total_size"`
+	Shapefiles   []ShapefileSet   `json:"shapefiles,omitempty"`
+	Geodatabases []string         `json:"geodatabases,omitempty"`
+	Rasters      []string         `json:"rasters,omitempty"`
+	Vectors      []string         `json:"vectors,omitempty"`
+	PointClouds  []string         `json:"point_clouds,omitempty"`
+	Nested       []*ArchiveReport `json:"nested,omitempty"`
+	Extracted    []string         `json:"extracted,omitempty"`
+	Warnings     []string         `json:"warnings,omitempty"`
+}
+
+// ShapefileSet groups the sidecar files that make up one shapefile.
+type ShapefileSet struct {
+	Base     string   `json:"base"`
+	Parts    []string `json:"parts"`
+	Complete bool     `json:"complete"` // .shp, .shx and .dbf are all present
+}
+
+// ExtractLimits bounds what ExtractArchive is willing to write, protecting
+// against zip bombs and runaway nesting.
+type ExtractLimits struct {
+	MaxFiles     int     // maximum number of files written in total
+	MaxFileSize  int64   // maximum uncompressed size of a single entry
+	MaxTotalSize int64   // maximum uncompressed size of all entries together
+	MaxRatio     float64 // maximum uncompressed/compressed ratio per entry
+	MaxDepth     int     // maximum nesting depth of archives inside archives
+}
+
+// DefaultExtractLimits are generous enough for national-scale shapefile and
+// GeoTIFF bundles while still stopping obvious zip bombs.
+var DefaultExtractLimits = ExtractLimits{
+	MaxFiles:     10_000,
+	MaxFileSize:  20 << 30,
+	MaxTotalSize: 50 << 30,
+	MaxRatio:     200,
+	MaxDepth:     3,
+}
+
+// maxNestedInspectSize caps how large a nested archive may be for it to be
+// read into memory during inspection.
+const maxNestedInspectSize = 256 << 20
+
+var shapefileParts = map[string]bool{
+	".shp": true, ".shx": true, ".dbf": true, ".prj": true, ".cpg": true,
+	".sbn": true, ".sbx": true, ".qix": true, ".shp.xml": true,
+}
+
+var archiveRasterExts = map[string]bool{
+	".tif": true, ".tiff": true, ".img": true, ".jp2": true, ".asc": true,
+	".dem": true, ".vrt": true, ".nc": true, ".grib": true, ".grb2": true,
+	".hdf": true, ".h5": true, ".bil": true, ".adf": true,
+}
+
+var archiveVectorExts = map[string]bool{
+	".geojson": true, ".json": true, ".kml": true, ".kmz": true, ".gpkg": true,
+	".gml": true, ".csv": true, ".sqlite": true, ".pbf": true, ".topojson": true,
+}
+
+var archivePointCloudExts = map[string]bool{
+	".las": true, ".laz": true, ".e57": true,
+}
+
+// IsArchive reports whether name looks like a zip archive.
+func IsArchive(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".zip" || ext == ".kmz"
+}
+
+// InspectArchive lists the archive at p and classifies the geodata inside it
+// without extracting anything to disk.
+func InspectArchive(p string) (*ArchiveReport, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, fmt.Errorf("opening archive %s: %w", p, err)
+	}
+	defer zr.Close()
+	return inspectZip(&zr.Reader, p, 0), nil
+}
+
+func inspectZip(zr *zip.Reader, name string, depth int) *ArchiveReport {
+	report := &ArchiveReport{Path: name}
+	shapefiles := make(map[string][]string)
+	gdbs := make(map[string]bool)
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			if strings.HasSuffix(strings.ToLower(strings.TrimSuffix(f.Name, "/")), ".gdb") {
+				gdbs[strings.TrimSuffix(f.Name, "/")] = true
+			}
+			continue
+		}
+		report.Entries++
+		report.TotalSize += f.UncompressedSize64
+		if !filepath.IsLocal(f.Name) {
+			report.Warnings = append(report.Warnings, "unsafe path: "+f.Name)
+		}
+
+		lower := strings.ToLower(f.Name)
+		if gdb := gdbRoot(f.Name); gdb != "" {
+			gdbs[gdb] = true
+			continue
+		}
+		ext := path.Ext(lower)
+		if strings.HasSuffix(lower, ".shp.xml") {
+			ext = ".shp.xml"
+		}
+		switch {
+		case shapefileParts[ext]:
+			base := f.Name[:len(f.Name)-len(ext)]
+			shapefiles[base] = append(shapefiles[base], ext)
+		case archiveRasterExts[ext]:
+			report.Rasters = append(report.Rasters, f.Name)
+		case archivePointCloudExts[ext]:
+			report.PointClouds = append(report.PointClouds, f.Name)
+		case archiveVectorExts[ext]:
+			report.Vectors = append(report.Vectors, f.Name)
+		case ext == ".zip":
+			report.Nested = append(report.Nested, inspectNested(f, depth+1))
+		}
+	}
+
+	for base, parts := range shapefiles {
+		set := ShapefileSet{Base: base, Parts: parts}
+		sort.Strings(set.Parts)
+		has := make(map[string]bool)
+		for _, p := range parts {
+			has[p] = true
+		}
+		set.Complete = has[".shp"] && has[".shx"] && has[".dbf"]
+		if !has[".shp"] {
+			continue // stray sidecars such as a lone .dbf table
+		}
+		if !set.Complete {
+			report.Warnings = append(report.Warnings, "incomplete shapefile: "+base)
+		}
+		report.Shapefiles = append(report.Shapefiles, set)
+	}
+	sort.Slice(report.Shapefiles, func(i, j int) bool { return report.Shapefiles[i].Base < report.Shapefiles[j].Base })
+	for gdb := range gdbs {
+		report.Geodatabases = append(report.Geodatabases, gdb)
+	}
+	sort.Strings(report.Geodatabases)
+	return report
+}
+
+// inspectNested reads a zip stored inside another zip into memory and
+// inspects it. Oversized or deeply nested archives are reported but skipped.
+func inspectNested(f *zip.File, depth int) *ArchiveReport {
+	report := &ArchiveReport{Path: f.Name}
+	if depth > DefaultExtractLimits.MaxDepth {
+		report.Warnings = append(report.Warnings, "nesting too deep, not inspected")
+		return report
+	}
+	if f.UncompressedSize64 > maxNestedInspectSize {
+		report.Warnings = append(report.Warnings, "nested archive too large to inspect in memory")
+		return report
+	}
+	rc, err := f.Open()
+	if err != nil {
+		report.Warnings = append(report.Warnings, err.Error())
+		return report
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxNestedInspectSize+1))
+	if err != nil || len(data) > maxNestedInspectSize {
+		report.Warnings = append(report.Warnings, "nested archive could not be read")
+		return report
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		report.Warnings = append(report.Warnings, err.Error())
+		return report
+	}
+	return inspectZip(zr, f.Name, depth)
+}
+
+// gdbRoot returns the enclosing .gdb directory of an archive entry, if any.
+func gdbRoot(name string) string {
+	parts := strings.Split(name, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if strings.HasSuffix(strings.ToLower(parts[i]), ".gdb") {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	return ""
+}
+
+// Summary returns a one-line, human-readable description of the report that
+// can be appended to a result's description.
+func (r *ArchiveReport) Summary() string {
+	var parts []string
+	if n := len(r.Shapefiles); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d shapefile(s)", n))
+	}
+	if n := len(r.Geodatabases); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d file geodatabase(s)", n))
+	}
+	if n := len(r.Rasters); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d raster(s)", n))
+	}
+	if n := len(r.Vectors); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d vector file(s)", n))
+	}
+	if n := len(r.PointClouds); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d point cloud(s)", n))
+	}
+	if n := len(r.Nested); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d nested archive(s)", n))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no recognised geodata")
+	}
+	return fmt.Sprintf("Archive contents (%d files, %d bytes uncompressed): %s.", r.Entries, r.TotalSize, strings.Join(parts, ", "))
+}
+
+// ErrExtractLimit is returned when an archive exceeds the configured
+// ExtractLimits.
+var ErrExtractLimit = errors.New("archive exceeds extraction limits")
+
+// extractState carries the budget shared by an archive and the archives nested
+// inside it.
+type extractState struct {
+	limits  ExtractLimits
+	files   int
+	written int64
+	paths   []string
+}
+
+// ExtractArchive safely extracts the zip at src into dest. Entries that would
+// escape dest, symlinks, and entries exceeding limits are rejected. Archives
+// found inside the archive are extracted into a directory named after them,
+// up to limits.MaxDepth. The paths of all written files are returned.
+func ExtractArchive(src, dest string, limits ExtractLimits) ([]string, error) {
+	st := &extractState{limits: limits}
+	err := st.extract(src, dest, 0)
+	return st.paths, err
+}
+
+func (st *extractState) extract(src, dest string, depth int) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("opening archive %s: %w", src, err)
+	}
+	defer zr.Close()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		if !filepath.IsLocal(f.Name) || strings.Contains(f.Name, `\`) {
+			return fmt.Errorf("%w: entry %q escapes the destination", ErrExtractLimit, f.Name)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(f.Name))
+		mode := f.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: entry %q is a symlink", ErrExtractLimit, f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if st.files++; st.limits.MaxFiles > 0 && st.files > st.limits.MaxFiles {
+			return fmt.Errorf("%w: more than %d files", ErrExtractLimit, st.limits.MaxFiles)
+		}
+		if err := st.extractFile(f, target); err != nil {
+			return err
+		}
+
+		if IsArchive(f.Name) && strings.EqualFold(path.Ext(f.Name), ".zip") {
+			if depth+1 > st.limits.MaxDepth {
+				continue // keep the nested archive as a plain file
+			}
+			nestedDest := strings.TrimSuffix(target, filepath.Ext(target))
+			if err := st.extract(target, nestedDest, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extractFile writes a single entry, counting the bytes actually decompressed
+// rather than trusting the sizes recorded in the archive header.
+func (st *extractState) extractFile(f *zip.File, target string) error {
+	limit := int64(-1) // no limit
+	if st.limits.MaxFileSize > 0 {
+		limit = st.limits.MaxFileSize
+	}
+	if st.limits.MaxTotalSize > 0 {
+		rest := st.limits.MaxTotalSize - st.written
+		if rest <= 0 {
+			return fmt.Errorf("%w: archive decompresses beyond %d bytes", ErrExtractLimit, st.limits.MaxTotalSize)
+		}
+		if limit < 0 || rest < limit {
+			limit = rest
+		}
+	}
+	if ratioLimit := int64(st.limits.MaxRatio * float64(f.CompressedSize64)); st.limits.MaxRatio > 0 && f.CompressedSize64 > 0 && (limit < 0 || ratioLimit < limit) {
+		limit = ratioLimit
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", target, err)
+	}
+	defer out.Close()
+
+	var r io.Reader = rc
+	if limit >= 0 {
+		r = io.LimitReader(rc, limit+1)
+	}
+	n, err := io.Copy(out, r)
+	st.written += n
+	if err != nil {
+		return fmt.Errorf("extracting %s: %w", f.Name, err)
+	}
+	if limit >= 0 && n > limit {
+		return fmt.Errorf("%w: entry %q decompresses beyond %d bytes", ErrExtractLimit, f.Name, limit)
+	}
+	st.paths = append(st.paths, target)
+	return nil
+}
@@ -0,0 +1,153 @@
+package crawler
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeZip creates a zip archive at p containing the given name/content pairs.
+func writeZip(t *testing.T, p string, files map[string][]byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write zip: %v", err)
+	}
+}
+
+func TestInspectArchive(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "inner.zip")
+	writeZip(t, nested, map[string][]byte{"dem.tif": []byte("II*\x00")})
+	inner, _ := os.ReadFile(nested)
+
+	p := filepath.Join(dir, "bundle.zip")
+	writeZip(t, p, map[string][]byte{
+		"roads/roads.shp":                []byte("shp"),
+		"roads/roads.shx":                []byte("shx"),
+		"roads/roads.dbf":                []byte("dbf"),
+		"roads/roads.prj":                []byte("prj"),
+		"parcels.shp":                    []byte("shp"),
+		"hydro.gdb/a00000001.gdbtable":   []byte("gdb"),
+		"hydro.gdb/a00000001.gdbtablx":   []byte("gdb"),
+		"imagery/tile_01.tif":            []byte("tif"),
+		"points.geojson":                 []byte("{}"),
+		"tiles/inner.zip":                inner,
+		"docs/readme.txt":                []byte("hi"),
+		"pointcloud/USGS_LPC_tile_1.laz": []byte("laz"),
+	})
+
+	report, err := InspectArchive(p)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if report.Entries != 12 {
+		t.Fatalf("expected 12 entries, got %d", report.Entries)
+	}
+	if len(report.Shapefiles) != 2 || !report.Shapefiles[1].Complete || report.Shapefiles[0].Complete {
+		t.Fatalf("unexpected shapefiles %+v", report.Shapefiles)
+	}
+	if len(report.Geodatabases) != 1 || report.Geodatabases[0] != "hydro.gdb" {
+		t.Fatalf("unexpected geodatabases %v", report.Geodatabases)
+	}
+	if len(report.Rasters) != 1 || len(report.Vectors) != 1 || len(report.PointClouds) != 1 {
+		t.Fatalf("unexpected classification %+v", report)
+	}
+	if len(report.Nested) != 1 || len(report.Nested[0].Rasters) != 1 {
+		t.Fatalf("nested archive not inspected: %+v", report.Nested)
+	}
+	if !strings.Contains(report.Summary(), "2 shapefile(s)") {
+		t.Fatalf("unexpected summary %q", report.Summary())
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "inner.zip")
+	writeZip(t, nested, map[string][]byte{"dem.tif": []byte("II*\x00")})
+	inner, _ := os.ReadFile(nested)
+	p := filepath.Join(dir, "bundle.zip")
+	writeZip(t, p, map[string][]byte{
+		"a/roads.shp":     []byte("shp"),
+		"tiles/inner.zip": inner,
+	})
+
+	dest := filepath.Join(dir, "out")
+	paths, err := ExtractArchive(p, dest, DefaultExtractLimits)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("expected 3 extracted files, got %v", paths)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "tiles", "inner", "dem.tif")); err != nil {
+		t.Fatalf("nested archive not extracted: %v", err)
+	}
+}
+
+func TestExtractArchive_ZipSlip(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "evil.zip")
+	writeZip(t, p, map[string][]byte{"../../etc/evil": []byte("x")})
+	_, err := ExtractArchive(p, filepath.Join(dir, "out"), DefaultExtractLimits)
+	if !errors.Is(err, ErrExtractLimit) {
+		t.Fatalf("expected ErrExtractLimit, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "..", "etc", "evil")); err == nil {
+		t.Fatalf("zip slip wrote outside the destination")
+	}
+}
+
+func TestExtractArchive_RatioLimit(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "bomb.zip")
+	writeZip(t, p, map[string][]byte{"zeros.bin": make([]byte, 1<<20)})
+	limits := DefaultExtractLimits
+	limits.MaxRatio = 10
+	_, err := ExtractArchive(p, filepath.Join(dir, "out"), limits)
+	if !errors.Is(err, ErrExtractLimit) {
+		t.Fatalf("expected ErrExtractLimit, got %v", err)
+	}
+}
+
+// TestExtractArchive_TotalLimit extracts an archive whose first entry uses up
+// the total limit exactly; nothing after it may be written.
+func TestExtractArchive_TotalLimit(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "bundle.zip")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"a.bin", "b.bin"} {
+		w, _ := zw.Create(name)
+		w.Write(make([]byte, 1<<10))
+	}
+	zw.Close()
+	os.WriteFile(p, buf.Bytes(), 0644)
+
+	limits := DefaultExtractLimits
+	limits.MaxTotalSize = 1 << 10
+	limits.MaxRatio = 0
+	dest := filepath.Join(dir, "out")
+	_, err := ExtractArchive(p, dest, limits)
+	if !errors.Is(err, ErrExtractLimit) {
+		t.Fatalf("expected ErrExtractLimit, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "b.bin")); err == nil {
+		t.Fatalf("entry past the total limit was extracted")
+	}
+}
@@ -0,0 +1,337 @@
+package crawler
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// rrfK dampens the reciprocal rank fusion of lexical and vector results: a
+// document at rank r in a list contributes 1/(rrfK+r).
+const rrfK = 60
+
+// stopWords are left out of the lexical index.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "http": true, "https": true, "in": true, "is": true, "it": true,
+	"of": true, "on": true, "or": true, "that": true, "the": true, "this": true, "to": true,
+	"with": true, "www": true,
+}
+
+// genericTerms occur in the URLs and descriptions of nearly every dataset.
+// They are indexed, and rank entries that contain them, but a catalog entry
+// sharing only these with a query does not match it.
+var genericTerms = map[string]bool{
+	"data": true, "dataset": true, "datasets": true, "download": true, "downloads": true,
+	"file": true, "files": true, "gov": true, "org": true, "com": true, "zip": true,
+	"api": true, "open": true, "portal": true, "html": true, "index": true,
+}
+
+// specificTerms returns the terms of query that are not generic.
+func specificTerms(query string) string {
+	var terms []string
+	for _, t := range lexTerms(query) {
+		if !genericTerms[t] {
+			terms = append(terms, t)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// posting records how often a term occurs in one document.
+type posting struct {
+	doc int
+	tf  int
+}
+
+// LexicalIndex is an in-memory BM25 inverted index over the titles,
+// descriptions, keywords and URLs of catalog entries. It is safe for
+// concurrent use.
+type LexicalIndex struct {
+	mu       sync.RWMutex
+	ids      map[string]int // URL -> document number
+	urls     []string       // document number -> URL; "" once removed
+	lens     []int          // document length in terms
+	postings map[string][]posting
+	total    int // sum of the lengths of live documents
+	live     int
+}
+
+// LexicalHit is a document matching a lexical query.
+type LexicalHit struct {
+	URL   string
+	Score float64
+}
+
+// NewLexicalIndex indexes every entry of cache.
+func NewLexicalIndex(cache map[string]DataContext) *LexicalIndex {
+	ix := &LexicalIndex{ids: make(map[string]int), postings: make(map[string][]posting)}
+	for u, ctx := range cache {
+		ix.Add(u, ctx)
+	}
+	return ix
+}
+
+// catalogText returns the searchable text of a catalog entry. Titles and
+// keywords of extracted metadata are repeated so they weigh more than the
+// page text.
+func catalogText(rawURL string, ctx DataContext) string {
+	var b strings.Builder
+	// The host name is left out: every entry of a portal would match a query
+	// naming a word of it, such as "data" or "texas".
+	if u, err := url.Parse(rawURL); err == nil {
+		b.WriteString(u.Path + " ")
+	} else {
+		b.WriteString(rawURL + " ")
+	}
+	var md downloadMetadata
+	if json.Unmarshal([]byte(ctx.Description), &md) == nil && (md.URL != "" || md.Title != "") {
+		b.WriteString(strings.Repeat(md.Title+" ", 2))
+		b.WriteString(strings.Repeat(strings.Join(md.Keywords, " ")+" ", 2))
+		b.WriteString(md.Description + " " + md.Format)
+	} else {
+		b.WriteString(ctx.Description)
+	}
+	return b.String()
+}
+
+// lexTerms splits text into lower-case terms. Letters and digits stay
+// together, so product codes such as HUC8 or 3DEP are single terms, and a
+// short number following a word is also joined to it ("HUC-8" -> "huc8").
+func lexTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for i, w := range words {
+		if !stopWords[w] {
+			terms = append(terms, w)
+		}
+		if i > 0 && len(w) <= 2 && isDigits(w) && !isDigits(words[i-1]) {
+			terms = append(terms, words[i-1]+w)
+		}
+	}
+	return terms
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Add indexes or re-indexes the entry for rawURL.
+func (ix *LexicalIndex) Add(rawURL string, ctx DataContext) {
+	terms := lexTerms(catalogText(rawURL, ctx))
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(rawURL)
+	doc := len(ix.urls)
+	ix.ids[rawURL] = doc
+	ix.urls = append(ix.urls, rawURL)
+	ix.lens = append(ix.lens, len(terms))
+	ix.total += len(terms)
+	ix.live++
+	tf := make(map[string]int)
+	for _, t := range terms {
+		tf[t]++
+	}
+	for t, n := range tf {
+		ix.postings[t] = append(ix.postings[t], posting{doc: doc, tf: n})
+	}
+}
+
+// Has reports whether rawURL is indexed.
+func (ix *LexicalIndex) Has(rawURL string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	_, ok := ix.ids[rawURL]
+	return ok
+}
+
+// Remove drops rawURL from the index.
+func (ix *LexicalIndex) Remove(rawURL string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(rawURL)
+}
+
+// remove marks the document as deleted; its postings are skipped by Search.
+func (ix *LexicalIndex) remove(rawURL string) {
+	doc, ok := ix.ids[rawURL]
+	if !ok {
+		return
+	}
+	delete(ix.ids, rawURL)
+	ix.urls[doc] = ""
+	ix.total -= ix.lens[doc]
+	ix.live--
+}
+
+// Len returns the number of indexed documents.
+func (ix *LexicalIndex) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.live
+}
+
+// Search returns up to n documents matching query, best first. A
+// non-positive n returns every match.
+func (ix *LexicalIndex) Search(query string, n int) []LexicalHit {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if ix.live == 0 {
+		return nil
+	}
+	avg := float64(ix.total) / float64(ix.live)
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	for _, t := range lexTerms(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		var live []posting
+		for _, p := range ix.postings[t] {
+			if ix.urls[p.doc] != "" {
+				live = append(live, p)
+			}
+		}
+		if len(live) == 0 {
+			continue
+		}
+		df := float64(len(live))
+		idf := math.Log(1 + (float64(ix.live)-df+0.5)/(df+0.5))
+		for _, p := range live {
+			tf := float64(p.tf)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(ix.lens[p.doc])/avg)
+			scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+	hits := make([]LexicalHit, 0, len(scores))
+	for doc, s := range scores {
+		hits = append(hits, LexicalHit{URL: ix.urls[doc], Score: s})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].URL < hits[j].URL
+	})
+	if n > 0 && len(hits) > n {
+		hits = hits[:n]
+	}
+	return hits
+}
+
+// RankHybrid ranks the catalog (seeds and previously discovered datasets)
+// against query by fusing BM25 and embedding similarity with reciprocal
+// rank fusion, and returns the best n entries. Without a lexical index it
+// falls back to RankCached.
+func (m *Manager) RankHybrid(query string, queryEmbedding []float64, n int) []WebNode {
+	return m.rankHybrid(query, queryEmbedding, n, nil)
+}
+
+// rankHybrid is RankHybrid over the entries for which keep returns true, or
+// over all entries if keep is nil.
+func (m *Manager) rankHybrid(query string, queryEmbedding []float64, n int, keep func(string, DataContext) bool) []WebNode {
+	if m.lexical == nil || m.lexical.Len() == 0 {
+		return m.rankVectors(queryEmbedding, n, keep)
+	}
+	depth := 50
+	if n > 0 && 5*n > depth {
+		depth = 5 * n
+	}
+
+	fused := make(map[string]*WebNode)
+	node := func(u string) *WebNode {
+		if fn, ok := fused[u]; ok {
+			return fn
+		}
+		ctx, ok := m.CachedURLEmbeddings[u]
+		if !ok || keep != nil && !keep(u, ctx) {
+			return nil
+		}
+		fn := &WebNode{Url: u, context: ctx, Relevance: &Score{}}
+		if c, err := Cosine(queryEmbedding, ctx.Embedding); err == nil {
+			fn.CosineSimilarity = c
+			fn.Relevance.Semantic = c
+		}
+		fused[u] = fn
+		return fn
+	}
+	rank := 0
+	for _, hit := range m.lexical.Search(query, 0) {
+		if rank == depth {
+			break
+		}
+		if fn := node(hit.URL); fn != nil {
+			rank++
+			fn.Relevance.Lexical = hit.Score
+			fn.Relevance.Total += 1 / float64(rrfK+rank)
+		}
+	}
+	for rank, v := range m.rankVectors(queryEmbedding, depth, keep) {
+		if fn := node(v.Url); fn != nil {
+			fn.Relevance.Total += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	ranked := make([]WebNode, 0, len(fused))
+	for _, fn := range fused {
+		ranked = append(ranked, *fn)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Relevance.Total != ranked[j].Relevance.Total {
+			return ranked[i].Relevance.Total > ranked[j].Relevance.Total
+		}
+		return ranked[i].Url < ranked[j].Url
+	})
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// rankVectors is RankCached over the entries for which keep returns true.
+// The nearest neighbour index is asked for more and more results until n of
+// them are kept; if that takes too many, the kept entries are ranked
+// exhaustively.
+func (m *Manager) rankVectors(queryEmbedding []float64, n int, keep func(string, DataContext) bool) []WebNode {
+	if keep == nil {
+		return m.RankCached(queryEmbedding, n)
+	}
+	if m.ann != nil && n > 0 && m.ann.Len() >= annExactBelow {
+		for k := 4 * n; k < annExactBelow; k *= 4 {
+			var top []WebNode
+			for _, hit := range m.ann.Search(queryEmbedding, k, max(annEf, k)) {
+				if ctx, ok := m.CachedURLEmbeddings[hit.URL]; ok && keep(hit.URL, ctx) {
+					top = append(top, WebNode{Url: hit.URL, context: ctx, CosineSimilarity: hit.Similarity})
+					if len(top) == n {
+						return top
+					}
+				}
+			}
+		}
+	}
+	kept := make(map[string]DataContext)
+	for u, ctx := range m.CachedURLEmbeddings {
+		if keep(u, ctx) {
+			kept[u] = ctx
+		}
+	}
+	return (&Manager{CachedURLEmbeddings: kept}).RankCached(queryEmbedding, n)
+}
@@ -0,0 +1,61 @@
+package crawler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLexTerms(t *testing.T) {
+	got := lexTerms("USGS 3DEP lidar for the HUC-8 watersheds (NLCD_2019)")
+	want := []string{"usgs", "3dep", "lidar", "huc", "8", "huc8", "watersheds", "nlcd", "2019"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestLexicalIndexSearch(t *testing.T) {
+	ix := NewLexicalIndex(map[string]DataContext{
+		"https://www.mrlc.gov/data":           {Description: "National Land Cover Database (NLCD) land cover rasters"},
+		"https://websoilsurvey.nrcs.usda.gov": {Description: "Soil survey data for the United States"},
+		"https://x.gov/wbd/HUC-8.zip":         {Description: `{"title":"Watershed boundaries","url":"https://x.gov/wbd/HUC-8.zip"}`},
+		"https://x.gov/ssurgo_oh.zip":         {Description: `{"title":"SSURGO soils","keywords":["soils","OH"],"url":"https://x.gov/ssurgo_oh.zip"}`},
+	})
+	cases := map[string]string{
+		"nlcd":            "https://www.mrlc.gov/data",
+		"HUC8 watersheds": "https://x.gov/wbd/HUC-8.zip",
+		"SSURGO OH":       "https://x.gov/ssurgo_oh.zip",
+	}
+	for q, want := range cases {
+		hits := ix.Search(q, 1)
+		if len(hits) != 1 || hits[0].URL != want {
+			t.Errorf("Search(%q) = %v, want %s", q, hits, want)
+		}
+	}
+
+	ix.Remove("https://www.mrlc.gov/data")
+	if hits := ix.Search("nlcd", 0); len(hits) != 0 || ix.Len() != 3 {
+		t.Fatalf("removed document still found: %v", hits)
+	}
+}
+
+func TestRankHybridFindsExactProductNames(t *testing.T) {
+	cache := map[string]DataContext{
+		"https://a.gov/elevation": {Description: "elevation models", Embedding: []float64{1, 0}},
+		"https://b.gov/terrain":   {Description: "terrain and relief", Embedding: []float64{0.9, 0.1}},
+		"https://c.gov/slopes":    {Description: "slope rasters", Embedding: []float64{0.8, 0.2}},
+		"https://d.gov/3dep":      {Description: "USGS 3DEP products", Embedding: []float64{0, 1}},
+	}
+	m := &Manager{CachedURLEmbeddings: cache, lexical: NewLexicalIndex(cache)}
+	query := []float64{1, 0}
+
+	if vec := m.RankCached(query, 0); vec[len(vec)-1].Url != "https://d.gov/3dep" {
+		t.Fatalf("expected the vector ranking to put 3dep last, got %v", vec)
+	}
+	got := m.RankHybrid("3DEP", query, 2)
+	if len(got) != 2 || got[0].Url != "https://d.gov/3dep" || got[0].Relevance.Lexical == 0 {
+		t.Fatalf("hybrid ranking: %+v", got)
+	}
+	if got[1].Url != "https://a.gov/elevation" || got[1].CosineSimilarity != 1 {
+		t.Fatalf("second result: %+v", got[1])
+	}
+}
@@ -0,0 +1,206 @@
+package crawler
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// nearDuplicateBits is the largest number of differing SimHash bits at which
+// two pages count as copies of each other. It is replaced by Config.Apply.
+var nearDuplicateBits = activeConfig.NearDuplicateBits
+
+// minFingerprintWords is the least text a page needs to be fingerprinted;
+// shorter pages are too alike to tell apart by their text.
+const minFingerprintWords = 50
+
+// trackingParams are query parameters that never change the page, removed
+// from every URL. A trailing * matches any suffix.
+var trackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "msclkid", "yclid", "mc_cid", "mc_eid",
+	"_ga", "_gl", "igshid", "jsessionid", "phpsessid",
+}
+
+// autoindexSort holds the values Apache and nginx directory listings accept
+// for their column sort parameters ?C=N;O=D.
+var autoindexSort = map[string]string{"C": "NMSD", "O": "AD"}
+
+func matchParam(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(name, prefix) || p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// autoindexQuery reports whether query is nothing but the column sort of a
+// directory listing, such as "C=N;O=D". Other queries may use ";" and
+// single-letter parameters for their own ends and are left alone.
+func autoindexQuery(query string) bool {
+	if query == "" {
+		return false
+	}
+	for _, p := range strings.FieldsFunc(query, func(r rune) bool { return r == '&' || r == ';' }) {
+		name, value, _ := strings.Cut(p, "=")
+		if len(value) != 1 || !strings.Contains(autoindexSort[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanonicalURL cleans rawURL for fetching: the scheme and host are lower
+// case, default ports and the fragment are dropped, an empty path becomes
+// "/", a query that only sorts a directory listing is dropped, and tracking
+// and session parameters, as well as those the host's drop_params name, are
+// removed from the query, which is sorted. If the host sets keep_params,
+// only those parameters are kept. URLs that do not parse are returned
+// unchanged.
+func CanonicalURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	port := u.Port()
+	if port == "" || u.Scheme == "http" && port == "80" || u.Scheme == "https" && port == "443" {
+		u.Host = host
+	} else {
+		u.Host = host + ":" + port
+	}
+	u.Fragment, u.RawFragment = "", ""
+	if u.Path == "" {
+		u.Path, u.RawPath = "/", ""
+	}
+
+	hc := activeConfig.Host(host)
+	if autoindexQuery(u.RawQuery) {
+		u.RawQuery = ""
+	}
+	var params []string
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(p, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		switch {
+		case p == "":
+		case len(hc.KeepParams) > 0 && !matchParam(hc.KeepParams, name):
+		case matchParam(trackingParams, name), matchParam(hc.DropParams, name):
+		default:
+			params = append(params, p)
+		}
+	}
+	sort.Strings(params)
+	u.RawQuery = strings.Join(params, "&")
+	u.ForceQuery = false
+	return u.String()
+}
+
+// canonicalKey identifies the page at rawURL when comparing URLs: its
+// canonical form without the scheme, so http and https links match, and
+// without a trailing slash or directory index file name.
+func canonicalKey(rawURL string) string {
+	c := CanonicalURL(rawURL)
+	if i := strings.Index(c, "://"); i >= 0 {
+		c = c[i+3:]
+	}
+	p, q, hasQuery := strings.Cut(c, "?")
+	for _, index := range []string{"/index.html", "/index.htm"} {
+		p = strings.TrimSuffix(p, index)
+	}
+	p = strings.TrimRight(p, "/")
+	if hasQuery {
+		return p + "?" + q
+	}
+	return p
+}
+
+// pageWords returns the lower-case words of the visible text of a page,
+// leaving out scripts, styles and the navigation skipped when following
+// links.
+func pageWords(doc *html.Node) []string {
+	var words []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			words = append(words, strings.FieldsFunc(strings.ToLower(n.Data), notAlnum)...)
+			return
+		case n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style" || n.Data == "noscript" || HasUnwantedClassOrID(n)):
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return words
+}
+
+// SimHash returns the 64-bit SimHash of the word 3-grams of words. Texts
+// that share most of their 3-grams have hashes differing in few bits.
+func SimHash(words []string) uint64 {
+	var weights [64]int
+	add := func(s string) {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		sum := h.Sum64()
+		for i := range weights {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	if len(words) < 3 {
+		add(strings.Join(words, " "))
+	}
+	for i := 0; i+3 <= len(words); i++ {
+		add(words[i] + " " + words[i+1] + " " + words[i+2])
+	}
+	var fp uint64
+	for i, w := range weights {
+		if w > 0 {
+			fp |= 1 << i
+		}
+	}
+	return fp
+}
+
+// pageFingerprint returns the SimHash of the page, or 0 if it has too little
+// text to be compared.
+func pageFingerprint(doc *html.Node) uint64 {
+	words := pageWords(doc)
+	if len(words) < minFingerprintWords {
+		return 0
+	}
+	return SimHash(words)
+}
+
+// duplicatePage reports whether a page with nearly the same text as the one
+// with fingerprint fp was crawled already, and records fp otherwise. A zero
+// fingerprint is never a duplicate.
+func (m *Manager) duplicatePage(fp uint64) bool {
+	if fp == 0 {
+		return false
+	}
+	m.ckMu.Lock()
+	defer m.ckMu.Unlock()
+	for _, f := range m.fingerprints {
+		if bits.OnesCount64(f^fp) <= nearDuplicateBits {
+			return true
+		}
+	}
+	m.fingerprints = append(m.fingerprints, fp)
+	return false
+}
@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	old := activeConfig
+	t.Cleanup(func() { activeConfig = old })
+	activeConfig = DefaultConfig()
+	activeConfig.Hosts = map[string]HostConfig{
+		"data.example.gov": {DropParams: []string{"session", "ref_*"}},
+		"maps.example.gov": {KeepParams: []string{"layer"}},
+	}
+
+	cases := []struct{ in, want string }{
+		{"HTTP://Example.COM:80", "http://example.com/"},
+		{"https://example.com:443/a#top", "https://example.com/a"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"http://example.com./a?", "http://example.com/a"},
+		{"http://example.com/a?utm_source=x&b=2&a=1&fbclid=y", "http://example.com/a?a=1&b=2"},
+		{"http://example.com/files/?C=N;O=D", "http://example.com/files/"},
+		{"http://example.com/files/?C=Q", "http://example.com/files/?C=Q"},
+		{"http://example.com/files/?C=M&O=A", "http://example.com/files/"},
+		{"https://example.gov/api?where=a;b&x=1", "https://example.gov/api?where=a;b&x=1"},
+		{"https://example.gov/list?C=D&id=3", "https://example.gov/list?C=D&id=3"},
+		{"https://example.gov/list?id=3;C=N;O=D", "https://example.gov/list?id=3;C=N;O=D"},
+		{"http://data.example.gov/x?session=1&ref_page=2&id=3", "http://data.example.gov/x?id=3"},
+		{"http://maps.example.gov/x?layer=roads&view=2", "http://maps.example.gov/x?layer=roads"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+	}
+	for _, c := range cases {
+		if got := CanonicalURL(c.in); got != c.want {
+			t.Errorf("CanonicalURL(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+
+	same := []string{
+		"http://example.com/data",
+		"https://example.com/data/",
+		"https://EXAMPLE.com/data/index.html#list",
+		"http://example.com/data/?O=A",
+	}
+	for _, u := range same[1:] {
+		if canonicalKey(u) != canonicalKey(same[0]) {
+			t.Errorf("canonicalKey(%q) = %q, want %q", u, canonicalKey(u), canonicalKey(same[0]))
+		}
+	}
+	if canonicalKey("http://example.com/data?page=2") == canonicalKey("http://example.com/data") {
+		t.Errorf("query ignored in canonical key")
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	text := strings.Fields(strings.Repeat("county parcel boundaries published by the state survey office as shapefiles ", 8))
+	edited := append(append([]string(nil), text...), "updated", "yesterday")
+	other := strings.Fields(strings.Repeat("river gauge readings every fifteen minutes from stations along the basin ", 8))
+
+	if d := bits.OnesCount64(SimHash(text) ^ SimHash(edited)); d > 3 {
+		t.Errorf("edited copy differs in %d bits", d)
+	}
+	if d := bits.OnesCount64(SimHash(text) ^ SimHash(other)); d <= 3 {
+		t.Errorf("unrelated text differs in only %d bits", d)
+	}
+}
+
+func TestCrawlSkipsURLVariantsAndMirrors(t *testing.T) {
+	listing := strings.Repeat("Statewide elevation tiles in GeoTIFF format, updated every spring by the survey office. ", 8)
+	var mu sync.Mutex
+	fetched := map[string]int{}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[r.URL.RequestURI()]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/data/">data</a><a href="/data/?C=N;O=D">sorted</a>`+
+				`<a href="/data/index.html?utm_source=home#top">again</a><a href="/mirror/">mirror</a>`)
+		case "/data/", "/mirror/":
+			fmt.Fprintf(w, `<p>%s</p><a href="%stiles.zip">tiles</a><a href="%sold/">older</a>`, listing, r.URL.Path, r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	mg := NewManager(Options{})
+	mg.CachedURLEmbeddings = map[string]DataContext{site.URL + "/": {Description: "seed"}}
+	mg.pages = NewPageCache()
+	found := mg.Recrawl(context.Background(), 0)
+
+	// Only one of the two listings is crawled further, but the datasets of
+	// both are found.
+	if fetched["/data/"] != 1 || fetched["/data/old/"]+fetched["/mirror/old/"] != 1 || len(fetched) != 4 {
+		t.Fatalf("pages fetched: %v", fetched)
+	}
+	if len(found) != 2 || mg.Progress().Duplicates != 1 {
+		t.Fatalf("found %v, progress %+v", found, mg.Progress())
+	}
+}
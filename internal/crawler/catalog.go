@@ -0,0 +1,88 @@
+package crawler
+
+import (
+	"log"
+	"time"
+)
+
+// Catalog-first search answers a query from the datasets earlier crawls
+// discovered and crawls only when too few of them match or they are stale.
+// The thresholds are replaced by Config.Apply.
+var (
+	catalogMinResults    = activeConfig.CatalogMinResults
+	catalogMaxAge        = activeConfig.CatalogMaxAge
+	catalogMinSimilarity = activeConfig.CatalogMinSimilarity
+)
+
+// IsDataset reports whether a cache entry is a dataset found by a crawl
+// rather than a page crawls start from. Entries written before discovery
+// times were recorded count as datasets when they are not built-in seeds and
+// look like a file.
+func IsDataset(rawURL string, ctx DataContext) bool {
+	if !ctx.Found.IsZero() {
+		return true
+	}
+	if _, ok := PublicGeospatialDataSeeds[rawURL]; ok {
+		return false
+	}
+	return candidateFormat(WebNode{Url: rawURL, context: ctx}) != ""
+}
+
+func isSeed(rawURL string, ctx DataContext) bool { return !IsDataset(rawURL, ctx) }
+
+// Stale reports whether no crawl has seen the dataset within maxAge.
+func (c DataContext) Stale(maxAge time.Duration) bool {
+	return c.Checked.IsZero() || time.Since(c.Checked) > maxAge
+}
+
+// SearchCatalog returns the known datasets matching query, best first, scored
+// like crawl candidates. Entries that neither share a term other than generic
+// ones such as "data" with the query nor reach the configured similarity are
+// left out, so the result may be empty.
+func (m *Manager) SearchCatalog(query string, queryEmbedding []float64, n int) []WebNode {
+	specific := make(map[string]bool)
+	if m.lexical != nil {
+		for _, hit := range m.lexical.Search(specificTerms(query), 0) {
+			specific[hit.URL] = true
+		}
+	}
+	var matches []WebNode
+	for _, node := range m.rankHybrid(query, queryEmbedding, 0, IsDataset) {
+		if specific[node.Url] || node.CosineSimilarity >= catalogMinSimilarity {
+			node.Relevance = nil
+			matches = append(matches, node)
+		}
+	}
+	ranked := m.RankCandidates(queryEmbedding, matches)
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// answerFromCatalog looks the query up in the catalog. It reports the known
+// datasets and whether enough of them are fresh to skip the crawl.
+func (m *Manager) answerFromCatalog(queryEmbedding []float64) ([]WebNode, bool) {
+	known := m.SearchCatalog(*m.searchQuery, queryEmbedding, 0)
+	fresh := 0
+	for _, n := range known {
+		if !n.context.Stale(catalogMaxAge) {
+			fresh++
+		}
+	}
+	log.Printf("catalog: %d known datasets match, %d fresh (need %d)", len(known), fresh, catalogMinResults)
+	return known, fresh >= catalogMinResults
+}
+
+// downloadKnown downloads catalog answers, which unlike crawl candidates are
+// not fetched while the crawl runs.
+func (m *Manager) downloadKnown(nodes []WebNode) {
+	for i := range nodes {
+		if err := m.DownloadURL(&nodes[i]); err != nil {
+			log.Printf("downloading %s: %v", nodes[i].Url, err)
+			entry := NewManifestEntry(*m.searchQuery, &nodes[i])
+			entry.Error = err.Error()
+			m.addManifestEntry(entry)
+		}
+	}
+}
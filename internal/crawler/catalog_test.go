@@ -0,0 +1,132 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsDataset(t *testing.T) {
+	var builtin string
+	for u := range PublicGeospatialDataSeeds {
+		builtin = u
+		break
+	}
+	cases := []struct {
+		url  string
+		ctx  DataContext
+		want bool
+	}{
+		{builtin, DataContext{}, false},
+		{"https://example.com/data/", DataContext{Description: "a portal"}, false},
+		{"https://example.com/data/", DataContext{Found: time.Now()}, true},
+		{"https://example.com/roads.zip", DataContext{}, true}, // cached before Found was recorded
+	}
+	for _, c := range cases {
+		if got := IsDataset(c.url, c.ctx); got != c.want {
+			t.Errorf("IsDataset(%s, %+v) = %v", c.url, c.ctx, got)
+		}
+	}
+}
+
+func TestFindLinksCatalogFirst(t *testing.T) {
+	useFakeEmbedder(t)
+	var mu sync.Mutex
+	var fetched []string
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/new.zip">new</a>`))
+	}))
+	defer site.Close()
+
+	oldMin := catalogMinResults
+	catalogMinResults = 1
+	t.Cleanup(func() { catalogMinResults = oldMin })
+
+	now := time.Now().UTC()
+	known := site.URL + "/old.zip"
+	cache := map[string]DataContext{
+		site.URL + "/": {Description: "seed", Embedding: []float64{1, 0}},
+		known:          {Description: "old counties", Embedding: []float64{1, 0}, Found: now, Checked: now},
+	}
+	crawl := func() []WebNode {
+		t.Helper()
+		mg := NewManager(Options{Query: "counties", CatalogFirst: true})
+		mg.CachedURLEmbeddings = cache
+		mg.lexical = NewLexicalIndex(cache)
+		links, err := mg.FindLinksContext(context.Background())
+		if err != nil {
+			t.Fatalf("FindLinksContext: %v", err)
+		}
+		mg.Learn(links)
+		return links
+	}
+
+	// A fresh match answers the query without any request.
+	links := crawl()
+	if len(links) != 1 || links[0].Url != known || len(fetched) != 0 {
+		t.Fatalf("fresh catalog: links %v, fetched %v", links, fetched)
+	}
+	if r := NewResult(links[0]); r.Checked == nil || r.Stale {
+		t.Fatalf("result freshness: %+v", r)
+	}
+
+	// Once stale, the seed is crawled again, but the known dataset is
+	// neither used as a seed nor marked as seen.
+	ctx := cache[known]
+	ctx.Checked = now.Add(-2 * catalogMaxAge)
+	cache[known] = ctx
+	links = crawl()
+	var urls []string
+	for _, n := range links {
+		urls = append(urls, n.Url)
+	}
+	if !SlicesEqualUnordered(urls, []string{known, site.URL + "/new.zip"}) {
+		t.Fatalf("stale catalog: links %v", urls)
+	}
+	for _, p := range fetched {
+		if p == "/old.zip" {
+			t.Fatalf("known dataset was crawled: %v", fetched)
+		}
+	}
+	if !cache[known].Stale(catalogMaxAge) || cache[site.URL+"/new.zip"].Found.IsZero() {
+		t.Fatalf("cache after crawl: %+v", cache)
+	}
+}
+
+// TestCatalogIgnoresGenericTerms keeps fresh but unrelated datasets from
+// answering a query through words such as "data" in their URLs.
+func TestCatalogIgnoresGenericTerms(t *testing.T) {
+	oldMin := catalogMinResults
+	catalogMinResults = 5
+	t.Cleanup(func() { catalogMinResults = oldMin })
+
+	now := time.Now().UTC()
+	cache := map[string]DataContext{}
+	for i := 0; i < 6; i++ {
+		u := fmt.Sprintf("https://data.texas.gov/download/data/roads-%d.zip", i)
+		cache[u] = DataContext{
+			Description: fmt.Sprintf(`{"title":"Texas road centerlines %d","keywords":["roads","data"],"url":%q}`, i, u),
+			Embedding:   []float64{0, 1},
+			Found:       now,
+			Checked:     now,
+		}
+	}
+	mg := NewManager(Options{Query: "ohio lidar data"})
+	mg.CachedURLEmbeddings = cache
+	mg.lexical = NewLexicalIndex(cache)
+
+	if known, ok := mg.answerFromCatalog([]float64{1, 0}); ok || len(known) != 0 {
+		t.Fatalf("unrelated entries answered the query: %d known, sufficient %v", len(known), ok)
+	}
+	if known := mg.SearchCatalog("texas road centerlines", []float64{1, 0}, 0); len(known) != 6 {
+		t.Fatalf("matching query: %d known", len(known))
+	}
+}
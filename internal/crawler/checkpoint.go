@@ -0,0 +1,247 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checkpointInterval is how often a crawl saves its state while it runs. It
+// is replaced by Config.Apply.
+var checkpointInterval = activeConfig.CheckpointInterval
+
+// Checkpoint is the saved state of a crawl session, enough to continue it
+// without fetching the visited pages again.
+type Checkpoint struct {
+	Session      string           `json:"session"`
+	Query        string           `json:"query"`
+	DownloadDir  string           `json:"download_dir,omitempty"`
+	MaxCrawl     int              `json:"max_crawl"`
+	Saved        time.Time        `json:"saved"`
+	Crawled      int              `json:"crawled"` // pages finished, counted against max_crawl
+	Progress     Progress         `json:"progress"`
+	Visited      []string         `json:"visited"`  // canonical keys
+	Frontier     []CheckpointNode `json:"frontier"` // pages still to fetch, including those in flight
+	Candidates   []CheckpointNode `json:"candidates"`
+	Fingerprints []uint64         `json:"fingerprints,omitempty"` // SimHashes of the pages crawled
+	Scopes       []CheckpointSeed `json:"scopes,omitempty"`       // budgets spent, by seed
+	Manifest     *Manifest        `json:"manifest,omitempty"`
+}
+
+// CheckpointSeed is what a crawl has spent from the page and byte budgets of
+// a seed.
+type CheckpointSeed struct {
+	Seed  string `json:"seed"`
+	Pages int    `json:"pages"`
+	Bytes int64  `json:"bytes"`
+}
+
+// CheckpointNode is a page or candidate with the chain of pages it was
+// reached through.
+type CheckpointNode struct {
+	URL         string   `json:"url"`
+	Path        []string `json:"path,omitempty"` // ancestors, seed first
+	Description string   `json:"description,omitempty"`
+}
+
+func checkpointNode(n WebNode) CheckpointNode {
+	path := CrawlPath(&n)
+	return CheckpointNode{URL: n.Url, Path: path[:len(path)-1], Description: n.context.Description}
+}
+
+// node rebuilds the WebNode, with its parent chain and depth.
+func (c CheckpointNode) node() WebNode {
+	var parent *WebNode
+	for i, u := range c.Path {
+		parent = &WebNode{Url: u, Parent: parent, Depth: i}
+	}
+	return WebNode{Url: c.URL, Parent: parent, Depth: len(c.Path), context: DataContext{Description: c.Description}}
+}
+
+// checkpointPath returns where the checkpoint of session is kept, next to
+// the crawl log.
+func checkpointPath(session string) string {
+	return filepath.Join(filepath.Dir(findLinksLogPath), "sessions", session+".json")
+}
+
+// Checkpoint returns the current state of the crawl. It is safe to call while
+// the crawl is running.
+func (m *Manager) Checkpoint() *Checkpoint {
+	ck := &Checkpoint{
+		Query:    *m.searchQuery,
+		MaxCrawl: m.maxCrawl,
+		Saved:    time.Now().UTC(),
+		Progress: m.Progress(),
+	}
+	if m.downloadPath != nil {
+		ck.DownloadDir = *m.downloadPath
+	}
+
+	m.ckMu.Lock()
+	// Pages in flight are saved in the frontier and counted again when a
+	// resumed crawl fetches them.
+	ck.Crawled = m.crawled - len(m.pending)
+	ck.Fingerprints = append([]uint64(nil), m.fingerprints...)
+	inFlight := make(map[string]int)
+	for _, n := range m.pending {
+		inFlight[seedOf(&n).Url]++
+	}
+	for seed, sc := range m.scopes {
+		ck.Scopes = append(ck.Scopes, CheckpointSeed{Seed: seed, Pages: sc.pages - inFlight[seed], Bytes: sc.bytes})
+	}
+	todo := make(map[string]WebNode, len(m.pending)+len(m.frontier))
+	for u, n := range m.frontier {
+		todo[u] = n
+	}
+	for u, n := range m.pending {
+		todo[u] = n
+	}
+	for u := range m.seen {
+		if _, ok := m.pending[u]; !ok {
+			ck.Visited = append(ck.Visited, u)
+		}
+	}
+	m.ckMu.Unlock()
+	sort.Strings(ck.Visited)
+	sort.Slice(ck.Scopes, func(i, j int) bool { return ck.Scopes[i].Seed < ck.Scopes[j].Seed })
+	for _, n := range todo {
+		ck.Frontier = append(ck.Frontier, checkpointNode(n))
+	}
+	sort.Slice(ck.Frontier, func(i, j int) bool { return ck.Frontier[i].URL < ck.Frontier[j].URL })
+	for _, n := range m.Found() {
+		ck.Candidates = append(ck.Candidates, checkpointNode(n))
+	}
+
+	m.manifestMu.Lock()
+	if m.manifest != nil {
+		ck.Session, ck.Manifest = m.manifest.Session, m.manifest.redacted()
+	}
+	m.manifestMu.Unlock()
+	return ck
+}
+
+// SaveCheckpoint writes ck to p. The file is replaced atomically, so a crash
+// while saving leaves the previous checkpoint intact.
+func SaveCheckpoint(ck *Checkpoint, p string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ck); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// LoadCheckpoint reads the checkpoint of a session, given by its ID or by
+// the path of the checkpoint file.
+func LoadCheckpoint(session string) (*Checkpoint, error) {
+	p := session
+	if !strings.HasSuffix(session, ".json") {
+		p = checkpointPath(session)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var ck Checkpoint
+	if err := json.Unmarshal(data, &ck); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %s: %w", p, err)
+	}
+	return &ck, nil
+}
+
+// saveCheckpoint writes the current state to m.checkpointPath.
+func (m *Manager) saveCheckpoint() {
+	if err := SaveCheckpoint(m.Checkpoint(), m.checkpointPath); err != nil {
+		log.Printf("failed to write checkpoint %s: %v", m.checkpointPath, err)
+	}
+}
+
+// checkpointPeriodically saves checkpoints until the returned function is
+// called, which saves a final one.
+func (m *Manager) checkpointPeriodically() func() {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(checkpointInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				m.saveCheckpoint()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		m.saveCheckpoint()
+	}
+}
+
+// Resume restores the state saved in ck. The next call to FindLinksContext
+// continues the crawl from the saved frontier instead of choosing seeds, and
+// checkpoints keep being written for the session.
+func (m *Manager) Resume(ck *Checkpoint) {
+	m.ckMu.Lock()
+	m.seen = make(map[string]bool, len(ck.Visited))
+	for _, u := range ck.Visited {
+		m.seen[u] = true
+	}
+	m.crawled = ck.Crawled
+	m.fingerprints = ck.Fingerprints
+	m.scopes = nil
+	for _, c := range ck.Scopes {
+		sc := m.scopeOf(&WebNode{Url: c.Seed})
+		sc.pages, sc.bytes = c.Pages, c.Bytes
+	}
+	m.ckMu.Unlock()
+	if ck.MaxCrawl > 0 {
+		m.maxCrawl = ck.MaxCrawl
+	}
+
+	m.resumeFrontier = make([]WebNode, 0, len(ck.Frontier))
+	for _, c := range ck.Frontier {
+		m.resumeFrontier = append(m.resumeFrontier, c.node())
+	}
+
+	m.linkChan <- struct{}{}
+	m.candidates = make(map[string]bool, len(ck.Candidates))
+	m.downloadURLs = m.downloadURLs[:0]
+	for _, c := range ck.Candidates {
+		m.candidates[canonicalKey(c.URL)] = true
+		m.downloadURLs = append(m.downloadURLs, c.node())
+	}
+	<-m.linkChan
+
+	m.eventMu.Lock()
+	m.progress = ck.Progress
+	m.progress.Queued = 0
+	m.eventMu.Unlock()
+
+	if ck.Manifest != nil {
+		m.manifestMu.Lock()
+		m.manifest = ck.Manifest
+		m.manifestMu.Unlock()
+	}
+	m.checkpointPath = checkpointPath(ck.Session)
+}
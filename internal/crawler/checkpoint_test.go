@@ -0,0 +1,113 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCrawlResumesFromCheckpoint(t *testing.T) {
+	useFakeEmbedder(t)
+	var mu sync.Mutex
+	fetched := map[string]int{}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/p1">1</a><a href="/p2">2</a><a href="/a.zip">a</a>`))
+		case "/p1":
+			w.Write([]byte(`<a href="/b.zip">b</a>`))
+		case "/p2":
+			w.Write([]byte(`<a href="/c.zip">c</a>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	oldLog := findLinksLogPath
+	findLinksLogPath = filepath.Join(t.TempDir(), "findLinks.log")
+	t.Cleanup(func() { findLinksLogPath = oldLog })
+	cache := map[string]DataContext{site.URL + "/": {Description: "seed", Embedding: []float64{1, 0}}}
+
+	// Interrupt the crawl once the seed page has been parsed.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := NewManager(Options{Query: "q", Checkpoint: true, OnEvent: func(ev Event) {
+		if ev.Kind == EventPage {
+			cancel()
+		}
+	}})
+	first.CachedURLEmbeddings = cache
+	if _, err := first.FindLinksContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	session := first.manifest.Session
+	if fetched["/p1"]+fetched["/p2"] != 0 {
+		t.Fatalf("crawl continued after cancel: %v", fetched)
+	}
+
+	ck, err := LoadCheckpoint(session)
+	if err != nil {
+		t.Fatalf("loading checkpoint: %v", err)
+	}
+	if !reflect.DeepEqual(ck.Visited, []string{canonicalKey(site.URL + "/")}) || len(ck.Frontier) != 2 || len(ck.Candidates) != 1 || ck.Progress.Fetched != 1 {
+		t.Fatalf("checkpoint: %+v", ck)
+	}
+
+	second := NewManager(Options{Query: ck.Query})
+	second.CachedURLEmbeddings = cache
+	second.Resume(ck)
+	links, err := second.FindLinksContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched["/"] != 1 || fetched["/p1"] != 1 || fetched["/p2"] != 1 {
+		t.Fatalf("pages fetched: %v", fetched)
+	}
+	paths := map[string][]string{}
+	for _, n := range links {
+		paths[n.Url] = CrawlPath(&n)
+	}
+	if len(paths) != 3 || !reflect.DeepEqual(paths[site.URL+"/b.zip"], []string{site.URL + "/", site.URL + "/p1", site.URL + "/b.zip"}) {
+		t.Fatalf("resumed results: %v", paths)
+	}
+	if pr := second.Progress(); pr.Fetched != 3 || pr.Found != 3 || second.manifest.Session != session {
+		t.Fatalf("progress %+v, session %s", pr, second.manifest.Session)
+	}
+
+	// The final checkpoint of the resumed session has nothing left to do.
+	if ck, err = LoadCheckpoint(filepath.Join(filepath.Dir(findLinksLogPath), "sessions", session+".json")); err != nil || len(ck.Frontier) != 0 {
+		t.Fatalf("final checkpoint: %+v, %v", ck, err)
+	}
+}
+
+// TestCheckpointInFlight saves a crawl with a page still being fetched,
+// which the resumed crawl fetches again and must not count twice.
+func TestCheckpointInFlight(t *testing.T) {
+	seed := &WebNode{Url: "https://data.example.gov/"}
+	page := WebNode{Url: "https://data.example.gov/maps/", Parent: seed, Depth: 1}
+	first := NewManager(Options{Query: "q"})
+	first.crawled = 2
+	first.seen = map[string]bool{canonicalKey(seed.Url): true, canonicalKey(page.Url): true}
+	first.pending = map[string]WebNode{canonicalKey(page.Url): page}
+	sc := first.scopeOf(seed)
+	sc.pages, sc.bytes = 2, 5000
+
+	ck := first.Checkpoint()
+	if ck.Crawled != 1 || len(ck.Frontier) != 1 || !reflect.DeepEqual(ck.Scopes, []CheckpointSeed{{Seed: seed.Url, Pages: 1, Bytes: 5000}}) {
+		t.Fatalf("checkpoint: %+v", ck)
+	}
+
+	second := NewManager(Options{Query: "q"})
+	second.Resume(ck)
+	if sc := second.scopeOf(&page); second.crawled != 1 || sc.pages != 1 || sc.bytes != 5000 {
+		t.Fatalf("resumed: crawled %d, scope %+v", second.crawled, sc)
+	}
+}
@@ -0,0 +1,349 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The segmented download settings, replaced by Config.Apply.
+var (
+	downloadSegments = activeConfig.Segments
+	segmentMinBytes  = activeConfig.SegmentMinBytes
+	segmentRetries   = activeConfig.SegmentRetries
+	downloadLimiter  = newRateLimiter(activeConfig.RateLimit)
+)
+
+// segmentBackoff is the pause before the first retry of a failed segment;
+// later retries wait longer.
+var segmentBackoff = time.Second
+
+// progressInterval is how often EventDownload reports a running download.
+var progressInterval = time.Second
+
+// errChanged is returned when a range request is answered with the whole
+// file because it no longer matches the validators of the first response.
+var errChanged = errors.New("file changed on the server during the download")
+
+// saveDownload saves the body of resp into dir under the name the layout
+// gives node, through the download sandbox in secure mode, while reporting
+// its progress and keeping to the rate limit. Large files from servers that
+// accept range requests are fetched over several connections by
+// saveSegmented.
+func (m *Manager) saveDownload(resp *http.Response, node *WebNode, dir string) (savedFile, error) {
+	tr := m.startTransfer(node.Url, resp.ContentLength)
+	defer tr.stop()
+	name := layoutName(node, resp)
+	var saved savedFile
+	var err error
+	if n := segmentsFor(resp); n > 1 {
+		tr.segments.Store(int64(n))
+		saved, err = m.saveSegmented(resp, node.Url, name, dir, n, tr)
+	} else {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{limitRate(tr.count(resp.Body)), resp.Body}
+		if m.secure {
+			saved, err = saveSandboxed(resp, node.Url, name, dir)
+		} else {
+			saved, err = saveResponse(resp, dir)
+		}
+	}
+	if err != nil {
+		return saved, err
+	}
+	return m.place(saved, dir, name)
+}
+
+// segmentsFor returns the number of connections the body of resp is worth
+// fetching over: one unless the file is large, its length is known, the
+// server accepts byte ranges and the host allows more than one request at a
+// time.
+func segmentsFor(resp *http.Response) int {
+	n := downloadSegments
+	if n < 2 || resp.Request == nil || resp.StatusCode != http.StatusOK ||
+		resp.ContentLength < segmentMinBytes ||
+		!strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") ||
+		resp.Header.Get("Content-Encoding") != "" {
+		return 1
+	}
+	if hc := activeConfig.Host(resp.Request.URL.Hostname()); hc.Concurrency > 0 && hc.Concurrency < n {
+		n = hc.Concurrency
+	}
+	return n
+}
+
+// saveSegmented splits the file into n ranges and fetches them at once into
+// a temporary file preallocated to its full size, like saveResponse. The
+// first range is read from resp itself. In secure mode the ranges are
+// assembled in the quarantine directory and the result is handed to the
+// sandbox helper to validate against name.
+func (m *Manager) saveSegmented(resp *http.Response, rawURL, name, dir string, n int, tr *transfer) (savedFile, error) {
+	defer resp.Body.Close()
+	var f *os.File
+	var err error
+	if m.secure {
+		quarantine := filepath.Join(dir, quarantineDir)
+		if err := os.MkdirAll(quarantine, 0700); err != nil {
+			return savedFile{}, err
+		}
+		f, err = os.CreateTemp(quarantine, "segments-*")
+	} else {
+		f, err = os.CreateTemp(dir, partPattern)
+	}
+	if err != nil {
+		return savedFile{}, fmt.Errorf("creating file for %s: %w", rawURL, err)
+	}
+	fail := func(err error) (savedFile, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return savedFile{}, err
+	}
+
+	size := resp.ContentLength
+	if err := f.Truncate(size); err != nil {
+		return fail(fmt.Errorf("allocating %s: %w", f.Name(), err))
+	}
+	if err := fetchSegments(resp, f, n, tr); err != nil {
+		return fail(fmt.Errorf("downloading %s: %w", rawURL, err))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+
+	if m.secure {
+		defer os.Remove(f.Name())
+		return saveSandboxed(&http.Response{Body: f, ContentLength: size}, rawURL, name, dir)
+	}
+	hw := newHashingWriter(io.Discard)
+	if _, err := io.Copy(hw, f); err != nil {
+		return fail(fmt.Errorf("reading back %s: %w", f.Name(), err))
+	}
+	if err := f.Close(); err != nil {
+		return fail(fmt.Errorf("writing to file %s: %w", f.Name(), err))
+	}
+	return savedFile{Path: f.Name(), Size: hw.size, SHA256: hw.Hex()}, nil
+}
+
+// fetchSegments writes the n ranges of the file behind resp to f, each on its
+// own connection, and returns once all of them are complete or one has
+// failed for good.
+func fetchSegments(resp *http.Response, f *os.File, n int, tr *transfer) error {
+	size := resp.ContentLength
+	rawURL := resp.Request.URL.String()
+	// If-Range makes the server send the whole file instead of a range when
+	// the file is no longer the one the first response started.
+	validator := resp.Header.Get("Etag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	part := size / int64(n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		start, end := int64(i)*part, int64(i+1)*part
+		if i == n-1 {
+			end = size
+		}
+		var body io.ReadCloser
+		if i == 0 {
+			body = resp.Body
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fetchSegment(rawURL, validator, body, f, start, end, tr)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// fetchSegment writes bytes start to end (exclusive) of rawURL at the same
+// offsets of f. body, if not nil, already delivers the file from start. A
+// failed segment is requested again from where it stopped, up to
+// segmentRetries times.
+func fetchSegment(rawURL, validator string, body io.ReadCloser, f *os.File, start, end int64, tr *transfer) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if body == nil {
+			body, err = requestRange(rawURL, validator, start, end)
+		}
+		if body != nil {
+			var n int64
+			n, err = io.Copy(io.NewOffsetWriter(f, start), limitRate(tr.count(io.LimitReader(body, end-start))))
+			body.Close()
+			body = nil
+			start += n
+			if start == end {
+				return nil
+			}
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if errors.Is(err, errChanged) || errors.Is(err, ErrUnsafeURL) || attempt >= segmentRetries {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * segmentBackoff)
+	}
+}
+
+// requestRange requests bytes start to end (exclusive) of rawURL. The host
+// slot is held until the returned body is closed, so the segments of a file
+// count against the per-host concurrency like any other request.
+func requestRange(rawURL, validator string, start, end int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	hc := activeConfig.Host(req.URL.Hostname())
+	req.Header.Set("User-Agent", hc.UserAgent)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+	release := acquireHost(req.URL.Hostname(), hc)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", start)) {
+		resp.Body.Close()
+		release()
+		if resp.StatusCode == http.StatusOK {
+			return nil, errChanged
+		}
+		return nil, fmt.Errorf("range %d-%d: %s", start, end-1, resp.Status)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{resp.Body, closerFunc(func() error {
+		defer release()
+		return resp.Body.Close()
+	})}, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// transfer counts the bytes of one download and reports them as
+// EventDownload every progressInterval, and once more when it stops.
+type transfer struct {
+	m        *Manager
+	url      string
+	total    int64
+	bytes    atomic.Int64
+	segments atomic.Int64
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func (m *Manager) startTransfer(rawURL string, total int64) *transfer {
+	t := &transfer{m: m, url: rawURL, total: total, quit: make(chan struct{}), done: make(chan struct{})}
+	t.segments.Store(1)
+	go func() {
+		defer close(t.done)
+		tick := time.NewTicker(progressInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				t.report(false)
+			case <-t.quit:
+				t.report(true)
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (t *transfer) report(done bool) {
+	t.m.emit(Event{Kind: EventDownload, Node: WebNode{Url: t.url}, Transfer: Transfer{
+		Bytes:    t.bytes.Load(),
+		Total:    t.total,
+		Segments: int(t.segments.Load()),
+		Done:     done,
+	}})
+}
+
+func (t *transfer) stop() {
+	close(t.quit)
+	<-t.done
+}
+
+// count returns a reader that adds what it reads from r to the transfer.
+func (t *transfer) count(r io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		t.bytes.Add(int64(n))
+		return n, err
+	})
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// rateLimiter spaces reads so that the bytes they return, summed over every
+// reader sharing the limiter, stay under a rate.
+type rateLimiter struct {
+	rate int64 // bytes per second
+	mu   sync.Mutex
+	next time.Time // when the bytes read so far are paid for
+}
+
+// newRateLimiter returns a limiter for rate bytes per second, or nil for no
+// limit.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until n more bytes fit under the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	until := l.next
+	l.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
+
+// limitRate returns r slowed to the download rate limit. Reads are kept
+// small so that concurrent downloads share the rate evenly.
+func limitRate(r io.Reader) io.Reader {
+	l := downloadLimiter
+	if l == nil {
+		return r
+	}
+	return readerFunc(func(p []byte) (int, error) {
+		if len(p) > 32<<10 {
+			p = p[:32<<10]
+		}
+		n, err := r.Read(p)
+		l.wait(n)
+		return n, err
+	})
+}
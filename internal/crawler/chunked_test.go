@@ -0,0 +1,165 @@
+package crawler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// segmentSettings makes files of 1KB and more download over 4 connections
+// for the rest of the test.
+func segmentSettings(t *testing.T) {
+	oldSegments, oldMin, oldRetries, oldBackoff := downloadSegments, segmentMinBytes, segmentRetries, segmentBackoff
+	downloadSegments, segmentMinBytes, segmentRetries, segmentBackoff = 4, 1<<10, 2, 0
+	t.Cleanup(func() {
+		downloadSegments, segmentMinBytes, segmentRetries, segmentBackoff = oldSegments, oldMin, oldRetries, oldBackoff
+	})
+}
+
+func TestSegmentedDownload(t *testing.T) {
+	segmentSettings(t)
+	body := []byte("PK\x03\x04" + strings.Repeat("0123456789", 10000))
+	sum := sha256.Sum256(body)
+	var ranges, failed atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rg := r.Header.Get("Range"); rg != "" {
+			ranges.Add(1)
+			// The first request for the last segment breaks off halfway.
+			if strings.HasPrefix(rg, "bytes=75003-") && failed.Add(1) == 1 {
+				w.Header().Set("Content-Range", "bytes 75003-100003/100004")
+				w.Header().Set("Content-Length", "25001")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(body[75003:80003])
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		http.ServeContent(w, r, "", time.Unix(1700000000, 0), bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	for _, secure := range []bool{false, true} {
+		if secure {
+			if err := sandboxCommand(exec.Command("true")); err != nil {
+				t.Log("skipping secure mode:", err)
+				continue
+			}
+		}
+		ranges.Store(0)
+		failed.Store(0)
+		dir := t.TempDir()
+		var mu sync.Mutex
+		var last Transfer
+		mg := NewManager(Options{Query: "q", DownloadDir: dir, Secure: secure, OnEvent: func(ev Event) {
+			if ev.Kind == EventDownload {
+				mu.Lock()
+				last = ev.Transfer
+				mu.Unlock()
+			}
+		}})
+		if err := mg.DownloadURL(&WebNode{Url: srv.URL + "/dem.zip"}); err != nil {
+			t.Fatal(err)
+		}
+		e := mg.FinishManifest(nil).Entries[0]
+		data, _ := os.ReadFile(filepath.Join(dir, "dem.zip"))
+		if e.Error != "" || !bytes.Equal(data, body) || e.SHA256 != hex.EncodeToString(sum[:]) || e.Size != int64(len(body)) {
+			t.Fatalf("secure=%v: entry %+v, %d bytes on disk", secure, e, len(data))
+		}
+		// Three ranges, one of them resumed where it broke off.
+		if ranges.Load() != 4 {
+			t.Errorf("secure=%v: %d range requests", secure, ranges.Load())
+		}
+		if !last.Done || last.Segments != 4 || last.Total != int64(len(body)) || last.Bytes != int64(len(body)) {
+			t.Errorf("secure=%v: last progress %+v", secure, last)
+		}
+		if left, _ := os.ReadDir(filepath.Join(dir, quarantineDir)); len(left) != 0 {
+			t.Errorf("secure=%v: quarantine not emptied: %v", secure, left)
+		}
+	}
+}
+
+func TestSegmentedDownloadChanged(t *testing.T) {
+	segmentSettings(t)
+	body := strings.Repeat("x", 8<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Etag", `"v2"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Header.Get("If-Range") == `"v1"` || r.Header.Get("Range") == "" {
+			// Every range request sees a new version of the file.
+			if r.Header.Get("Range") == "" {
+				w.Header().Set("Etag", `"v1"`)
+			}
+			io.WriteString(w, body)
+			return
+		}
+		t.Errorf("unexpected request: %v", r.Header)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	mg := NewManager(Options{Query: "q", DownloadDir: dir})
+	if err := mg.DownloadURL(&WebNode{Url: srv.URL + "/dem.tif"}); err != nil {
+		t.Fatal(err)
+	}
+	e := mg.FinishManifest(nil).Entries[0]
+	if !strings.Contains(e.Error, errChanged.Error()) {
+		t.Fatalf("error %q", e.Error)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dem.tif")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial file left: %v", err)
+	}
+}
+
+func TestSegmentsFor(t *testing.T) {
+	segmentSettings(t)
+	activeConfig = DefaultConfig()
+	activeConfig.Hosts["slow.example.com"] = HostConfig{Concurrency: 2}
+	defer func() { activeConfig = DefaultConfig() }()
+	resp := func(host string, length int64, ranges string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, "https://"+host+"/dem.tif", nil)
+		return &http.Response{StatusCode: http.StatusOK, ContentLength: length, Request: req,
+			Header: http.Header{"Accept-Ranges": {ranges}}}
+	}
+	for _, tc := range []struct {
+		resp *http.Response
+		want int
+	}{
+		{resp("example.com", 1<<20, "bytes"), 4},
+		{resp("slow.example.com", 1<<20, "bytes"), 2},
+		{resp("example.com", 512, "bytes"), 1},
+		{resp("example.com", -1, "bytes"), 1},
+		{resp("example.com", 1<<20, "none"), 1},
+	} {
+		if got := segmentsFor(tc.resp); got != tc.want {
+			t.Errorf("%s (%d bytes): %d segments, want %d", tc.resp.Request.URL.Host, tc.resp.ContentLength, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	old := downloadLimiter
+	downloadLimiter = newRateLimiter(1 << 20)
+	defer func() { downloadLimiter = old }()
+	start := time.Now()
+	n, err := io.Copy(io.Discard, limitRate(strings.NewReader(strings.Repeat("x", 300<<10))))
+	if err != nil || n != 300<<10 {
+		t.Fatal(n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("300KB at 1MB/s took %v", elapsed)
+	}
+}
@@ -0,0 +1,1151 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes returned by the godl and downloader commands.
+const (
+	ExitOK        = 0 // success
+	ExitError     = 1 // runtime failure (network, disk, embedding service)
+	ExitUsage     = 2 // bad flags or arguments
+	ExitNoResults = 3 // the command ran but found nothing
+)
+
+// command is a godl subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands returns the godl subcommands in the order they are listed in the
+// usage text.
+func commands() []command {
+	return []command{
+		{"search", "rank cached seeds and datasets against a query and print them", runSearch},
+		{"crawl", "crawl the best-matching seeds and list (or download) datasets", runCrawl},
+		{"recrawl", "refresh the dataset catalog from every seed, skipping recently fetched pages", runRecrawl},
+		{"download", "download URLs or every entry of a manifest", runDownload},
+		{"seeds", "list, add or remove crawl seeds", runSeeds},
+		{"cache", "show, clear or rebuild the embedding cache", runCache},
+		{"config", "show the effective configuration and the files it is read from", runConfig},
+		{"serve", "run the HTTP search and download service", runServe},
+	}
+}
+
+// Run executes the godl command line and exits with its status code.
+func Run() {
+	os.Exit(Main(os.Args[1:]))
+}
+
+// Main dispatches args to a godl subcommand and returns the exit code. For
+// compatibility, arguments starting with a flag (godl -s "query" -download
+// dir) are treated as a crawl.
+func Main(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return ExitUsage
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		usage(os.Stdout)
+		return ExitOK
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return runCrawl(args)
+	}
+	for _, c := range commands() {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "godl: unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return ExitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: godl <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'godl <command> -h' for the flags of a command.")
+}
+
+// parseArgs parses flags that may appear before, between or after positional
+// arguments and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// newFlagSet returns a FlagSet that reports errors instead of exiting, so the
+// caller can return ExitUsage.
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// usageExit maps a flag parsing error to an exit code.
+func usageExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return ExitUsage
+}
+
+// openLog sends the standard logger to the crawl log file.
+func openLog() (io.Closer, error) {
+	if err := os.MkdirAll(filepath.Dir(findLinksLogPath), 0755); err != nil {
+		return nil, fmt.Errorf("creating log directory: %w", err)
+	}
+	return WriteToLog(findLinksLogPath)
+}
+
+// writeJSON prints v to stdout as indented JSON.
+func writeJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "godl: encoding output: %v\n", err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+// Result is the scripting-friendly form of a search or crawl result.
+type Result struct {
+	URL         string          `json:"url"`
+	Seed        string          `json:"seed,omitempty"`
+	Depth       int             `json:"depth"`
+	Score       float64         `json:"score,omitempty"`
+	Breakdown   *Score          `json:"score_breakdown,omitempty"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Found       *time.Time      `json:"found,omitempty"`   // when a crawl first discovered a known dataset
+	Checked     *time.Time      `json:"checked,omitempty"` // when a crawl last found it
+	Stale       bool            `json:"stale,omitempty"`   // not seen by a crawl within catalog.max_age
+}
+
+// NewResult converts a WebNode into a Result. JSON descriptions produced by
+// ExtractMetadata are passed through as metadata.
+func NewResult(n WebNode) Result {
+	r := Result{URL: n.Url, Depth: n.Depth, Score: n.CosineSimilarity}
+	if n.Relevance != nil {
+		r.Score, r.Breakdown = n.Relevance.Total, n.Relevance
+	}
+	if path := CrawlPath(&n); len(path) > 1 {
+		r.Seed = path[0]
+	}
+	if !n.context.Found.IsZero() {
+		found, checked := n.context.Found, n.context.Checked
+		r.Found, r.Checked = &found, &checked
+		r.Stale = n.context.Stale(catalogMaxAge)
+	}
+	desc := strings.TrimSpace(n.context.Description)
+	if desc != "" && json.Valid([]byte(desc)) {
+		r.Metadata = json.RawMessage(desc)
+	} else {
+		r.Description = desc
+	}
+	return r
+}
+
+// title returns a short label for a result in plain-text output.
+func (r Result) title() string {
+	var md downloadMetadata
+	if len(r.Metadata) > 0 && json.Unmarshal(r.Metadata, &md) == nil {
+		if md.Title != "" {
+			return md.Title
+		}
+		return md.Description
+	}
+	return r.Description
+}
+
+func printResults(w io.Writer, results []Result) {
+	for i, r := range results {
+		printResult(w, i+1, r)
+	}
+}
+
+// printSeedErrors prints the pages that failed per seed, by class of error.
+func printSeedErrors(w io.Writer, errs []SeedErrors) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nErrors by seed:")
+	for _, s := range errs {
+		fmt.Fprintf(w, "  %-50s %4d  %s\n", s.Seed, s.Total, s)
+	}
+}
+
+// printPlan prints the estimated download volume, largest seeds and formats
+// first, and what would exceed the quotas.
+func printPlan(w io.Writer, p *Plan) {
+	size := func(t PlanTotal) string {
+		s := FormatBytes(t.Bytes)
+		if t.Unknown > 0 {
+			s += fmt.Sprintf(" + %d of unknown size", t.Unknown)
+		}
+		return s
+	}
+	fmt.Fprintf(w, "\nDownload plan: %d files, %s", p.Total.Files, size(p.Total))
+	if p.Free >= 0 {
+		fmt.Fprintf(w, "; %s free", FormatBytes(p.Free))
+	}
+	fmt.Fprintln(w)
+	for _, part := range []struct {
+		name   string
+		totals []PlanTotal
+	}{{"By seed", p.BySeed}, {"By format", p.ByFormat}} {
+		fmt.Fprintf(w, "%s:\n", part.name)
+		for _, t := range part.totals {
+			fmt.Fprintf(w, "  %-50s %4d files  %s\n", t.Key, t.Files, size(t))
+		}
+	}
+	for _, it := range p.Items {
+		if it.Error != "" {
+			fmt.Fprintf(w, "  could not size %s: %s\n", it.URL, it.Error)
+		}
+	}
+	for _, q := range p.OverQuota {
+		fmt.Fprintf(w, "Over quota: %s\n", q)
+	}
+}
+
+func printResult(w io.Writer, n int, r Result) {
+	fmt.Fprintf(w, "%3d. %s\n", n, r.URL)
+	if b := r.Breakdown; b != nil {
+		var parts []string
+		for _, s := range []struct {
+			name  string
+			value float64
+		}{{"semantic", b.Semantic}, {"lexical", b.Lexical}, {"format", b.Format},
+			{"spatial", b.Spatial}, {"temporal", b.Temporal}, {"depth", b.Depth}} {
+			if s.value != 0 {
+				parts = append(parts, fmt.Sprintf("%s %.2f", s.name, s.value))
+			}
+		}
+		fmt.Fprintf(w, "     score: %.4f (%s)\n", b.Total, strings.Join(parts, ", "))
+	} else if r.Score != 0 {
+		fmt.Fprintf(w, "     score: %.4f\n", r.Score)
+	}
+	if r.Checked != nil {
+		note := ""
+		if r.Stale {
+			note = " (stale)"
+		}
+		fmt.Fprintf(w, "     known dataset, last seen %s%s\n", r.Checked.Format("2006-01-02"), note)
+	}
+	if t := r.title(); t != "" {
+		if len(t) > 160 {
+			t = t[:157] + "..."
+		}
+		fmt.Fprintf(w, "     %s\n", t)
+	}
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// progressPrinter prints crawl candidates as they are found, before they are
+// ranked, and keeps a progress line of the crawl or of the running download
+// at the bottom of the terminal.
+type progressPrinter struct {
+	out    io.Writer // candidates are printed here; nil to only show progress
+	status io.Writer // progress line
+	live   bool      // status is a terminal and the line is redrawn
+	drawn  time.Time
+}
+
+func newProgressPrinter(out io.Writer, status *os.File) *progressPrinter {
+	return &progressPrinter{out: out, status: status, live: isTerminal(status)}
+}
+
+func (p *progressPrinter) handle(ev Event) {
+	if ev.Kind == EventCandidate && p.out != nil {
+		p.clear()
+		fmt.Fprintf(p.out, "found: %s\n", ev.Node.Url)
+	}
+	if ev.Kind == EventDownload {
+		if p.live {
+			tr := ev.Transfer
+			size := "unknown size"
+			if tr.Total >= 0 {
+				size = FormatBytes(tr.Total)
+			}
+			fmt.Fprintf(p.status, "\r\033[Kdownloading %s: %s of %s over %d connection(s)",
+				path.Base(ev.Node.Url), FormatBytes(tr.Bytes), size, tr.Segments)
+			p.drawn = time.Now()
+		}
+		return
+	}
+	if p.live && (ev.Kind == EventCandidate || time.Since(p.drawn) > 100*time.Millisecond) {
+		pr := ev.Progress
+		fmt.Fprintf(p.status, "\r\033[Kcrawling: %d pages fetched, %d queued, %d found, %d errors",
+			pr.Fetched, pr.Queued, pr.Found, pr.Errors)
+		p.drawn = time.Now()
+	}
+}
+
+// clear removes the progress line.
+func (p *progressPrinter) clear() {
+	if p.live && !p.drawn.IsZero() {
+		fmt.Fprint(p.status, "\r\033[K")
+	}
+}
+
+// queryFrom joins positional arguments into a query, preferring an explicit
+// -s value.
+func queryFrom(flagValue string, positional []string) string {
+	if strings.TrimSpace(flagValue) != "" {
+		return strings.TrimSpace(flagValue)
+	}
+	return strings.TrimSpace(strings.Join(positional, " "))
+}
+
+// ------------------------------------------------------------------
+// godl search
+// ------------------------------------------------------------------
+
+func runSearch(args []string) int {
+	fs := newFlagSet("search", "godl search [flags] <query>")
+	query := fs.String("s", "", "Search query (alternatively given as arguments).")
+	top := fs.Int("top", 10, "Number of results to print.")
+	asJSON := fs.Bool("json", false, "Print results as JSON.")
+	cf := addConfigFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("godl search") {
+		return ExitError
+	}
+	q := queryFrom(*query, positional)
+	if q == "" {
+		fmt.Fprintln(os.Stderr, "godl search: a query is required")
+		fs.Usage()
+		return ExitUsage
+	}
+	logFile, err := openLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl search: %v\n", err)
+		return ExitError
+	}
+	defer logFile.Close()
+
+	mg := NewManager(Options{Query: q})
+	mg.Init()
+	emb, err := EmbedQuery(q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl search: embedding query: %v\n", err)
+		return ExitError
+	}
+	var results []Result
+	for _, n := range mg.RankHybrid(q, emb, *top) {
+		results = append(results, NewResult(n))
+	}
+	if *asJSON {
+		if code := writeJSON(results); code != ExitOK {
+			return code
+		}
+	} else {
+		printResults(os.Stdout, results)
+	}
+	if len(results) == 0 {
+		return ExitNoResults
+	}
+	return ExitOK
+}
+
+// ------------------------------------------------------------------
+// godl crawl
+// ------------------------------------------------------------------
+
+// crawlOutput is the JSON document printed by godl crawl --json.
+type crawlOutput struct {
+	Session    string       `json:"session"`
+	Query      string       `json:"query"`
+	Manifest   string       `json:"manifest,omitempty"`
+	Results    []Result     `json:"results"`
+	Plan       *Plan        `json:"plan,omitempty"`        // set by --dry-run
+	SeedErrors []SeedErrors `json:"seed_errors,omitempty"` // pages that failed, by seed
+}
+
+func runCrawl(args []string) int {
+	fs := newFlagSet("crawl", "godl crawl [flags] <query>")
+	query := fs.String("s", "", "Search query (alternatively given as arguments).")
+	downloadDir := fs.String("download", "", "Directory to download datasets to. If empty, only prints URLs.")
+	fs.StringVar(downloadDir, "d", "", "Shorthand for -download.")
+	noSec := fs.Bool("nosec", false, "Disable security sandboxing (enabled by default).")
+	manifestPath := fs.String("manifest", "", "Path of the JSON session manifest. Defaults to manifest-<session>.json in the download directory, or next to the log when not downloading.")
+	manifestCSV := fs.Bool("manifest-csv", false, "Also write the manifest as CSV next to the JSON file.")
+	inspect := fs.Bool("inspect", false, "List and classify the contents of downloaded zip archives.")
+	extract := fs.Bool("extract", false, "Safely extract downloaded zip archives (implies -inspect).")
+	top := fs.Int("top", 0, "Number of ranked results to print; 0 prints all.")
+	catalogFirst := fs.Bool("catalog-first", false, "Answer from datasets found by earlier crawls; crawl only when too few match or they are stale.")
+	resume := fs.String("resume", "", "Continue an interrupted session, given by its ID or checkpoint file, without fetching visited pages again.")
+	dryRun := fs.Bool("dry-run", false, "Download nothing; print how much data the results would take, per seed and format.")
+	asJSON := fs.Bool("json", false, "Print results as JSON.")
+	cf := addConfigFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("godl crawl") {
+		return ExitError
+	}
+	q := queryFrom(*query, positional)
+	var ck *Checkpoint
+	if *resume != "" {
+		if ck, err = LoadCheckpoint(*resume); err != nil {
+			fmt.Fprintf(os.Stderr, "godl crawl: resuming: %v\n", err)
+			return ExitError
+		}
+		if q != "" && q != ck.Query {
+			fmt.Fprintf(os.Stderr, "godl crawl: session %s searched for %q, not %q\n", ck.Session, ck.Query, q)
+			return ExitUsage
+		}
+		q = ck.Query
+		if *downloadDir == "" {
+			*downloadDir = ck.DownloadDir
+		}
+	}
+	if q == "" {
+		fmt.Fprintln(os.Stderr, "godl crawl: a query is required")
+		fs.Usage()
+		return ExitUsage
+	}
+	logFile, err := openLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl crawl: %v\n", err)
+		return ExitError
+	}
+	defer logFile.Close()
+
+	if *downloadDir != "" {
+		if err := os.MkdirAll(*downloadDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "godl crawl: creating %s: %v\n", *downloadDir, err)
+			return ExitError
+		}
+		if err := checkFreeSpace(*downloadDir); err != nil && !*dryRun {
+			fmt.Fprintf(os.Stderr, "godl crawl: %v\n", err)
+			return ExitError
+		}
+	}
+	// A dry run crawls without downloading; the directory is only used to
+	// report the free space.
+	saveDir := *downloadDir
+	if *dryRun {
+		saveDir = ""
+	}
+
+	progress := newProgressPrinter(os.Stderr, os.Stderr)
+	if *asJSON {
+		progress.out = nil
+	}
+	mg := NewManager(Options{
+		Query:        q,
+		DownloadDir:  saveDir,
+		Secure:       !*noSec,
+		Inspect:      *inspect,
+		Extract:      *extract,
+		Args:         os.Args[1:],
+		OnEvent:      progress.handle,
+		CatalogFirst: *catalogFirst,
+		Checkpoint:   true,
+	})
+	mg.Init()
+	if ck != nil {
+		mg.Resume(ck)
+	}
+	if !*asJSON {
+		fmt.Printf("Searching for: \"%s\"\n", q)
+	}
+
+	// An interrupted crawl keeps its checkpoint and reports what it found.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	downloadableLinks, err := mg.FindLinksContext(ctx)
+	interrupted := ctx.Err() != nil
+	stop()
+	progress.clear()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl crawl: embedding query: %v\n", err)
+		return ExitError
+	}
+	pr := mg.Progress()
+	if !*asJSON {
+		fmt.Printf("Fetched %d pages (%d errors), found %d datasets\n", pr.Fetched, pr.Errors, pr.Found)
+	}
+	log.Printf("For searchQuery '%v'", q)
+	log.Printf("	found %v URLs:", len(downloadableLinks))
+	for _, node := range downloadableLinks {
+		log.Println("		URL: ", node.Url)
+	}
+
+	out := crawlOutput{Query: q}
+	mf := mg.FinishManifest(downloadableLinks)
+	out.Session = mf.Session
+	out.Manifest = manifestLocation(*manifestPath, saveDir, mf.Session)
+	if err := writeManifestFiles(mf, out.Manifest, *manifestCSV); err != nil {
+		log.Printf("failed to write manifest: %v", err)
+		fmt.Fprintf(os.Stderr, "godl crawl: %v\n", err)
+		out.Manifest = ""
+	}
+	mg.Close(downloadableLinks)
+	if interrupted {
+		fmt.Fprintf(os.Stderr, "godl crawl: interrupted; continue with: godl crawl --resume %s\n", mf.Session)
+	} else if err := os.Remove(mg.checkpointPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove checkpoint: %v", err)
+	}
+
+	for i, n := range downloadableLinks {
+		if *top > 0 && i == *top {
+			break
+		}
+		out.Results = append(out.Results, NewResult(n))
+	}
+	if *dryRun {
+		planned := downloadableLinks
+		if *top > 0 && len(planned) > *top {
+			planned = planned[:*top]
+		}
+		out.Plan = PlanDownloads(planned, *downloadDir)
+	}
+	out.SeedErrors = mg.ErrorsBySeed()
+	if *asJSON {
+		if code := writeJSON(out); code != ExitOK {
+			return code
+		}
+	} else {
+		printResults(os.Stdout, out.Results)
+		if out.Plan != nil {
+			printPlan(os.Stdout, out.Plan)
+		}
+		printSeedErrors(os.Stdout, out.SeedErrors)
+		if out.Manifest != "" {
+			fmt.Println("Manifest written to", out.Manifest)
+		}
+	}
+	if len(out.Results) == 0 {
+		return ExitNoResults
+	}
+	return ExitOK
+}
+
+// ------------------------------------------------------------------
+// godl recrawl
+// ------------------------------------------------------------------
+
+// recrawlOutput is printed by godl recrawl --json.
+type recrawlOutput struct {
+	Progress
+	New        int          `json:"new"`                   // datasets added to the catalog
+	SeedErrors []SeedErrors `json:"seed_errors,omitempty"` // pages that failed, by seed
+}
+
+func runRecrawl(args []string) int {
+	fs := newFlagSet("recrawl", "godl recrawl [flags]")
+	asJSON := fs.Bool("json", false, "Print the summary as JSON.")
+	cf := addConfigFlags(fs)
+	if _, err := parseArgs(fs, args); err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("godl recrawl") {
+		return ExitError
+	}
+	logFile, err := openLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl recrawl: %v\n", err)
+		return ExitError
+	}
+	defer logFile.Close()
+
+	progress := newProgressPrinter(nil, os.Stderr)
+	mg := NewManager(Options{Args: os.Args[1:], OnEvent: progress.handle})
+	mg.Init()
+	before := len(mg.CachedURLEmbeddings)
+
+	// An interrupted recrawl still saves what it found.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	found := mg.Recrawl(ctx, recrawlAge)
+	progress.clear()
+	mg.Close(found)
+
+	out := recrawlOutput{Progress: mg.Progress(), New: len(mg.CachedURLEmbeddings) - before, SeedErrors: mg.ErrorsBySeed()}
+	if *asJSON {
+		return writeJSON(out)
+	}
+	fmt.Printf("Fetched %d pages (%d unchanged or recent, %d errors), found %d datasets, %d new\n",
+		out.Fetched, out.Reused, out.Errors, out.Found, out.New)
+	printSeedErrors(os.Stdout, out.SeedErrors)
+	return ExitOK
+}
+
+// manifestLocation returns where the session manifest is written: the
+// explicit path if given, else the download directory, else the log
+// directory.
+func manifestLocation(explicit, downloadDir, session string) string {
+	if explicit != "" {
+		return explicit
+	}
+	dir := downloadDir
+	if dir == "" {
+		dir = filepath.Dir(findLinksLogPath)
+	}
+	return filepath.Join(dir, "manifest-"+session+".json")
+}
+
+func writeManifestFiles(mf *Manifest, jsonPath string, withCSV bool) error {
+	var csvPath string
+	if withCSV {
+		csvPath = strings.TrimSuffix(jsonPath, filepath.Ext(jsonPath)) + ".csv"
+	}
+	return WriteManifest(mf, jsonPath, csvPath)
+}
+
+// ------------------------------------------------------------------
+// godl download
+// ------------------------------------------------------------------
+
+func runDownload(args []string) int {
+	fs := newFlagSet("download", "godl download [flags] <url|manifest.json>...")
+	dir := fs.String("d", ".", "Directory to download into.")
+	noSec := fs.Bool("nosec", false, "Disable security sandboxing (enabled by default).")
+	inspect := fs.Bool("inspect", false, "List and classify the contents of downloaded zip archives.")
+	extract := fs.Bool("extract", false, "Safely extract downloaded zip archives (implies -inspect).")
+	manifestPath := fs.String("manifest", "", "Path of the JSON manifest for this download session.")
+	dryRun := fs.Bool("dry-run", false, "Download nothing; print how much data the targets would take, per seed and format.")
+	asJSON := fs.Bool("json", false, "Print the manifest entries as JSON.")
+	cf := addConfigFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("godl download") {
+		return ExitError
+	}
+	if len(positional) == 0 {
+		fmt.Fprintln(os.Stderr, "godl download: at least one URL or manifest is required")
+		fs.Usage()
+		return ExitUsage
+	}
+	logFile, err := openLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl download: %v\n", err)
+		return ExitError
+	}
+	defer logFile.Close()
+	return downloadTargets("godl download", positional, Options{
+		DownloadDir: *dir,
+		Secure:      !*noSec,
+		Inspect:     *inspect,
+		Extract:     *extract,
+		Args:        os.Args[1:],
+	}, *manifestPath, *dryRun, *asJSON)
+}
+
+// downloadTargets downloads every URL in targets, expanding manifest files
+// into their entries, and writes a manifest for the session. A dry run only
+// prints the plan.
+func downloadTargets(prog string, targets []string, opts Options, manifestPath string, dryRun, asJSON bool) int {
+	if err := os.MkdirAll(opts.DownloadDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: creating %s: %v\n", prog, opts.DownloadDir, err)
+		return ExitError
+	}
+
+	var nodes []*WebNode
+	for _, t := range targets {
+		if strings.HasPrefix(t, "http://") || strings.HasPrefix(t, "https://") {
+			nodes = append(nodes, &WebNode{Url: t})
+			continue
+		}
+		mf, err := ReadManifest(t)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s is neither a URL nor a readable manifest: %v\n", prog, t, err)
+			return ExitUsage
+		}
+		if opts.Query == "" {
+			opts.Query = mf.Query
+		}
+		for _, e := range mf.Entries {
+			nodes = append(nodes, nodeFromEntry(e))
+		}
+	}
+
+	if dryRun {
+		planned := make([]WebNode, len(nodes))
+		for i, n := range nodes {
+			planned[i] = *n
+		}
+		plan := PlanDownloads(planned, opts.DownloadDir)
+		if asJSON {
+			return writeJSON(plan)
+		}
+		printPlan(os.Stdout, plan)
+		return ExitOK
+	}
+	if err := checkFreeSpace(opts.DownloadDir); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", prog, err)
+		return ExitError
+	}
+
+	progress := newProgressPrinter(nil, os.Stderr)
+	opts.OnEvent = progress.handle
+	mg := NewManager(opts)
+	failed := 0
+	for _, n := range nodes {
+		if err := mg.DownloadURL(n); err != nil {
+			progress.clear()
+			fmt.Fprintf(os.Stderr, "%s: %v\n", prog, err)
+			failed++
+		}
+	}
+	mf := mg.FinishManifest(nil)
+	progress.clear()
+	jsonPath := manifestLocation(manifestPath, opts.DownloadDir, mf.Session)
+	if err := WriteManifest(mf, jsonPath, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", prog, err)
+	}
+	if asJSON {
+		writeJSON(mf.Entries)
+	} else {
+		for _, e := range mf.Entries {
+			if e.Error != "" {
+				continue
+			}
+			fmt.Printf("%s -> %s (%d bytes)\n", e.SourceURL, e.LocalPath, e.Size)
+		}
+	}
+	for _, e := range mf.Entries {
+		if e.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return ExitError
+	}
+	return ExitOK
+}
+
+// nodeFromEntry rebuilds the crawl path of a manifest entry as a chain of
+// WebNodes so re-downloads keep their provenance.
+func nodeFromEntry(e ManifestEntry) *WebNode {
+	var parent *WebNode
+	for i, u := range e.CrawlPath {
+		if i == len(e.CrawlPath)-1 {
+			break
+		}
+		parent = &WebNode{Url: u, Parent: parent, Depth: i}
+	}
+	n := &WebNode{Url: e.SourceURL, Parent: parent}
+	if parent != nil {
+		n.Depth = parent.Depth + 1
+	}
+	if len(e.Metadata) > 0 {
+		n.context.Description = string(e.Metadata)
+	}
+	return n
+}
+
+// DownloadURL fetches node.Url and saves it into the download directory,
+// recording the result in the session manifest. Unlike crawl downloads, the
+// body is saved even when it does not look like a dataset.
+func (m *Manager) DownloadURL(node *WebNode) error {
+	resp, err := httpGet(node.Url)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return &StatusError{URL: node.Url, Code: resp.StatusCode, Status: resp.Status}
+	}
+	info := ClassifyResponse(resp)
+	if !info.Downloadable {
+		log.Printf("downloading %s although it looks like %q (%s)", node.Url, info.Format, info.MediaType)
+	}
+	m.downloads.Add(1)
+	m.downloadNode(resp, node, info)
+	return nil
+}
+
+// RunDownloader is the entry point of the standalone downloader tool:
+//
+//	downloader -u="https://host/file.zip" -d="/staging/mydir/"
+func RunDownloader(args []string) int {
+	fs := newFlagSet("downloader", "downloader -u <url> [-u <url>...] -d <dir>")
+	var urls multiFlag
+	fs.Var(&urls, "u", "URL or manifest to download (repeatable).")
+	dir := fs.String("d", ".", "Directory to download into.")
+	noSec := fs.Bool("nosec", false, "Disable security sandboxing (enabled by default).")
+	asJSON := fs.Bool("json", false, "Print the manifest entries as JSON.")
+	cf := addConfigFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("downloader") {
+		return ExitError
+	}
+	targets := append([]string(urls), positional...)
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "downloader: -u is required")
+		fs.Usage()
+		return ExitUsage
+	}
+	return downloadTargets("downloader", targets, Options{
+		DownloadDir: *dir,
+		Secure:      !*noSec,
+		Args:        args,
+	}, "", false, *asJSON)
+}
+
+// multiFlag collects every value of a repeatable string flag.
+type multiFlag []string
+
+func (f *multiFlag) String() string     { return strings.Join(*f, ",") }
+func (f *multiFlag) Set(v string) error { *f = append(*f, v); return nil }
+
+// ------------------------------------------------------------------
+// godl seeds
+// ------------------------------------------------------------------
+
+// seedInfo is one line of godl seeds list.
+type seedInfo struct {
+	URL         string `json:"url"`
+	Description string `json:"description"`
+	Builtin     bool   `json:"builtin"`
+	Embedded    bool   `json:"embedded"`
+}
+
+func runSeeds(args []string) int {
+	const synopsis = "godl seeds list [--json] | add <url> <description> | remove <url>"
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", synopsis)
+		return ExitUsage
+	}
+	fs := newFlagSet("seeds "+args[0], synopsis)
+	asJSON := fs.Bool("json", false, "Print as JSON.")
+	cf := addConfigFlags(fs)
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("godl seeds") {
+		return ExitError
+	}
+	logFile, err := openLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl seeds: %v\n", err)
+		return ExitError
+	}
+	defer logFile.Close()
+	cache, err := LoadCache(dataPath)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "godl seeds: %v\n", err)
+		return ExitError
+	}
+
+	switch args[0] {
+	case "list":
+		var seeds []seedInfo
+		for u, ctx := range PublicGeospatialDataSeeds {
+			_, embedded := cache[u]
+			seeds = append(seeds, seedInfo{URL: u, Description: ctx.Description, Builtin: true, Embedded: embedded})
+		}
+		// Seeds added with godl seeds add only live in the cache.
+		for u, ctx := range cache {
+			if _, builtin := PublicGeospatialDataSeeds[u]; !builtin && isSeed(u, ctx) {
+				seeds = append(seeds, seedInfo{URL: u, Description: ctx.Description, Embedded: true})
+			}
+		}
+		sort.Slice(seeds, func(i, j int) bool { return seeds[i].URL < seeds[j].URL })
+		if *asJSON {
+			return writeJSON(seeds)
+		}
+		for _, s := range seeds {
+			fmt.Printf("%s\n    %s\n", s.URL, s.Description)
+		}
+		return ExitOK
+
+	case "add":
+		if len(positional) < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: %s\n", synopsis)
+			return ExitUsage
+		}
+		u, desc := positional[0], strings.Join(positional[1:], " ")
+		emb, err := EmbedQuery(desc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "godl seeds add: embedding description: %v\n", err)
+			return ExitError
+		}
+		if cache == nil {
+			cache = make(map[string]DataContext)
+		}
+		cache[u] = DataContext{Description: desc, Embedding: emb}
+		if err := WriteToGob(dataPath, cache); err != nil {
+			fmt.Fprintf(os.Stderr, "godl seeds add: %v\n", err)
+			return ExitError
+		}
+		fmt.Println("added", u)
+		return ExitOK
+
+	case "remove":
+		if len(positional) != 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s\n", synopsis)
+			return ExitUsage
+		}
+		if _, ok := cache[positional[0]]; !ok {
+			fmt.Fprintf(os.Stderr, "godl seeds remove: %s is not cached\n", positional[0])
+			return ExitNoResults
+		}
+		delete(cache, positional[0])
+		if err := WriteToGob(dataPath, cache); err != nil {
+			fmt.Fprintf(os.Stderr, "godl seeds remove: %v\n", err)
+			return ExitError
+		}
+		fmt.Println("removed", positional[0])
+		return ExitOK
+	}
+	fmt.Fprintf(os.Stderr, "godl seeds: unknown subcommand %q\nUsage: %s\n", args[0], synopsis)
+	return ExitUsage
+}
+
+// ------------------------------------------------------------------
+// godl cache
+// ------------------------------------------------------------------
+
+// cacheInfo is printed by godl cache info.
+type cacheInfo struct {
+	Path     string `json:"path"`
+	Exists   bool   `json:"exists"`
+	Entries  int    `json:"entries"`
+	Seeds    int    `json:"seeds"`
+	Datasets int    `json:"datasets"`
+	Bytes    int64  `json:"bytes"`
+	Modified string `json:"modified,omitempty"`
+}
+
+func runCache(args []string) int {
+	const synopsis = "godl cache info [--json] | path | clear | rebuild"
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", synopsis)
+		return ExitUsage
+	}
+	fs := newFlagSet("cache "+args[0], synopsis)
+	asJSON := fs.Bool("json", false, "Print as JSON.")
+	cf := addConfigFlags(fs)
+	if _, err := parseArgs(fs, args[1:]); err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("godl cache") {
+		return ExitError
+	}
+
+	switch args[0] {
+	case "path":
+		fmt.Println(dataPath)
+		return ExitOK
+
+	case "info":
+		info := cacheInfo{Path: dataPath}
+		if st, err := os.Stat(dataPath); err == nil {
+			info.Exists = true
+			info.Bytes = st.Size()
+			info.Modified = st.ModTime().UTC().Format("2006-01-02T15:04:05Z")
+			cache, err := LoadCache(dataPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "godl cache info: %v\n", err)
+				return ExitError
+			}
+			info.Entries = len(cache)
+			for u, ctx := range cache {
+				if isSeed(u, ctx) {
+					info.Seeds++
+				} else {
+					info.Datasets++
+				}
+			}
+		}
+		if *asJSON {
+			return writeJSON(info)
+		}
+		fmt.Printf("path:     %s\nexists:   %v\nentries:  %d (%d seeds, %d datasets)\nsize:     %d bytes\nmodified: %s\n",
+			info.Path, info.Exists, info.Entries, info.Seeds, info.Datasets, info.Bytes, info.Modified)
+		return ExitOK
+
+	case "clear", "rebuild":
+		for _, p := range []string{dataPath, annPath(), pagesPath()} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "godl cache %s: %v\n", args[0], err)
+				return ExitError
+			}
+		}
+		if args[0] == "clear" {
+			fmt.Println("removed", dataPath)
+			return ExitOK
+		}
+		logFile, err := openLog()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "godl cache rebuild: %v\n", err)
+			return ExitError
+		}
+		defer logFile.Close()
+		mg := NewManager(Options{})
+		mg.Init()
+		if len(mg.CachedURLEmbeddings) == 0 {
+			fmt.Fprintln(os.Stderr, "godl cache rebuild: embedding the seeds failed, see the log")
+			return ExitError
+		}
+		fmt.Printf("rebuilt %s with %d seeds\n", dataPath, len(mg.CachedURLEmbeddings))
+		return ExitOK
+	}
+	fmt.Fprintf(os.Stderr, "godl cache: unknown subcommand %q\nUsage: %s\n", args[0], synopsis)
+	return ExitUsage
+}
+
+// ------------------------------------------------------------------
+// godl config
+// ------------------------------------------------------------------
+
+func runConfig(args []string) int {
+	const synopsis = "godl config show [--json] [flags] | files"
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", synopsis)
+		return ExitUsage
+	}
+	fs := newFlagSet("config "+args[0], synopsis)
+	asJSON := fs.Bool("json", false, "Print as JSON.")
+	cf := addConfigFlags(fs)
+	if _, err := parseArgs(fs, args[1:]); err != nil {
+		return usageExit(err)
+	}
+
+	switch args[0] {
+	case "files":
+		files := ConfigFiles()
+		if cf.path != "" {
+			files = []string{cf.path}
+		} else if p := os.Getenv("GODL_CONFIG"); p != "" {
+			files = []string{p}
+		}
+		for _, f := range files {
+			_, err := os.Stat(f)
+			fmt.Printf("%s (exists: %v)\n", f, err == nil)
+		}
+		return ExitOK
+
+	case "show":
+		cfg, err := cf.load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "godl config show: %v\n", err)
+			return ExitError
+		}
+		if *asJSON {
+			return writeJSON(cfg.Values())
+		}
+		cfg.WriteTOML(os.Stdout)
+		hosts, err := cfg.CredentialHosts()
+		if len(hosts) > 0 {
+			fmt.Printf("\n# credentials for %s (not shown)\n", strings.Join(hosts, ", "))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "godl config show: credentials: %v\n", err)
+			return ExitError
+		}
+		return ExitOK
+	}
+	fmt.Fprintf(os.Stderr, "godl config: unknown subcommand %q\nUsage: %s\n", args[0], synopsis)
+	return ExitUsage
+}
+
+// ------------------------------------------------------------------
+// godl serve
+// ------------------------------------------------------------------
+
+func runServe(args []string) int {
+	fs := newFlagSet("serve", "godl serve [flags]")
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on.")
+	dir := fs.String("d", "downloads", "Directory downloads are saved to, one subdirectory per job.")
+	maxJobs := fs.Int("jobs", 2, "Number of crawl jobs run at once; further jobs are queued.")
+	noSec := fs.Bool("nosec", false, "Disable security sandboxing (enabled by default).")
+	cf := addConfigFlags(fs)
+	if _, err := parseArgs(fs, args); err != nil {
+		return usageExit(err)
+	}
+	if !cf.setup("godl serve") {
+		return ExitError
+	}
+	logFile, err := openLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godl serve: %v\n", err)
+		return ExitError
+	}
+	defer logFile.Close()
+
+	warm := NewManager(Options{})
+	warm.Init()
+	if len(warm.CachedURLEmbeddings) == 0 {
+		fmt.Fprintln(os.Stderr, "godl serve: the embedding cache is empty, see the log")
+		return ExitError
+	}
+	srv := NewServer(warm, *dir, *maxJobs, !*noSec)
+	hs := &http.Server{Addr: *addr, Handler: srv.Handler()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- hs.ListenAndServe() }()
+	fmt.Printf("godl serve: listening on http://%s (%d cached entries)\n", *addr, len(warm.CachedURLEmbeddings))
+
+	select {
+	case err := <-errc:
+		fmt.Fprintf(os.Stderr, "godl serve: %v\n", err)
+		return ExitError
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	hs.Shutdown(shutdownCtx)
+	srv.Shutdown()
+	return ExitOK
+}
@@ -0,0 +1,131 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgsInterspersed(t *testing.T) {
+	fs := newFlagSet("search", "godl search")
+	top := fs.Int("top", 10, "")
+	asJSON := fs.Bool("json", false, "")
+	positional, err := parseArgs(fs, []string{"elevation", "--top", "5", "ohio", "--json"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if *top != 5 || !*asJSON || !reflect.DeepEqual(positional, []string{"elevation", "ohio"}) {
+		t.Fatalf("got top=%d json=%v positional=%v", *top, *asJSON, positional)
+	}
+}
+
+func TestMainExitCodes(t *testing.T) {
+	if code := Main([]string{"nonsense"}); code != ExitUsage {
+		t.Fatalf("unknown command: got %d want %d", code, ExitUsage)
+	}
+	if code := Main([]string{"search", "--no-such-flag"}); code != ExitUsage {
+		t.Fatalf("bad flag: got %d want %d", code, ExitUsage)
+	}
+	if code := Main([]string{"help"}); code != ExitOK {
+		t.Fatalf("help: got %d want %d", code, ExitOK)
+	}
+}
+
+func TestDownloadTargetsFromManifest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("PK\x03\x04data"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	src := &Manifest{Session: "old", Query: "counties", Entries: []ManifestEntry{{
+		Query:     "counties",
+		Seed:      "https://example.com/",
+		CrawlPath: []string{"https://example.com/", ts.URL + "/counties.zip"},
+		SourceURL: ts.URL + "/counties.zip",
+	}}}
+	srcPath := filepath.Join(dir, "old.json")
+	if err := WriteManifest(src, srcPath, ""); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	newManifest := filepath.Join(dir, "new.json")
+	code := downloadTargets("test", []string{srcPath}, Options{DownloadDir: outDir}, newManifest, false, false)
+	if code != ExitOK {
+		t.Fatalf("got exit code %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "counties.zip")); err != nil {
+		t.Fatalf("file not downloaded: %v", err)
+	}
+	mf, err := ReadManifest(newManifest)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if len(mf.Entries) != 1 || mf.Entries[0].Seed != "https://example.com/" || mf.Query != "counties" {
+		t.Fatalf("provenance lost: %+v", mf)
+	}
+}
+
+func TestSeedsAddThenList(t *testing.T) {
+	useFakeEmbedder(t)
+	old := activeConfig
+	t.Cleanup(func() {
+		old.Apply()
+		allowPrivate = true
+	})
+	t.Setenv("GODL_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	flags := []string{"--cache", filepath.Join(dir, "cache.gob"), "--log", filepath.Join(dir, "godl.log"), "--embedder", embedEndpoint}
+
+	add := append([]string{"seeds", "add", "https://gis.example.gov/", "county", "parcels"}, flags...)
+	if code := Main(add); code != ExitOK {
+		t.Fatalf("seeds add: exit %d", code)
+	}
+
+	var seeds []seedInfo
+	mainJSON(t, append([]string{"seeds", "list", "--json"}, flags...), &seeds)
+	listed := false
+	for _, s := range seeds {
+		if s.URL == "https://gis.example.gov/" {
+			if s.Builtin || !s.Embedded || s.Description != "county parcels" {
+				t.Fatalf("added seed listed as %+v", s)
+			}
+			listed = true
+		}
+	}
+	if !listed {
+		t.Fatalf("added seed missing from %d seeds", len(seeds))
+	}
+
+	var info cacheInfo
+	mainJSON(t, append([]string{"cache", "info", "--json"}, flags...), &info)
+	if info.Entries != 1 || info.Seeds != 1 || info.Datasets != 0 {
+		t.Fatalf("cache info %+v", info)
+	}
+}
+
+// mainJSON runs the command args and decodes what it prints into out.
+func mainJSON(t *testing.T, args []string, out interface{}) {
+	t.Helper()
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	code := Main(args)
+	os.Stdout = stdout
+	w.Close()
+	data, _ := io.ReadAll(r)
+	if code != ExitOK {
+		t.Fatalf("%v: exit %d", args, code)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatalf("%v: decode %q: %v", args, data, err)
+	}
+}
@@ -0,0 +1,954 @@
+package crawler
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings shared by every godl command. Values are layered:
+// built-in defaults, then config files, then GODL_* environment variables,
+// then command-line flags.
+type Config struct {
+	CachePath            string                    // embedding cache (gob)
+	LogPath              string                    // crawl log
+	MaxCrawl             int                       // pages visited per crawl
+	MaxDepth             int                       // links followed away from a seed
+	CrawlWorkers         int                       // concurrent page fetches
+	DownloadWorkers      int                       // concurrent downloads
+	TopSeeds             int                       // best-matching seeds a crawl starts from
+	Embedder             string                    // URL of the embedding service
+	UserAgent            string                    // User-Agent sent with every request
+	Contact              string                    // URL or e-mail address added to the User-Agent
+	ConnectTimeout       time.Duration             // time to connect and complete the TLS handshake
+	HeaderTimeout        time.Duration             // time for the response headers to arrive once the request is sent
+	RequestTimeout       time.Duration             // time for a page, retries and body included
+	Proxy                string                    // proxy URL, "env" for HTTP(S)_PROXY, or empty for none
+	CABundle             string                    // PEM certificates trusted besides the system roots; empty for none
+	HTTP2                bool                      // use HTTP/2 with servers that offer it
+	HTTP2Ping            time.Duration             // idle time after which an HTTP/2 connection is checked with a ping
+	MaxConnsPerHost      int                       // connections per host at a time; 0 for no limit
+	MaxIdleConnsPerHost  int                       // idle connections kept per host for reuse
+	AllowPrivate         bool                      // allow requests to private, loopback and link-local addresses
+	AllowedSchemes       []string                  // URL schemes crawled and downloaded
+	MaxRedirects         int                       // redirects followed per request
+	MaxPageBytes         int64                     // largest page parsed for links
+	Retries              int                       // retries of a request that timed out, lost its connection or got a 503 or similar
+	RetryBase            time.Duration             // backoff before the first retry, doubled for each later one
+	RetryMax             time.Duration             // longest backoff, including one asked for by Retry-After
+	MaxFileBytes         int64                     // largest file downloaded; 0 for no limit
+	MaxTotalBytes        int64                     // bytes downloaded per session; 0 for no limit
+	MinFreeBytes         int64                     // free space downloads must leave in the download directory
+	Segments             int                       // connections a large file is downloaded over
+	SegmentMinBytes      int64                     // smallest file downloaded in segments
+	SegmentRetries       int                       // retries of a failed segment
+	RateLimit            int64                     // bytes per second downloaded, across all files; 0 for no limit
+	Layout               string                    // where downloads go below the download directory: a preset or template
+	Overwrite            bool                      // replace existing files instead of saving under a new name
+	NetrcPath            string                    // .netrc with logins per host; empty for none
+	CredentialsPath      string                    // credential file with logins, tokens and API keys per host; empty for none
+	LoginHosts           []string                  // login services data hosts redirect to, such as Earthdata Login
+	ANNEf                int                       // candidates examined per nearest neighbour query
+	ExactBelow           int                       // caches smaller than this are searched exhaustively
+	CatalogMinResults    int                       // fresh catalog matches that make a crawl unnecessary
+	CatalogMaxAge        time.Duration             // how long a catalog entry stays fresh after a crawl saw it
+	CatalogMinSimilarity float64                   // similarity a catalog entry needs to match without sharing a term
+	RecrawlAge           time.Duration             // pages godl recrawl leaves alone when fetched more recently
+	CheckpointInterval   time.Duration             // how often a crawl saves its state for --resume
+	NearDuplicateBits    int                       // SimHash bits two pages may differ in and still count as copies
+	Scope                ScopeConfig               // which pages a crawl follows from a seed
+	Seeds                map[string]ScopeOverrides // per-seed scope settings, keyed by the seed's host
+	Hosts                map[string]HostConfig     // per-host overrides, keyed by host name
+
+	origin map[string]string // key -> file or variable that last set it
+	files  []string          // config files that were read, in order
+}
+
+// HostConfig overrides request settings for one host and its subdomains.
+type HostConfig struct {
+	UserAgent   string        // replaces Config.UserAgent when set
+	Concurrency int           // requests in flight at once; 0 for no limit
+	Delay       time.Duration // minimum gap between the start of two requests
+	DropParams  []string      // query parameters removed from URLs before they are compared or fetched
+	KeepParams  []string      // when set, the only query parameters kept
+}
+
+// activeConfig is the configuration installed by the last call to Apply.
+var activeConfig = DefaultConfig()
+
+// maxDepth bounds how far VisitNode follows links away from a seed.
+var maxDepth = activeConfig.MaxDepth
+
+// embedEndpoint is the URL embedding requests are posted to.
+var embedEndpoint = activeConfig.Embedder
+
+// DefaultConfig returns the built-in settings, with the cache and log under
+// the XDG cache and state directories.
+func DefaultConfig() *Config {
+	return &Config{
+		CachePath:            filepath.Join(xdgDir("XDG_CACHE_HOME", ".cache"), "godl", "data.gob"),
+		LogPath:              filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state")), "godl", "findLinks.log"),
+		MaxCrawl:             600,
+		MaxDepth:             4,
+		CrawlWorkers:         40,
+		DownloadWorkers:      40,
+		TopSeeds:             10,
+		Embedder:             "http://localhost:8000/embed",
+		UserAgent:            "godl/0.1",
+		ConnectTimeout:       10 * time.Second,
+		HeaderTimeout:        30 * time.Second,
+		RequestTimeout:       2 * time.Minute,
+		HTTP2:                true,
+		HTTP2Ping:            30 * time.Second,
+		MaxConnsPerHost:      16,
+		MaxIdleConnsPerHost:  8,
+		AllowedSchemes:       []string{"http", "https"},
+		MaxRedirects:         10,
+		MaxPageBytes:         10 << 20,
+		Retries:              3,
+		RetryBase:            500 * time.Millisecond,
+		RetryMax:             30 * time.Second,
+		MinFreeBytes:         1 << 30,
+		Segments:             4,
+		SegmentMinBytes:      64 << 20,
+		SegmentRetries:       3,
+		Layout:               "flat",
+		NetrcPath:            defaultNetrc(),
+		CredentialsPath:      filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "godl", "credentials.toml"),
+		LoginHosts:           []string{"urs.earthdata.nasa.gov", "uat.urs.earthdata.nasa.gov"},
+		ANNEf:                64,
+		ExactBelow:           10000,
+		CatalogMinResults:    5,
+		CatalogMaxAge:        30 * 24 * time.Hour,
+		CatalogMinSimilarity: 0.5,
+		RecrawlAge:           24 * time.Hour,
+		CheckpointInterval:   30 * time.Second,
+		NearDuplicateBits:    3,
+		Scope:                ScopeConfig{SameDomain: true},
+		Seeds:                map[string]ScopeOverrides{},
+		Hosts:                map[string]HostConfig{},
+		origin:               map[string]string{},
+	}
+}
+
+// xdgDir returns the directory named by the XDG variable env, or fallback
+// relative to the home directory. Relative values are ignored, as the XDG
+// base directory specification requires.
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), fallback)
+	}
+	return filepath.Join(home, fallback)
+}
+
+// ConfigFiles returns the config files godl reads, least important first:
+// config.toml in each of $XDG_CONFIG_DIRS (default /etc/xdg) and then in
+// $XDG_CONFIG_HOME (default ~/.config), each under a godl directory.
+func ConfigFiles() []string {
+	dirs := os.Getenv("XDG_CONFIG_DIRS")
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+	var files []string
+	list := filepath.SplitList(dirs)
+	for i := len(list) - 1; i >= 0; i-- {
+		if filepath.IsAbs(list[i]) {
+			files = append(files, filepath.Join(list[i], "godl", "config.toml"))
+		}
+	}
+	return append(files, filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "godl", "config.toml"))
+}
+
+// configField describes one setting: its key in the config file, the
+// environment variable and flag that override it, and how it is read and
+// written.
+type configField struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, v string) error
+}
+
+var configFields = []configField{
+	{"paths.cache", "GODL_CACHE", "cache", "Path of the embedding cache.",
+		func(c *Config) string { return c.CachePath },
+		func(c *Config, v string) error { return setPath(&c.CachePath, v) }},
+	{"paths.log", "GODL_LOG", "log", "Path of the crawl log.",
+		func(c *Config) string { return c.LogPath },
+		func(c *Config, v string) error { return setPath(&c.LogPath, v) }},
+	{"limits.max_crawl", "GODL_MAX_CRAWL", "max-crawl", "Maximum number of pages visited per crawl.",
+		func(c *Config) string { return strconv.Itoa(c.MaxCrawl) },
+		func(c *Config, v string) error { return setPositive(&c.MaxCrawl, v) }},
+	{"limits.max_depth", "GODL_MAX_DEPTH", "max-depth", "Maximum number of links followed away from a seed.",
+		func(c *Config) string { return strconv.Itoa(c.MaxDepth) },
+		func(c *Config, v string) error { return setPositive(&c.MaxDepth, v) }},
+	{"limits.crawl_workers", "GODL_CRAWL_WORKERS", "crawl-workers", "Number of pages fetched concurrently.",
+		func(c *Config) string { return strconv.Itoa(c.CrawlWorkers) },
+		func(c *Config, v string) error { return setPositive(&c.CrawlWorkers, v) }},
+	{"limits.download_workers", "GODL_DOWNLOAD_WORKERS", "download-workers", "Number of files downloaded concurrently.",
+		func(c *Config) string { return strconv.Itoa(c.DownloadWorkers) },
+		func(c *Config, v string) error { return setPositive(&c.DownloadWorkers, v) }},
+	{"limits.top_seeds", "GODL_TOP_SEEDS", "top-seeds", "Number of best-matching seeds a crawl starts from.",
+		func(c *Config) string { return strconv.Itoa(c.TopSeeds) },
+		func(c *Config, v string) error { return setPositive(&c.TopSeeds, v) }},
+	{"embedder.endpoint", "GODL_EMBEDDER", "embedder", "URL of the embedding service.",
+		func(c *Config) string { return c.Embedder },
+		func(c *Config, v string) error { c.Embedder = v; return nil }},
+	{"http.user_agent", "GODL_USER_AGENT", "user-agent", "User-Agent header sent with every request.",
+		func(c *Config) string { return c.UserAgent },
+		func(c *Config, v string) error { c.UserAgent = v; return nil }},
+	{"http.contact", "GODL_CONTACT", "contact", "URL or e-mail address added to the User-Agent, so site operators can reach you.",
+		func(c *Config) string { return c.Contact },
+		func(c *Config, v string) error { c.Contact = v; return nil }},
+	{"http.connect_timeout", "GODL_CONNECT_TIMEOUT", "connect-timeout", "Time to connect to a server and complete the TLS handshake, e.g. 10s.",
+		func(c *Config) string { return c.ConnectTimeout.String() },
+		func(c *Config, v string) error { return setDuration(&c.ConnectTimeout, v) }},
+	{"http.header_timeout", "GODL_HEADER_TIMEOUT", "header-timeout", "Time for the response headers to arrive once a request is sent, e.g. 30s.",
+		func(c *Config) string { return c.HeaderTimeout.String() },
+		func(c *Config, v string) error { return setDuration(&c.HeaderTimeout, v) }},
+	{"http.timeout", "GODL_TIMEOUT", "timeout", "Time to fetch a page, retries and body included, e.g. 2m; downloads are not limited.",
+		func(c *Config) string { return c.RequestTimeout.String() },
+		func(c *Config, v string) error { return setDuration(&c.RequestTimeout, v) }},
+	{"http.proxy", "GODL_PROXY", "proxy", "Proxy for every request, e.g. http://proxy.example.gov:3128; \"env\" for HTTP_PROXY, HTTPS_PROXY and NO_PROXY; empty for none.",
+		func(c *Config) string { return c.Proxy },
+		func(c *Config, v string) error { return setProxy(&c.Proxy, v) }},
+	{"http.ca_bundle", "GODL_CA_BUNDLE", "ca-bundle", "PEM file of certificate authorities trusted besides the system ones; empty for none.",
+		func(c *Config) string { return c.CABundle },
+		func(c *Config, v string) error { return setOptionalPath(&c.CABundle, v) }},
+	{"http.http2", "GODL_HTTP2", "http2", "Use HTTP/2 with servers that offer it.",
+		func(c *Config) string { return strconv.FormatBool(c.HTTP2) },
+		func(c *Config, v string) error { return setBool(&c.HTTP2, v) }},
+	{"http.http2_ping", "GODL_HTTP2_PING", "http2-ping", "Idle time after which an HTTP/2 connection is checked with a ping, so dead ones are dropped.",
+		func(c *Config) string { return c.HTTP2Ping.String() },
+		func(c *Config, v string) error { return setDuration(&c.HTTP2Ping, v) }},
+	{"http.max_conns_per_host", "GODL_MAX_CONNS_PER_HOST", "max-conns-per-host", "Connections to one host at a time; 0 for no limit.",
+		func(c *Config) string { return strconv.Itoa(c.MaxConnsPerHost) },
+		func(c *Config, v string) error { return setCount(&c.MaxConnsPerHost, v) }},
+	{"http.max_idle_per_host", "GODL_MAX_IDLE_PER_HOST", "max-idle-per-host", "Idle connections kept open per host for reuse.",
+		func(c *Config) string { return strconv.Itoa(c.MaxIdleConnsPerHost) },
+		func(c *Config, v string) error { return setPositive(&c.MaxIdleConnsPerHost, v) }},
+	{"http.allow_private", "GODL_ALLOW_PRIVATE", "allow-private", "Allow requests to private, loopback and link-local addresses, e.g. to crawl a local test server.",
+		func(c *Config) string { return strconv.FormatBool(c.AllowPrivate) },
+		func(c *Config, v string) error { return setBool(&c.AllowPrivate, v) }},
+	{"http.allowed_schemes", "GODL_ALLOWED_SCHEMES", "allowed-schemes", "Comma-separated URL schemes that are crawled and downloaded: http, https or both.",
+		func(c *Config) string { return strings.Join(c.AllowedSchemes, ",") },
+		func(c *Config, v string) error { return setSchemes(&c.AllowedSchemes, v) }},
+	{"http.max_redirects", "GODL_MAX_REDIRECTS", "max-redirects", "Redirects followed per request.",
+		func(c *Config) string { return strconv.Itoa(c.MaxRedirects) },
+		func(c *Config, v string) error { return setCount(&c.MaxRedirects, v) }},
+	{"http.max_page_bytes", "GODL_MAX_PAGE_BYTES", "max-page-bytes", "Largest page parsed for links, e.g. 10MB.",
+		func(c *Config) string { return strconv.FormatInt(c.MaxPageBytes, 10) },
+		func(c *Config, v string) error { return setSize(&c.MaxPageBytes, v) }},
+	{"http.retries", "GODL_RETRIES", "retries", "Retries of a request that timed out, lost its connection or got a 408, 425, 429, 500, 502, 503 or 504 response; 0 to not retry.",
+		func(c *Config) string { return strconv.Itoa(c.Retries) },
+		func(c *Config, v string) error { return setCount(&c.Retries, v) }},
+	{"http.retry_base", "GODL_RETRY_BASE", "retry-base", "Backoff before the first retry, e.g. 500ms; doubled, with jitter, for each later one.",
+		func(c *Config) string { return c.RetryBase.String() },
+		func(c *Config, v string) error { return setDuration(&c.RetryBase, v) }},
+	{"http.retry_max", "GODL_RETRY_MAX", "retry-max", "Longest backoff between retries, also the longest Retry-After honoured, e.g. 30s.",
+		func(c *Config) string { return c.RetryMax.String() },
+		func(c *Config, v string) error { return setDuration(&c.RetryMax, v) }},
+	{"download.max_file_bytes", "GODL_MAX_FILE_BYTES", "max-file-bytes", "Largest file downloaded, e.g. 20GB; 0 for no limit.",
+		func(c *Config) string { return strconv.FormatInt(c.MaxFileBytes, 10) },
+		func(c *Config, v string) error { return setQuota(&c.MaxFileBytes, v) }},
+	{"download.max_total_bytes", "GODL_MAX_TOTAL_BYTES", "max-total-bytes", "Bytes downloaded per session, e.g. 100GB; 0 for no limit.",
+		func(c *Config) string { return strconv.FormatInt(c.MaxTotalBytes, 10) },
+		func(c *Config, v string) error { return setQuota(&c.MaxTotalBytes, v) }},
+	{"download.min_free_bytes", "GODL_MIN_FREE_BYTES", "min-free-bytes", "Free space downloads must leave in the download directory, e.g. 1GB.",
+		func(c *Config) string { return strconv.FormatInt(c.MinFreeBytes, 10) },
+		func(c *Config, v string) error { return setQuota(&c.MinFreeBytes, v) }},
+	{"download.segments", "GODL_SEGMENTS", "segments", "Connections a large file is downloaded over when its server accepts range requests; 1 for one.",
+		func(c *Config) string { return strconv.Itoa(c.Segments) },
+		func(c *Config, v string) error { return setPositive(&c.Segments, v) }},
+	{"download.segment_min_bytes", "GODL_SEGMENT_MIN_BYTES", "segment-min-bytes", "Smallest file downloaded in segments, e.g. 64MB.",
+		func(c *Config) string { return strconv.FormatInt(c.SegmentMinBytes, 10) },
+		func(c *Config, v string) error { return setSize(&c.SegmentMinBytes, v) }},
+	{"download.segment_retries", "GODL_SEGMENT_RETRIES", "segment-retries", "Times a failed segment is requested again, from where it stopped.",
+		func(c *Config) string { return strconv.Itoa(c.SegmentRetries) },
+		func(c *Config, v string) error { return setCount(&c.SegmentRetries, v) }},
+	{"download.rate_limit", "GODL_RATE_LIMIT", "rate-limit", "Bytes per second downloaded across all files, e.g. 10MB; 0 for no limit.",
+		func(c *Config) string { return strconv.FormatInt(c.RateLimit, 10) },
+		func(c *Config, v string) error { return setQuota(&c.RateLimit, v) }},
+	{"download.layout", "GODL_LAYOUT", "layout", "Where downloads are saved below the download directory: flat, host, source or a template of {host}, {path}, {name}, {dataset} and {source}.",
+		func(c *Config) string { return c.Layout },
+		func(c *Config, v string) error { return setLayout(&c.Layout, v) }},
+	{"download.overwrite", "GODL_OVERWRITE", "overwrite", "Replace existing files with different content instead of saving downloads under a numbered name.",
+		func(c *Config) string { return strconv.FormatBool(c.Overwrite) },
+		func(c *Config, v string) error { return setBool(&c.Overwrite, v) }},
+
+	{"auth.netrc", "GODL_NETRC", "netrc", "The .netrc file with logins per host; empty for none. Defaults to $NETRC or ~/.netrc.",
+		func(c *Config) string { return c.NetrcPath },
+		func(c *Config, v string) error { return setOptionalPath(&c.NetrcPath, v) }},
+	{"auth.credentials", "GODL_CREDENTIALS", "credentials", "Credential file with logins, bearer tokens and API keys per host; empty for none.",
+		func(c *Config) string { return c.CredentialsPath },
+		func(c *Config, v string) error { return setOptionalPath(&c.CredentialsPath, v) }},
+	{"auth.login_hosts", "GODL_LOGIN_HOSTS", "login-hosts", "Comma-separated login services, such as Earthdata Login, whose pages mean a download was refused.",
+		func(c *Config) string { return strings.Join(c.LoginHosts, ",") },
+		func(c *Config, v string) error { c.LoginHosts = splitList(v); return nil }},
+	{"search.ann_ef", "GODL_ANN_EF", "ann-ef", "Candidates examined per nearest neighbour query; higher is more accurate and slower.",
+		func(c *Config) string { return strconv.Itoa(c.ANNEf) },
+		func(c *Config, v string) error { return setPositive(&c.ANNEf, v) }},
+	{"search.exact_below", "GODL_EXACT_BELOW", "exact-below", "Caches with fewer entries are searched exhaustively instead of through the index.",
+		func(c *Config) string { return strconv.Itoa(c.ExactBelow) },
+		func(c *Config, v string) error { return setPositive(&c.ExactBelow, v) }},
+	{"catalog.min_results", "GODL_CATALOG_MIN_RESULTS", "catalog-min-results", "Fresh known datasets that answer a catalog-first crawl without crawling.",
+		func(c *Config) string { return strconv.Itoa(c.CatalogMinResults) },
+		func(c *Config, v string) error { return setPositive(&c.CatalogMinResults, v) }},
+	{"catalog.max_age", "GODL_CATALOG_MAX_AGE", "catalog-max-age", "How long a known dataset counts as fresh after a crawl last found it.",
+		func(c *Config) string { return c.CatalogMaxAge.String() },
+		func(c *Config, v string) error { return setDuration(&c.CatalogMaxAge, v) }},
+	{"catalog.min_similarity", "GODL_CATALOG_MIN_SIMILARITY", "catalog-min-similarity", "Similarity a known dataset needs to match a query it shares no term with.",
+		func(c *Config) string { return strconv.FormatFloat(c.CatalogMinSimilarity, 'g', -1, 64) },
+		func(c *Config, v string) error { return setFraction(&c.CatalogMinSimilarity, v) }},
+	{"recrawl.max_age", "GODL_RECRAWL_MAX_AGE", "recrawl-max-age", "Pages godl recrawl fetched more recently than this are not fetched again.",
+		func(c *Config) string { return c.RecrawlAge.String() },
+		func(c *Config, v string) error { return setDuration(&c.RecrawlAge, v) }},
+	{"crawl.checkpoint_interval", "GODL_CHECKPOINT_INTERVAL", "checkpoint-interval", "How often a crawl saves its state so it can be resumed.",
+		func(c *Config) string { return c.CheckpointInterval.String() },
+		func(c *Config, v string) error { return setDuration(&c.CheckpointInterval, v) }},
+	{"crawl.near_duplicate_bits", "GODL_NEAR_DUPLICATE_BITS", "near-duplicate-bits", "Pages whose text fingerprints differ in at most this many of 64 bits are crawled once.",
+		func(c *Config) string { return strconv.Itoa(c.NearDuplicateBits) },
+		func(c *Config, v string) error { return setBits(&c.NearDuplicateBits, v) }},
+	{"scope.same_domain", "GODL_SAME_DOMAIN", "same-domain", "Only crawl pages on the registrable domain of their seed, e.g. usgs.gov.",
+		func(c *Config) string { return c.Scope.get("same_domain") },
+		func(c *Config, v string) error { return c.Scope.set("same_domain", v) }},
+	{"scope.allow_hosts", "GODL_ALLOW_HOSTS", "allow-hosts", "Comma-separated hosts, with their subdomains, crawled in addition to the seed's domain.",
+		func(c *Config) string { return c.Scope.get("allow_hosts") },
+		func(c *Config, v string) error { return c.Scope.set("allow_hosts", v) }},
+	{"scope.include", "GODL_INCLUDE", "include", "Regular expression page URLs must match to be crawled.",
+		func(c *Config) string { return c.Scope.get("include") },
+		func(c *Config, v string) error { return c.Scope.set("include", v) }},
+	{"scope.exclude", "GODL_EXCLUDE", "exclude", "Regular expression of page URLs that are not crawled.",
+		func(c *Config) string { return c.Scope.get("exclude") },
+		func(c *Config, v string) error { return c.Scope.set("exclude", v) }},
+	{"scope.path_prefixes", "GODL_PATH_PREFIXES", "path-prefixes", "Comma-separated URL paths; when set, only pages below one of them are crawled.",
+		func(c *Config) string { return c.Scope.get("path_prefixes") },
+		func(c *Config, v string) error { return c.Scope.set("path_prefixes", v) }},
+	{"scope.max_pages", "GODL_SEED_MAX_PAGES", "seed-max-pages", "Pages crawled from each seed; 0 for no limit but limits.max_crawl.",
+		func(c *Config) string { return c.Scope.get("max_pages") },
+		func(c *Config, v string) error { return c.Scope.set("max_pages", v) }},
+	{"scope.max_bytes", "GODL_SEED_MAX_BYTES", "seed-max-bytes", "Bytes of pages downloaded while crawling from each seed, e.g. 50MB; 0 for no limit.",
+		func(c *Config) string { return c.Scope.get("max_bytes") },
+		func(c *Config, v string) error { return c.Scope.set("max_bytes", v) }},
+}
+
+func setPath(dst *string, v string) error {
+	if v == "" {
+		return errors.New("empty path")
+	}
+	if v == "~" || strings.HasPrefix(v, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		v = filepath.Join(home, v[1:])
+	}
+	*dst = v
+	return nil
+}
+
+func setOptionalPath(dst *string, v string) error {
+	if v == "" {
+		*dst = ""
+		return nil
+	}
+	return setPath(dst, v)
+}
+
+func setPositive(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", v)
+	}
+	if n <= 0 {
+		return fmt.Errorf("%d must be positive", n)
+	}
+	*dst = n
+	return nil
+}
+
+func setCount(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a non-negative integer", v)
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", v)
+	}
+	*dst = b
+	return nil
+}
+
+func setSize(dst *int64, v string) error {
+	n, err := parseBytes(v)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("size must be positive")
+	}
+	*dst = n
+	return nil
+}
+
+func setQuota(dst *int64, v string) error {
+	n, err := parseBytes(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+func setSchemes(dst *[]string, v string) error {
+	var schemes []string
+	for _, s := range splitList(v) {
+		s = strings.ToLower(s)
+		if s != "http" && s != "https" {
+			return fmt.Errorf("scheme %q is not supported", s)
+		}
+		schemes = append(schemes, s)
+	}
+	if len(schemes) == 0 {
+		return errors.New("no schemes")
+	}
+	*dst = schemes
+	return nil
+}
+
+func setBits(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 64 {
+		return fmt.Errorf("%q is not a number of bits between 0 and 64", v)
+	}
+	*dst = n
+	return nil
+}
+
+func setDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%q is not a positive duration such as \"720h\"", v)
+	}
+	*dst = d
+	return nil
+}
+
+func setFraction(dst *float64, v string) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < -1 || f > 1 {
+		return fmt.Errorf("%q is not a number between -1 and 1", v)
+	}
+	*dst = f
+	return nil
+}
+
+// Set assigns a value by its config file key, e.g. "limits.max_crawl",
+// "hosts.example.com.delay" or "seeds.usgs.gov.max_pages".
+func (c *Config) Set(key, value string) error {
+	for _, f := range configFields {
+		if f.key == key {
+			if err := f.set(c, value); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			return nil
+		}
+	}
+	if rest, ok := strings.CutPrefix(key, "hosts."); ok {
+		i := strings.LastIndex(rest, ".")
+		if i > 0 {
+			return c.setHost(strings.ToLower(rest[:i]), rest[i+1:], value)
+		}
+	}
+	if rest, ok := strings.CutPrefix(key, "seeds."); ok {
+		i := strings.LastIndex(rest, ".")
+		if i > 0 {
+			return c.setSeed(strings.ToLower(rest[:i]), rest[i+1:], value)
+		}
+	}
+	return fmt.Errorf("unknown config key %q", key)
+}
+
+func (c *Config) setHost(host, name, value string) error {
+	hc := c.Hosts[host]
+	switch name {
+	case "user_agent":
+		hc.UserAgent = value
+	case "concurrency":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("hosts.%s.concurrency: %q is not a non-negative integer", host, value)
+		}
+		hc.Concurrency = n
+	case "delay":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("hosts.%s.delay: %q is not a duration such as \"500ms\"", host, value)
+		}
+		hc.Delay = d
+	case "drop_params":
+		hc.DropParams = splitList(value)
+	case "keep_params":
+		hc.KeepParams = splitList(value)
+	default:
+		return fmt.Errorf("unknown config key \"hosts.%s.%s\"", host, name)
+	}
+	if c.Hosts == nil {
+		c.Hosts = map[string]HostConfig{}
+	}
+	c.Hosts[host] = hc
+	return nil
+}
+
+// splitList splits a comma-separated config value.
+func splitList(v string) []string {
+	var out []string
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Host returns the settings for requests to host: the most specific entry of
+// Hosts matching host or one of its parent domains, with the global user
+// agent filled in.
+func (c *Config) Host(host string) HostConfig {
+	host = strings.ToLower(host)
+	for h := host; h != ""; {
+		if hc, ok := c.Hosts[h]; ok {
+			if hc.UserAgent == "" {
+				hc.UserAgent = c.UserAgent
+			}
+			hc.UserAgent = userAgent(hc.UserAgent, c.Contact)
+			return hc
+		}
+		i := strings.IndexByte(h, '.')
+		if i < 0 {
+			break
+		}
+		h = h[i+1:]
+	}
+	return HostConfig{UserAgent: userAgent(c.UserAgent, c.Contact)}
+}
+
+// LoadConfig layers the config files and GODL_* environment variables over
+// the defaults. If path is empty, $GODL_CONFIG or else the XDG config files
+// are read, skipping those that do not exist; an explicit path must exist.
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	if path == "" {
+		path = os.Getenv("GODL_CONFIG")
+	}
+	files, required := ConfigFiles(), false
+	if path != "" {
+		files, required = []string{path}, true
+	}
+	for _, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			if os.IsNotExist(err) && !required {
+				continue
+			}
+			return nil, err
+		}
+		err = c.readFile(f, p)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.files = append(c.files, p)
+	}
+	for _, f := range configFields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(c, v); err != nil {
+				return nil, fmt.Errorf("$%s: %w", f.env, err)
+			}
+			c.origin[f.key] = "$" + f.env
+		}
+	}
+	return c, nil
+}
+
+// readFile applies the settings of a TOML config file.
+func (c *Config) readFile(r io.Reader, name string) error {
+	entries, err := parseTOML(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, e := range entries {
+		if err := c.Set(e.key, e.value); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, e.line, err)
+		}
+		c.origin[e.key] = name
+	}
+	return nil
+}
+
+// Apply installs c as the configuration of the package. It must be called
+// before any crawl or download starts.
+func (c *Config) Apply() {
+	activeConfig = c
+	dataPath = c.CachePath
+	findLinksLogPath = c.LogPath
+	maxDepth = c.MaxDepth
+	embedEndpoint = c.Embedder
+	annEf = c.ANNEf
+	annExactBelow = c.ExactBelow
+	catalogMinResults = c.CatalogMinResults
+	catalogMaxAge = c.CatalogMaxAge
+	catalogMinSimilarity = c.CatalogMinSimilarity
+	recrawlAge = c.RecrawlAge
+	checkpointInterval = c.CheckpointInterval
+	nearDuplicateBits = c.NearDuplicateBits
+	allowPrivate = c.AllowPrivate
+	allowedSchemes = c.AllowedSchemes
+	maxRedirects = c.MaxRedirects
+	maxPageBytes = c.MaxPageBytes
+	maxRetries = c.Retries
+	retryBase = c.RetryBase
+	retryMax = c.RetryMax
+	requestTimeout = c.RequestTimeout
+	transportErr = setTransport(c)
+	maxFileBytes = c.MaxFileBytes
+	maxTotalBytes = c.MaxTotalBytes
+	minFreeBytes = c.MinFreeBytes
+	downloadSegments = c.Segments
+	segmentMinBytes = c.SegmentMinBytes
+	segmentRetries = c.SegmentRetries
+	downloadLimiter = newRateLimiter(c.RateLimit)
+	downloadLayout = c.Layout
+	overwriteFiles = c.Overwrite
+	loginHosts = c.LoginHosts
+	creds, err := loadCredentials(c.NetrcPath, c.CredentialsPath)
+	credentialsMu.Lock()
+	credentials, credentialsErr = creds, err
+	credentialsMu.Unlock()
+	redactLog()
+	tokens = make(chan struct{}, c.CrawlWorkers)
+	downloadTokens = make(chan struct{}, c.DownloadWorkers)
+	resetHostGates()
+}
+
+// tomlEntry is one key/value pair of a config file, with the table prefix
+// folded into the key.
+type tomlEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+// parseTOML reads the subset of TOML used by godl config files: comments,
+// [table] and [table."quoted.name"] headers, and key = value pairs whose
+// values are strings, numbers or booleans.
+func parseTOML(r io.Reader) ([]tomlEntry, error) {
+	var entries []tomlEntry
+	var table string
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end < 0 || strings.HasPrefix(line, "[[") || strings.TrimSpace(stripComment(line[end+1:])) != "" {
+				return nil, fmt.Errorf("line %d: malformed table header", n)
+			}
+			parts, err := splitTOMLKey(line[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			table = strings.Join(parts, ".")
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		parts, err := splitTOMLKey(line[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		key := strings.Join(parts, ".")
+		if table != "" {
+			key = table + "." + key
+		}
+		entries = append(entries, tomlEntry{key: key, value: value, line: n})
+	}
+	return entries, sc.Err()
+}
+
+// splitTOMLKey splits a dotted key into its parts, honouring quoted parts
+// such as "example.com".
+func splitTOMLKey(s string) ([]string, error) {
+	var parts []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		var part string
+		if s[0] == '"' || s[0] == '\'' {
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return nil, errors.New("unterminated quoted key")
+			}
+			part, s = s[1:end+1], s[end+2:]
+		} else {
+			end := strings.IndexByte(s, '.')
+			if end < 0 {
+				end = len(s)
+			}
+			part, s = strings.TrimSpace(s[:end]), s[end:]
+			if part == "" || strings.ContainsAny(part, " \t\"'") {
+				return nil, fmt.Errorf("invalid key %q", part)
+			}
+		}
+		parts = append(parts, part)
+		s = strings.TrimSpace(s)
+		if s == "" {
+			break
+		}
+		if s[0] != '.' {
+			return nil, errors.New("expected '.' between key parts")
+		}
+		s = strings.TrimSpace(s[1:])
+		if s == "" {
+			return nil, errors.New("key ends with '.'")
+		}
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("empty key")
+	}
+	return parts, nil
+}
+
+// parseTOMLValue decodes a string, number or boolean followed by an optional
+// comment.
+func parseTOMLValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] == '\\' {
+				end++
+				continue
+			}
+			if s[end] == '"' {
+				break
+			}
+		}
+		if end >= len(s) {
+			return "", errors.New("unterminated string")
+		}
+		if strings.TrimSpace(stripComment(s[end+1:])) != "" {
+			return "", errors.New("unexpected text after string")
+		}
+		v, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s[:end+1])
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		if strings.TrimSpace(stripComment(s[end+2:])) != "" {
+			return "", errors.New("unexpected text after string")
+		}
+		return s[1 : end+1], nil
+	}
+	v := strings.TrimSpace(stripComment(s))
+	if v == "true" || v == "false" {
+		return v, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil || v == "" {
+		return "", fmt.Errorf("unsupported value %q (quote strings)", v)
+	}
+	return strings.ReplaceAll(v, "_", ""), nil
+}
+
+func stripComment(s string) string {
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// configFlags collects the config flags of a command until they are applied
+// over the loaded configuration.
+type configFlags struct {
+	path string
+	sets [][2]string // key, value in command-line order
+}
+
+// addConfigFlags registers -config, -set and one flag per setting on fs.
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	cf := &configFlags{}
+	fs.StringVar(&cf.path, "config", "", "Config file to read instead of $GODL_CONFIG or the XDG config files.")
+	fs.Func("set", "Override a setting as `key=value`, e.g. hosts.example.com.delay=1s (repeatable).", func(v string) error {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return errors.New("expected key=value")
+		}
+		return cf.add(strings.TrimSpace(key), value)
+	})
+	for _, f := range configFields {
+		key := f.key
+		fs.Func(f.flag, f.usage, func(v string) error { return cf.add(key, v) })
+	}
+	return cf
+}
+
+func (cf *configFlags) add(key, value string) error {
+	if err := DefaultConfig().Set(key, value); err != nil {
+		return err
+	}
+	cf.sets = append(cf.sets, [2]string{key, value})
+	return nil
+}
+
+// load reads the configuration and applies the flag overrides to it.
+func (cf *configFlags) load() (*Config, error) {
+	c, err := LoadConfig(cf.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range cf.sets {
+		if err := c.Set(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+		c.origin[kv[0]] = "flag"
+	}
+	return c, nil
+}
+
+// setup loads the configuration of a command and installs it, reporting
+// failures on stderr.
+func (cf *configFlags) setup(prog string) bool {
+	c, err := cf.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: config: %v\n", prog, err)
+		return false
+	}
+	c.Apply()
+	if credentialsErr != nil {
+		fmt.Fprintf(os.Stderr, "%s: credentials: %v\n", prog, credentialsErr)
+		return false
+	}
+	if transportErr != nil {
+		fmt.Fprintf(os.Stderr, "%s: http: %v\n", prog, transportErr)
+		return false
+	}
+	return true
+}
+
+// ConfigValue is one setting as printed by godl config show.
+type ConfigValue struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Values lists every setting with its effective value and where it came
+// from, host and seed overrides last.
+func (c *Config) Values() []ConfigValue {
+	source := func(key string) string {
+		if s, ok := c.origin[key]; ok {
+			return s
+		}
+		return "default"
+	}
+	var out []ConfigValue
+	for _, f := range configFields {
+		out = append(out, ConfigValue{Key: f.key, Value: f.get(c), Source: source(f.key)})
+	}
+	hosts := make([]string, 0, len(c.Hosts))
+	for h := range c.Hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	for _, h := range hosts {
+		hc := c.Hosts[h]
+		prefix := "hosts." + h + "."
+		if hc.UserAgent != "" {
+			out = append(out, ConfigValue{prefix + "user_agent", hc.UserAgent, source(prefix + "user_agent")})
+		}
+		if hc.Concurrency != 0 {
+			out = append(out, ConfigValue{prefix + "concurrency", strconv.Itoa(hc.Concurrency), source(prefix + "concurrency")})
+		}
+		if hc.Delay != 0 {
+			out = append(out, ConfigValue{prefix + "delay", hc.Delay.String(), source(prefix + "delay")})
+		}
+		if len(hc.DropParams) > 0 {
+			out = append(out, ConfigValue{prefix + "drop_params", strings.Join(hc.DropParams, ","), source(prefix + "drop_params")})
+		}
+		if len(hc.KeepParams) > 0 {
+			out = append(out, ConfigValue{prefix + "keep_params", strings.Join(hc.KeepParams, ","), source(prefix + "keep_params")})
+		}
+	}
+	seeds := make([]string, 0, len(c.Seeds))
+	for h := range c.Seeds {
+		seeds = append(seeds, h)
+	}
+	sort.Strings(seeds)
+	for _, h := range seeds {
+		for _, name := range scopeKeys {
+			if v, ok := c.Seeds[h][name]; ok {
+				key := "seeds." + h + "." + name
+				out = append(out, ConfigValue{key, v, source(key)})
+			}
+		}
+	}
+	return out
+}
+
+// WriteTOML writes the effective configuration as a config file, with the
+// source of each value as a comment.
+func (c *Config) WriteTOML(w io.Writer) {
+	for _, f := range c.files {
+		fmt.Fprintf(w, "# read %s\n", f)
+	}
+	table := ""
+	for _, v := range c.Values() {
+		t, name := v.Key[:strings.LastIndexByte(v.Key, '.')], v.Key[strings.LastIndexByte(v.Key, '.')+1:]
+		for _, prefix := range []string{"hosts.", "seeds."} {
+			if host, ok := strings.CutPrefix(t, prefix); ok {
+				t = prefix + strconv.Quote(host)
+			}
+		}
+		if t != table {
+			fmt.Fprintf(w, "\n[%s]\n", t)
+			table = t
+		}
+		value := v.Value
+		if _, err := strconv.Atoi(value); err != nil && value != "true" && value != "false" || name == "user_agent" {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(w, "%s = %s  # %s\n", name, value, v.Source)
+	}
+}
@@ -0,0 +1,111 @@
+package crawler
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	src := `# godl config
+[paths]
+cache = "~/godl/data.gob"   # trailing comment
+
+[limits]
+max_crawl = 1_000
+[hosts."prd-tnm.s3.amazonaws.com"]
+delay = '250ms'
+user_agent = "a \"quoted\" agent # not a comment"
+`
+	entries, err := parseTOML(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	got := map[string]string{}
+	for _, e := range entries {
+		got[e.key] = e.value
+	}
+	want := map[string]string{
+		"paths.cache":                               "~/godl/data.gob",
+		"limits.max_crawl":                          "1000",
+		"hosts.prd-tnm.s3.amazonaws.com.delay":      "250ms",
+		"hosts.prd-tnm.s3.amazonaws.com.user_agent": `a "quoted" agent # not a comment`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v\nwant %v", got, want)
+	}
+
+	for _, bad := range []string{"cache = unquoted path", "[paths", "= 1", `x = "open`} {
+		if _, err := parseTOML(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseTOML(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(home, "none"))
+	t.Setenv("GODL_CONFIG", "")
+	t.Setenv("GODL_MAX_CRAWL", "200")
+	if err := os.MkdirAll(filepath.Join(home, "godl"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(home, "godl", "config.toml")
+	err := os.WriteFile(file, []byte(`
+[limits]
+max_crawl = 100
+max_depth = 2
+[hosts."example.com"]
+concurrency = 2
+delay = "1s"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := newFlagSet("test", "test")
+	cf := addConfigFlags(fs)
+	if _, err := parseArgs(fs, []string{"--top-seeds", "3"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	cfg, err := cf.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.MaxDepth != 2 || cfg.MaxCrawl != 200 || cfg.TopSeeds != 3 || cfg.CrawlWorkers != 40 {
+		t.Fatalf("layering wrong: %+v", cfg)
+	}
+	sources := map[string]string{}
+	for _, v := range cfg.Values() {
+		sources[v.Key] = v.Source
+	}
+	if sources["limits.max_depth"] != file || sources["limits.max_crawl"] != "$GODL_MAX_CRAWL" ||
+		sources["limits.top_seeds"] != "flag" || sources["limits.crawl_workers"] != "default" {
+		t.Fatalf("sources: %v", sources)
+	}
+
+	// Subdomains inherit the overrides; the global user agent fills in.
+	hc := cfg.Host("Data.Example.com")
+	if hc.Concurrency != 2 || hc.Delay != time.Second || hc.UserAgent != cfg.UserAgent {
+		t.Fatalf("host override: %+v", hc)
+	}
+	if hc := cfg.Host("example.org"); hc.Concurrency != 0 || hc.Delay != 0 {
+		t.Fatalf("unrelated host got override: %+v", hc)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Setenv("GODL_CONFIG", "")
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Fatal("explicit missing config file was ignored")
+	}
+	bad := filepath.Join(t.TempDir(), "bad.toml")
+	os.WriteFile(bad, []byte("[limits]\nmax_crawl = -1\n"), 0644)
+	if _, err := LoadConfig(bad); err == nil || !strings.Contains(err.Error(), "bad.toml:2") {
+		t.Fatalf("got %v, want an error pointing at bad.toml:2", err)
+	}
+}
@@ -1,13 +1,14 @@
 package crawler
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -30,7 +31,7 @@ func BreadthFirst(scrapeQueue []string, downloadDir string) ([]string, error) {
 	log.Println("------------------------------------------------------------------------------")
 	var n int
 	n++
-	const maxCrawl = 400
+	maxCrawl := activeConfig.MaxCrawl
 	var count int
 	var JobQueue []WebNode
 	var results []string
@@ -95,7 +96,16 @@ func Crawl(node *WebNode, downloadDir *string) []WebNode {
 // to geospatial files are recorded with metadata while regular links are queued
 // for further crawling up to a maximum depth.
 func VisitNode(n *html.Node, links *[]WebNode, resp *http.Response, parent *WebNode, root *html.Node) {
-	const maxDepth = 4
+	visitNode(n, func(l WebNode) {
+		if l.Depth < maxDepth {
+			*links = append(*links, l)
+		}
+	}, resp, parent, root)
+}
+
+// visitNode is VisitNode with every link passed to visit as soon as it is
+// found, whatever its depth.
+func visitNode(n *html.Node, visit func(WebNode), resp *http.Response, parent *WebNode, root *html.Node) {
 
 	if n.Type == html.ElementNode && n.Data == "a" {
 
@@ -113,11 +123,9 @@ func VisitNode(n *html.Node, links *[]WebNode, resp *http.Response, parent *WebN
 			ext := strings.ToLower(path.Ext(link.Path))
 			if GeoFileExtensions[ext] {
 				meta := ExtractMetadata(root, resp.Request.URL.String(), link.String())
-				if parent.Depth+1 < maxDepth {
-					*links = append(*links, WebNode{Url: link.String(), Parent: parent, Depth: parent.Depth + 1, context: DataContext{Description: meta}})
-				}
-			} else if parent.Depth+1 < maxDepth {
-				*links = append(*links, WebNode{Url: link.String(), Parent: parent, Depth: parent.Depth + 1})
+				visit(WebNode{Url: link.String(), Parent: parent, Depth: parent.Depth + 1, context: DataContext{Description: meta}})
+			} else {
+				visit(WebNode{Url: link.String(), Parent: parent, Depth: parent.Depth + 1})
 			}
 		}
 	}
@@ -125,7 +133,7 @@ func VisitNode(n *html.Node, links *[]WebNode, resp *http.Response, parent *WebN
 	// Recurse into children
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
 		if c.Type == html.ElementNode && HasUnwantedClassOrID(c) == false {
-			VisitNode(c, links, resp, parent, root)
+			visitNode(c, visit, resp, parent, root)
 		}
 	}
 }
@@ -151,13 +159,13 @@ func HasUnwantedClassOrID(n *html.Node) bool {
 // geospatial file, the file is scheduled for download and no further links are
 // returned.
 func Extract(node *WebNode, downloadDir *string) ([]WebNode, error) {
-	resp, err := http.Get(node.Url)
+	resp, err := httpGet(node.Url)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("getting %s: %s", node.Url, resp.Status)
+		return nil, &StatusError{URL: node.Url, Code: resp.StatusCode, Status: resp.Status}
 	}
 	downloadable := ValidateDownloadable(resp, node.Url)
 	if downloadable {
@@ -165,10 +173,10 @@ func Extract(node *WebNode, downloadDir *string) ([]WebNode, error) {
 		return nil, nil
 	}
 
-	doc, err := html.Parse(resp.Body)
+	doc, err := html.Parse(limitPage(resp.Body))
 	resp.Body.Close()
 	if err != nil {
-		return nil, fmt.Errorf("parsing %s as HTML: %v", node.Url, err)
+		return nil, &ParseError{URL: node.Url, Err: err}
 	}
 	var links []WebNode
 
@@ -177,15 +185,12 @@ func Extract(node *WebNode, downloadDir *string) ([]WebNode, error) {
 	return links, nil
 }
 
-// ValidateDownloadable checks the HTTP response headers to determine if the
-// resource is a geospatial file that should be downloaded directly.
+// ValidateDownloadable checks the HTTP response to determine if the resource is
+// a geospatial file that should be downloaded directly. The media type is
+// parsed properly and the first bytes of the body are sniffed, see
+// ClassifyResponse.
 func ValidateDownloadable(resp *http.Response, url string) bool {
-	contentType := resp.Header.Get("Content-Type")
-	if GeoMIMETypes[contentType] {
-		//initiate download
-		return true
-	}
-	return false
+	return ClassifyResponse(resp).Downloadable
 }
 
 // DownloadBuffered saves the body of an HTTP response to disk using a buffered
@@ -196,61 +201,58 @@ func DownloadBuffered(resp *http.Response, rawURL string, downloadDir *string) {
 	downloadTokens <- struct{}{}
 	defer func() { <-downloadTokens }()
 
-	parsedURL, err := url.Parse(rawURL)
+	saved, err := saveResponse(resp, *downloadDir)
+	if err == nil {
+		_, err = placeFile(saved, filepath.Join(*downloadDir, layoutName(&WebNode{Url: rawURL}, resp)))
+	}
 	if err != nil {
-		log.Printf("error parsing URL %s: %v", rawURL, err)
-		resp.Body.Close()
-		return
+		log.Printf("error downloading %s: %v", rawURL, err)
 	}
+}
 
-	// Determine file name from URL path. If none exists, fall back to a
-	// generic name so the download is still saved.
-	filename := path.Base(parsedURL.Path)
-	if filename == "" || filename == "." || filename == "/" {
-		filename = "download"
-	}
+// savedFile describes a response body written to disk by saveResponse.
+type savedFile struct {
+	Path      string
+	Size      int64
+	SHA256    string
+	Duplicate bool // Path is an identical file that was saved before
+}
 
-	filepath := path.Join(*downloadDir, filename)
+// saveResponse streams the body of resp into a temporary file in downloadDir
+// and closes the body. The size and SHA-256 of the written bytes are returned
+// so callers can record provenance before placeFile gives the file its name.
+// Nothing is left behind if the body cannot be read in full.
+func saveResponse(resp *http.Response, downloadDir string) (savedFile, error) {
+	defer resp.Body.Close()
 
-	// Create or truncate the output file then stream the body directly to
-	// disk. Using io.Copy avoids buffering the entire file in memory.
-	outFile, err := os.OpenFile(filepath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	// Stream the body directly to disk. Using io.Copy avoids buffering the
+	// entire file in memory.
+	outFile, err := os.CreateTemp(downloadDir, partPattern)
 	if err != nil {
-		log.Printf("error creating file %s: %v", filepath, err)
-		resp.Body.Close()
-		return
+		return savedFile{}, fmt.Errorf("creating file in %s: %w", downloadDir, err)
 	}
-	defer outFile.Close()
-
-	if _, err := io.Copy(outFile, resp.Body); err != nil {
-		log.Printf("error writing to file %s: %v", filepath, err)
+	hw := newHashingWriter(outFile)
+	_, err = io.Copy(hw, resp.Body)
+	if cerr := outFile.Close(); err == nil {
+		err = cerr
 	}
-	resp.Body.Close()
+	if err != nil {
+		os.Remove(outFile.Name())
+		return savedFile{Size: hw.size}, fmt.Errorf("writing to file %s: %w", outFile.Name(), err)
+	}
+	return savedFile{Path: outFile.Name(), Size: hw.size, SHA256: hw.Hex()}, nil
 }
 
-// Download writes the provided data to a file in downloadDir using the
-// filename derived from the URL. It is used by the secure downloader helper
-// and tests.
+// Download writes the provided data to a file in downloadDir named by the
+// download layout, like a downloaded response. It is used by tests.
 func Download(rawURL string, data []byte, downloadDir *string) error {
-	parsedURL, err := url.Parse(rawURL)
-	if err != nil {
-		log.Printf("error parsing URL %s: %v", rawURL, err)
-		return err
+	resp := &http.Response{Body: io.NopCloser(bytes.NewReader(data)), Header: http.Header{}}
+	saved, err := saveResponse(resp, *downloadDir)
+	if err == nil {
+		_, err = placeFile(saved, filepath.Join(*downloadDir, layoutName(&WebNode{Url: rawURL}, resp)))
 	}
-	filename := path.Base(parsedURL.Path)
-	if filename == "" || filename == "." || filename == "/" {
-		filename = "download"
-	}
-	filepath := path.Join(*downloadDir, filename)
-	file, err := os.OpenFile(filepath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		log.Printf("error creating file %s: %v", filepath, err)
-		return err
-	}
-	defer file.Close()
-	if _, err := file.Write(data); err != nil {
-		log.Printf("error writing data to %s: %v", filepath, err)
-		return err
+		log.Printf("error saving %s: %v", rawURL, err)
 	}
-	return nil
+	return err
 }
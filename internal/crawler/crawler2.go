@@ -1,119 +1,239 @@
 package crawler
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/html"
 )
 
 // FindLinks embeds the search query, compares it against cached seed
 // descriptions and returns the most relevant URLs which are then crawled. The
-// resulting downloadable links are accumulated in m.downloadURLs.
+// resulting downloadable links are accumulated in m.downloadURLs and
+// returned ranked by RankCandidates.
 func (m *Manager) FindLinks() []WebNode {
+	links, err := m.FindLinksContext(context.Background())
+	if err != nil {
+		log.Fatalf("error while embedding search-query: %v", err)
+	}
+	return links
+}
+
+// FindLinksContext is FindLinks with cancellation: once ctx is done no new
+// pages are fetched, and the links found so far are returned after the
+// pages in flight finish. In catalog-first mode known datasets are returned
+// without crawling when enough of them match and are fresh.
+func (m *Manager) FindLinksContext(ctx context.Context) ([]WebNode, error) {
 	log.Println("------------------------------------------------------------------------------")
 	log.Println("							STARTED NEW CRAWL SESSION")
 	log.Println("------------------------------------------------------------------------------")
 	//finding relevant seeds
 	//1. embed search query
-	var buf bytes.Buffer
-	newPayload := TextPayload{Texts: []string{*m.searchQuery}}
-	if err := json.NewEncoder(&buf).Encode(newPayload); err != nil {
-		log.Fatalf("Error occured while encoding search-query JSON payload: %v", err)
-	}
-
-	resp, err := http.Post(
-		"http://localhost:8000/embed",
-		"application/json",
-		&buf,
-	)
+	queryEmbedding, err := EmbedQuery(*m.searchQuery)
 	if err != nil {
-		log.Fatalf("error while sending embedding request for search-query: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	var res EmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		log.Fatalf("error returned from vectorization endpoint while embedding search-query: %v", err)
+	//2. in catalog-first mode, answer from known datasets when enough of
+	//   them are fresh; otherwise crawl and rank them with the new finds
+	var known []WebNode
+	if m.resumeFrontier != nil {
+		//2-3. a resumed session continues from its saved frontier
+		log.Printf("resuming crawl with %d pages to fetch", len(m.resumeFrontier))
+		m.crawl(ctx, m.resumeFrontier)
+		return m.rankFound(queryEmbedding), nil
 	}
-
-	queryEmbedding := res.Embeddings[0]
-	var relevantURLs []WebNode
-	//2. compare with cached URL-embeddings
-
-	//create cosine-similarity and sort for top 10
-
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	for url, ctx := range m.CachedURLEmbeddings {
-		wg.Add(1)
-		go func(context DataContext, url string) {
-			score, err := Cosine(queryEmbedding, context.Embedding)
-			if err != nil {
-				log.Fatalf("Error while computing cosine similarity: %v", err)
+	if m.catalogFirst {
+		var enough bool
+		known, enough = m.answerFromCatalog(queryEmbedding)
+		if enough {
+			for _, n := range known {
+				m.addCandidate(n)
 			}
-			mu.Lock()
-			relevantURLs = append(relevantURLs, WebNode{Url: url, Parent: nil, Depth: 0, context: context, CosineSimilarity: score})
-			mu.Unlock()
-			wg.Done()
-		}(ctx, url)
+			if *m.downloadPath != "" {
+				m.downloadKnown(known)
+			}
+			return known, nil
+		}
 	}
-	wg.Wait()
 
-	length := len(relevantURLs) - 1
-	minusTen := length - 10
-	//sort, top-10
-	relevantURLs = MergeSort(&relevantURLs, 0, length)
-	//3. chose top 5 seeds using cosine similarity
-	JobQueue := relevantURLs[minusTen:length]
+	//3. compare with the cached seeds, lexically and by embedding, and
+	//   keep the best ones; known datasets are answers, not pages to crawl
+	JobQueue := m.rankHybrid(*m.searchQuery, queryEmbedding, m.topSeeds, isSeed)
 	//relevant seeds have been found
 
-	fmt.Println("Number of relevant URLs: ", len(JobQueue))
+	log.Println("Number of relevant URLs: ", len(JobQueue))
 	for _, node := range JobQueue {
+		log.Println("	closest-match URL: ", node.Url, node.context.Description)
+	}
+
+	m.crawl(ctx, JobQueue)
 
-		fmt.Println("	closest-match URL: ", node.Url, node.context.Description)
+	// Known datasets the crawl did not find again are still answers.
+	for _, n := range known {
+		m.addCandidate(n)
+	}
+
+	//4. rank the candidates against the query, best first
+	return m.rankFound(queryEmbedding), nil
+}
+
+// rankFound ranks the candidates found so far and keeps them in that order.
+func (m *Manager) rankFound(queryEmbedding []float64) []WebNode {
+	ranked := m.RankCandidates(queryEmbedding, m.Found())
+	m.linkChan <- struct{}{}
+	m.downloadURLs = ranked
+	<-m.linkChan
+	return ranked
+}
+
+// crawl visits pages breadth-first from seeds until m.maxCrawl pages have
+// been visited, the links run out or ctx is done. Candidates are collected in
+// m.downloadURLs. With a checkpoint path the state is saved periodically and
+// when the crawl ends.
+func (m *Manager) crawl(ctx context.Context, seeds []WebNode) {
+	m.ckMu.Lock()
+	if m.seen == nil {
+		m.seen = make(map[string]bool)
+	}
+	m.pending = make(map[string]WebNode)
+	m.frontier = make(map[string]WebNode, len(seeds))
+	for _, s := range seeds {
+		m.frontier[canonicalKey(s.Url)] = s
+	}
+	m.ckMu.Unlock()
+	if m.checkpointPath != "" {
+		defer m.checkpointPeriodically()()
 	}
 
-	//Crawling begins
 	go func() {
-		m.worklist <- JobQueue
+		m.worklist <- seeds
 	}()
 
 	n := 1
-	count := 0
-	maxCrawl := 600
 	for ; n > 0; n-- {
 		list := <-m.worklist
 		for _, node := range list {
-			if count > maxCrawl {
-				go func() { m.done <- true }()
-			} else {
-				go func() { m.done <- false }()
-				if !m.seen[node.Url] {
-					count++
-					n++
-					m.seen[node.Url] = true
-					stop := <-m.done
-					if !stop {
-						go func(node WebNode) {
-							res := m.Crawl2(&node)
-							m.worklist <- res
-						}(node)
-					}
-				}
+			// Pages are told apart by canonical key, so variants of a URL
+			// are crawled once. Every worker started below sends exactly one
+			// list back, so n only grows for nodes that are actually
+			// crawled. Links skipped because the crawl was cancelled stay in
+			// the frontier, so a resumed crawl visits them.
+			key := canonicalKey(node.Url)
+			m.ckMu.Lock()
+			if ctx.Err() != nil {
+				m.ckMu.Unlock()
+				continue
 			}
-
+			if m.crawled > m.maxCrawl || m.seen[key] {
+				delete(m.frontier, key)
+				m.ckMu.Unlock()
+				continue
+			}
+			scope := m.scopeOf(&node)
+			if why := scope.spent(); why != "" {
+				delete(m.frontier, key)
+				m.ckMu.Unlock()
+				m.emitFiltered()
+				log.Printf("out of scope of %s: %s (%s)", seedOf(&node).Url, node.Url, why)
+				continue
+			}
+			scope.pages++
+			m.crawled++
+			n++
+			m.seen[key] = true
+			delete(m.frontier, key)
+			m.pending[key] = node
+			m.ckMu.Unlock()
+			m.queue(1)
+			go func(node WebNode) {
+				if ctx.Err() != nil {
+					m.queue(-1)
+					m.worklist <- nil
+					return
+				}
+				links := m.Crawl2(&node)
+				m.ckMu.Lock()
+				delete(m.pending, key)
+				for _, l := range links {
+					m.frontier[canonicalKey(l.Url)] = l
+				}
+				m.ckMu.Unlock()
+				m.worklist <- links
+			}(node)
 		}
 	}
 	log.Println("------------------------------------------------------------------------------")
-	log.Printf("					Done! scraped %d URLs ", len(m.downloadURLs))
+	log.Printf("					Done! scraped %d URLs ", len(m.Found()))
 	log.Println("------------------------------------------------------------------------------")
-	return m.downloadURLs
+	for _, s := range m.ErrorsBySeed() {
+		log.Printf("%d errors crawling from %s: %s", s.Total, s.Seed, s)
+	}
+}
+
+// Found returns a copy of the downloadable links discovered so far. It is
+// safe to call while the crawl is running.
+func (m *Manager) Found() []WebNode {
+	m.linkChan <- struct{}{}
+	found := append([]WebNode(nil), m.downloadURLs...)
+	<-m.linkChan
+	return found
+}
+
+// RankCached scores every cached URL against queryEmbedding and returns the
+// n most similar ones, most similar first. A non-positive n returns all of
+// them. Large caches are searched through the nearest neighbour index, small
+// ones exhaustively.
+func (m *Manager) RankCached(queryEmbedding []float64, n int) []WebNode {
+	if m.ann != nil && n > 0 && m.ann.Len() >= annExactBelow {
+		var top []WebNode
+		for _, hit := range m.ann.Search(queryEmbedding, n, annEf) {
+			if ctx, ok := m.CachedURLEmbeddings[hit.URL]; ok {
+				top = append(top, WebNode{Url: hit.URL, context: ctx, CosineSimilarity: hit.Similarity})
+			}
+		}
+		return top
+	}
+	var relevantURLs []WebNode
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for url, ctx := range m.CachedURLEmbeddings {
+		wg.Add(1)
+		go func(context DataContext, url string) {
+			defer wg.Done()
+			score, err := Cosine(queryEmbedding, context.Embedding)
+			if err != nil {
+				log.Printf("Error while computing cosine similarity for %s: %v", url, err)
+				return
+			}
+			mu.Lock()
+			relevantURLs = append(relevantURLs, WebNode{Url: url, Parent: nil, Depth: 0, context: context, CosineSimilarity: score})
+			mu.Unlock()
+		}(ctx, url)
+	}
+	wg.Wait()
 
+	// MergeSort orders by ascending similarity; walk it from the end.
+	sorted := MergeSort(&relevantURLs, 0, len(relevantURLs))
+	if n <= 0 || n > len(sorted) {
+		n = len(sorted)
+	}
+	top := make([]WebNode, 0, n)
+	for i := len(sorted) - 1; i >= len(sorted)-n; i-- {
+		top = append(top, sorted[i])
+	}
+	return top
+}
+
+// Description returns the stored description of the node.
+func (n WebNode) Description() string {
+	return n.context.Description
 }
 
 // ToLinks returns the URLs from the download queue as a plain slice of strings.
@@ -133,6 +253,9 @@ func (m *Manager) Crawl2(node *WebNode) []WebNode {
 	<-m.smTokens
 	if err != nil {
 		log.Printf("Error occured while crawling %v: %v", node.Url, err)
+		m.emit(Event{Kind: EventError, Node: *node, Err: err})
+	} else {
+		m.emit(Event{Kind: EventPage, Node: *node})
 	}
 
 	return links
@@ -142,61 +265,186 @@ func (m *Manager) Crawl2(node *WebNode) []WebNode {
 // appends downloadable URLs to m.downloadURLs and returns any follow-on links
 // for further crawling.
 func (m *Manager) Extract2(node *WebNode) ([]WebNode, error) {
-	var links []WebNode
 
-	resp, err := http.Get(node.Url)
+	// Socrata portals render their catalogs client-side, so seeds on them are
+	// searched through the Discovery API instead of being parsed.
+	if domain, ok := SocrataDomain(node.Url); ok && node.Depth == 0 {
+		return m.ExtractSocrata(node, domain)
+	}
+
+	// Pages fetched recently enough are not fetched again; others are
+	// revalidated and their stored links reused if unchanged.
+	cached, ok := m.pages.Get(node.Url)
+	if ok && m.recrawlAge > 0 && time.Since(cached.Fetched) < m.recrawlAge {
+		return m.replayPage(node, cached), nil
+	}
+	deadline := newPageDeadline()
+	defer deadline.close()
+	resp, err := httpGetContext(deadline.ctx, node.Url, cached.ETag, cached.LastModified)
 	if err != nil {
-		return nil, err
+		return nil, deadline.err(err)
+	}
+	if resp.StatusCode == http.StatusNotModified && ok {
+		resp.Body.Close()
+		m.pages.Touch(node.Url, time.Now().UTC())
+		return m.replayPage(node, cached), nil
 	}
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("getting %s: %s", node.Url, resp.Status)
+		return nil, &StatusError{URL: node.Url, Code: resp.StatusCode, Status: resp.Status}
 	}
-	downloadable := ValidateDownloadable(resp, node.Url)
-	if downloadable {
-		m.linkChan <- struct{}{} //replace with mu.Lock()
-		links = append(links, WebNode{Url: node.Url})
-		<-m.linkChan //replace with mu.UnLock()
+	info := ClassifyResponse(resp)
+	if info.Downloadable {
+		// The page itself is a dataset, recognised by its content or its
+		// Content-Disposition rather than by a link to it.
+		found := *node
+		if found.context.Description == "" {
+			md := downloadMetadata{URL: node.Url, Format: info.Format}
+			if info.Filename != "." && info.Filename != "/" {
+				md.Title = info.Filename
+			}
+			out, _ := json.Marshal(md)
+			found.context.Description = string(out)
+		}
+		m.addCandidate(found)
 		if *m.downloadPath != "" {
-			go DownloadBuffered(resp, node.Url, m.downloadPath)
+			deadline.keep()
+			m.downloads.Add(1)
+			go m.downloadNode(resp, &found, info)
+		} else {
+			resp.Body.Close()
 		}
 		return nil, nil
 	}
 
-	doc, err := html.Parse(resp.Body)
+	doc, err := html.Parse(limitPage(m.countBytes(node, resp.Body)))
 	resp.Body.Close()
 	if err != nil {
-		return nil, fmt.Errorf("parsing %s as HTML: %v", node.Url, err)
+		if deadline.ctx.Err() != nil {
+			return nil, deadline.err(err)
+		}
+		return nil, &ParseError{URL: node.Url, Err: err}
 	}
 
-	VisitNode(doc, &m.downloadURLs, resp, node, doc)
+	// Every link is remembered for later crawls, which may reach the page
+	// closer to a seed.
+	entry := PageEntry{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now().UTC(),
+		Fingerprint:  pageFingerprint(doc),
+	}
+	var found []WebNode
+	visitNode(doc, func(l WebNode) {
+		entry.Links = append(entry.Links, PageLink{URL: l.Url, Description: l.context.Description})
+		found = append(found, l)
+	}, resp, node, doc)
+	m.pages.Put(node.Url, entry)
 
-	return links, nil
+	return m.followPage(found, entry.Fingerprint), nil
 }
 
-// DownloadBuffered reads the HTTP response body and writes it to disk when
-// running in secure mode. Downloads are serialized using dlTokens.
-func (m *Manager) DownloadBuffered(resp *http.Response, rawURL string) {
-	if m.secure {
-		m.dlTokens <- struct{}{}
-		data, err := io.ReadAll(resp.Body)
-		resp.Body.Close() // safe to close now
+// downloadNode saves the response for node into the download directory and
+// records the result, including failures, in the session manifest.
+func (m *Manager) downloadNode(resp *http.Response, node *WebNode, info ContentInfo) {
+	defer m.downloads.Done()
+	downloadTokens <- struct{}{}
+	defer func() { <-downloadTokens }()
+
+	entry := NewManifestEntry(*m.searchQuery, node)
+	entry.Headers = manifestHeaders(resp.Header)
+	entry.Format = info.Format
+	if err := m.reserveQuota(resp.ContentLength, *m.downloadPath); err != nil {
+		resp.Body.Close()
+		log.Printf("not downloading %s: %v", node.Url, err)
+		entry.Error = err.Error()
+		m.addManifestEntry(entry)
+		return
+	}
+	quota := m.quotaBody(resp.Body, resp.ContentLength)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{quota, resp.Body}
+	saved, err := m.saveDownload(resp, node, *m.downloadPath)
+	if err != nil || saved.Duplicate {
+		m.releaseQuota(quota.reserved)
+	} else if quota.reserved > saved.Size {
+		m.releaseQuota(quota.reserved - saved.Size)
+	}
+	fetched := time.Now().UTC()
+	entry.FetchedAt = &fetched
+	entry.LocalPath = saved.Path
+	entry.Size = saved.Size
+	entry.SHA256 = saved.SHA256
+	entry.Deduplicated = saved.Duplicate
+	if err != nil {
+		log.Printf("error downloading %s: %v", node.Url, err)
+		entry.Error = err.Error()
+	} else if (m.inspectArchives || m.extractArchives) && IsArchive(saved.Path) {
+		m.postProcessArchive(node, &entry)
+	} else {
+		readGeoInfo(&entry, saved.Path)
+	}
+	m.addManifestEntry(entry)
+}
+
+// readGeoInfo reads header metadata from a downloaded or extracted file and
+// stores it on entry. Files in formats without a reader are skipped.
+func readGeoInfo(entry *ManifestEntry, p string) {
+	info, err := ReadGeoInfo(p)
+	if err != nil {
+		if err != ErrUnsupportedFormat {
+			log.Printf("error reading geospatial header of %s: %v", p, err)
+		}
+		return
+	}
+	if entry.Geo == nil {
+		entry.Geo = make(map[string]*GeoInfo)
+	}
+	entry.Geo[p] = info
+}
+
+// postProcessArchive inspects, and optionally extracts, a downloaded archive.
+// The findings are stored on the manifest entry and appended to the node's
+// description.
+func (m *Manager) postProcessArchive(node *WebNode, entry *ManifestEntry) {
+	report, err := InspectArchive(entry.LocalPath)
+	if err != nil {
+		log.Printf("error inspecting archive %s: %v", entry.LocalPath, err)
+		entry.Error = err.Error()
+		return
+	}
+	if m.extractArchives {
+		dest := strings.TrimSuffix(entry.LocalPath, filepath.Ext(entry.LocalPath))
+		report.Extracted, err = ExtractArchive(entry.LocalPath, dest, DefaultExtractLimits)
 		if err != nil {
-			log.Printf("Failed to buffer body for download: %v", err)
+			log.Printf("error extracting archive %s: %v", entry.LocalPath, err)
+			entry.Error = err.Error()
 		}
-		// cmd := exec.Command(
-		// 	"firejail",
-		// 	"--private="+*m.downloadPath,
-		// 	"--net=none",
-		// 	"--caps.drop=all",
-		// 	"--seccomp",
-		// 	"--shell=none",
-		// 	"--quiet",
-		// 	fmt.Sprintf("downloader -u=%s -b=%s -d=%s", rawURL, data, *m.downloadPath),
-		// )
-		// cmd.Run()
-
-		Download(rawURL, data, m.downloadPath)
-		<-m.dlTokens
+		for _, p := range report.Extracted {
+			readGeoInfo(entry, p)
+		}
+	} else if strings.EqualFold(filepath.Ext(entry.LocalPath), ".kmz") {
+		readGeoInfo(entry, entry.LocalPath)
+	}
+	entry.Archive = report
+	if node.context.Description == "" {
+		out, _ := json.Marshal(downloadMetadata{URL: node.Url})
+		node.context.Description = string(out)
+	}
+	AppendDescription(node, report.Summary())
+	m.describeCandidate(node)
+	entry.Metadata = json.RawMessage(node.context.Description)
+}
+
+// DownloadBuffered saves the response body in the download directory,
+// through the download sandbox when running in secure mode and in segments
+// when the file is large. Downloads are serialized using dlTokens.
+func (m *Manager) DownloadBuffered(resp *http.Response, rawURL string) {
+	m.dlTokens <- struct{}{}
+	defer func() { <-m.dlTokens }()
+	if _, err := m.saveDownload(resp, &WebNode{Url: rawURL}, *m.downloadPath); err != nil {
+		log.Printf("error downloading %s: %v", rawURL, err)
 	}
 }
@@ -1,11 +1,15 @@
 package crawler
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	"encoding/json"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -37,8 +41,9 @@ func TestExtract2_Downloadable(t *testing.T) {
 	if links != nil {
 		t.Fatalf("expected nil links, got %v", links)
 	}
-	if len(mg.downloadURLs) != 0 {
-		t.Fatalf("expected downloadURLs empty, got %v", mg.downloadURLs)
+	// The response is a file, so the page is a candidate itself.
+	if len(mg.downloadURLs) != 1 || mg.downloadURLs[0].Url != ts.URL {
+		t.Fatalf("expected %s in downloadURLs, got %v", ts.URL, mg.downloadURLs)
 	}
 }
 
@@ -110,3 +115,135 @@ func TestGenerateEmbeddings(t *testing.T) {
 		t.Fatalf("got %v want %v", embeddings, want)
 	}
 }
+
+// useFakeEmbedder points the embedding client at a server that embeds every
+// text as (1, 0) for the duration of the test.
+func useFakeEmbedder(t *testing.T) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p TextPayload
+		json.NewDecoder(r.Body).Decode(&p)
+		var res EmbeddingResponse
+		for range p.Texts {
+			res.Embeddings = append(res.Embeddings, []float64{1, 0})
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	old := embedEndpoint
+	embedEndpoint = ts.URL
+	t.Cleanup(func() {
+		embedEndpoint = old
+		ts.Close()
+	})
+}
+
+func TestFindLinksStreamsEvents(t *testing.T) {
+	useFakeEmbedder(t)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/catalog">catalog</a><a href="/a.zip">a</a><a href="/missing">x</a>`))
+		case "/catalog":
+			w.Write([]byte(`<a href="/b.geojson">b</a><a href="/a.zip">a again</a><a href="/download?id=1">roads</a>`))
+		case "/download":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", "attachment; filename=roads.zip")
+			w.Write([]byte("PK\x03\x04 roads"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	var events []Event
+	mg := NewManager(Options{Query: "q", OnEvent: func(ev Event) { events = append(events, ev) }})
+	mg.CachedURLEmbeddings = map[string]DataContext{site.URL + "/": {Description: "seed", Embedding: []float64{1, 0}}}
+	links, err := mg.FindLinksContext(context.Background())
+	if err != nil {
+		t.Fatalf("FindLinksContext: %v", err)
+	}
+
+	var candidates []string
+	for _, ev := range events {
+		if ev.Kind == EventCandidate {
+			candidates = append(candidates, ev.Node.Url)
+		}
+	}
+	// b.geojson is only reachable through /catalog, so the crawl must follow
+	// page links; a.zip is reported once although it is linked twice, and
+	// /download is a candidate once its response turns out to be a file.
+	want := []string{site.URL + "/a.zip", site.URL + "/b.geojson", site.URL + "/download?id=1"}
+	if !SlicesEqualUnordered(candidates, want) || len(links) != 3 {
+		t.Fatalf("candidates %v, links %d", candidates, len(links))
+	}
+	last := events[len(events)-1].Progress
+	if want := (Progress{Fetched: 3, Queued: 0, Found: 3, Errors: 1}); last != want || mg.Progress() != want {
+		t.Fatalf("progress: got %+v want %+v", last, want)
+	}
+}
+
+// TestSniffedDownloadIsCandidate crawls a link that gives no hint of a file
+// but is answered with one, as generic download endpoints do.
+func TestSniffedDownloadIsCandidate(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/download?id=1">roads</a>`))
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", "attachment; filename=roads.zip")
+			w.Write([]byte("PK\x03\x04 roads"))
+		}
+	}))
+	defer site.Close()
+
+	mg := NewManager(Options{})
+	mg.CachedURLEmbeddings = map[string]DataContext{site.URL + "/": {Description: "seed"}}
+	mg.pages = NewPageCache()
+	mg.crawl(context.Background(), []WebNode{{Url: site.URL + "/"}})
+
+	found := mg.Found()
+	if len(found) != 1 || found[0].Url != site.URL+"/download?id=1" {
+		t.Fatalf("found %v, progress %+v", found, mg.Progress())
+	}
+	var md downloadMetadata
+	if err := json.Unmarshal([]byte(found[0].context.Description), &md); err != nil || md.Title != "roads.zip" || md.Format != FormatZip {
+		t.Fatalf("description %q", found[0].context.Description)
+	}
+}
+
+// TestArchiveSummaryInResults downloads a zip found during the crawl and
+// expects the inspection summary in the description of the result.
+func TestArchiveSummaryInResults(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, name := range []string{"roads.shp", "roads.shx", "roads.dbf"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(name))
+	}
+	zw.Close()
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/download?id=1">roads</a>`))
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", "attachment; filename=roads.zip")
+			w.Write(zipped.Bytes())
+		}
+	}))
+	defer site.Close()
+
+	mg := NewManager(Options{DownloadDir: t.TempDir(), Inspect: true})
+	mg.pages = NewPageCache()
+	mg.crawl(context.Background(), []WebNode{{Url: site.URL + "/"}})
+	mg.downloads.Wait()
+
+	found := mg.Found()
+	if len(found) != 1 || !strings.Contains(found[0].context.Description, "1 shapefile(s)") {
+		t.Fatalf("found %+v", found)
+	}
+}
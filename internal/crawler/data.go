@@ -1,9 +1,13 @@
 package crawler
 
+// GeoMIMETypes lists media types that identify a dataset on their own. Plain
+// application/json is deliberately absent: JSON bodies are only treated as
+// datasets when sniffing finds GeoJSON or TopoJSON.
 var GeoMIMETypes = map[string]bool{
 	"application/csv":                      true,
+	"text/csv":                             true,
 	"application/zip":                      true,
-	"application/json":                     true,
+	"application/x-zip-compressed":         true,
 	"application/geo+json":                 true,
 	"application/x-geotiff":                true,
 	"application/x-shapefile":              true,
@@ -45,6 +49,24 @@ var GeoFileExtensions = map[string]bool{
 	".xml":     true,
 }
 
+// SocrataDomains lists open-data portals running Socrata. Their browse pages
+// are rendered client-side, so seeds on these hosts are searched through the
+// Socrata Discovery API instead of being crawled as HTML.
+var SocrataDomains = map[string]bool{
+	"data.cityofnewyork.us":  true,
+	"data.wa.gov":            true,
+	"data.ny.gov":            true,
+	"data.cityofchicago.org": true,
+	"data.sfgov.org":         true,
+	"data.seattle.gov":       true,
+	"data.lacity.org":        true,
+	"data.texas.gov":         true,
+	"data.colorado.gov":      true,
+	"data.ct.gov":            true,
+	"data.maryland.gov":      true,
+	"data.austintexas.gov":   true,
+}
+
 var UnwantedClassOrIDSubstrings = map[string]bool{
 	// Navigation, headers, menus
 	"nav":        true,
@@ -472,3 +494,65 @@ var PublicGeospatialDataSeeds = map[string]DataContext{
 		Description: "Czech national mapping authority GeoPortal.",
 	},
 }
+
+// usState is a US state or district with its approximate lon/lat extent.
+type usState struct {
+	Name string
+	Abbr string
+	BBox BBox
+}
+
+// usStates is the gazetteer used to recognise places in queries.
+var usStates = []usState{
+	{"Alabama", "AL", BBox{-88.47, 30.22, -84.89, 35.01}},
+	{"Alaska", "AK", BBox{-179.15, 51.21, -129.98, 71.39}},
+	{"Arizona", "AZ", BBox{-114.82, 31.33, -109.05, 37.00}},
+	{"Arkansas", "AR", BBox{-94.62, 33.00, -89.64, 36.50}},
+	{"California", "CA", BBox{-124.41, 32.53, -114.13, 42.01}},
+	{"Colorado", "CO", BBox{-109.06, 36.99, -102.04, 41.00}},
+	{"Connecticut", "CT", BBox{-73.73, 40.98, -71.79, 42.05}},
+	{"Delaware", "DE", BBox{-75.79, 38.45, -75.05, 39.84}},
+	{"District of Columbia", "DC", BBox{-77.12, 38.79, -76.91, 38.99}},
+	{"Florida", "FL", BBox{-87.63, 24.52, -80.03, 31.00}},
+	{"Georgia", "GA", BBox{-85.61, 30.36, -80.84, 35.00}},
+	{"Hawaii", "HI", BBox{-160.25, 18.91, -154.81, 22.24}},
+	{"Idaho", "ID", BBox{-117.24, 41.99, -111.04, 49.00}},
+	{"Illinois", "IL", BBox{-91.51, 36.97, -87.49, 42.51}},
+	{"Indiana", "IN", BBox{-88.10, 37.77, -84.78, 41.76}},
+	{"Iowa", "IA", BBox{-96.64, 40.38, -90.14, 43.50}},
+	{"Kansas", "KS", BBox{-102.05, 36.99, -94.59, 40.00}},
+	{"Kentucky", "KY", BBox{-89.57, 36.50, -81.96, 39.15}},
+	{"Louisiana", "LA", BBox{-94.04, 28.93, -88.82, 33.02}},
+	{"Maine", "ME", BBox{-71.08, 43.06, -66.95, 47.46}},
+	{"Maryland", "MD", BBox{-79.49, 37.91, -75.05, 39.72}},
+	{"Massachusetts", "MA", BBox{-73.51, 41.24, -69.93, 42.89}},
+	{"Michigan", "MI", BBox{-90.42, 41.70, -82.41, 48.31}},
+	{"Minnesota", "MN", BBox{-97.24, 43.50, -89.49, 49.38}},
+	{"Mississippi", "MS", BBox{-91.66, 30.17, -88.10, 35.00}},
+	{"Missouri", "MO", BBox{-95.77, 35.99, -89.10, 40.61}},
+	{"Montana", "MT", BBox{-116.05, 44.36, -104.04, 49.00}},
+	{"Nebraska", "NE", BBox{-104.05, 40.00, -95.31, 43.00}},
+	{"Nevada", "NV", BBox{-120.01, 35.00, -114.04, 42.00}},
+	{"New Hampshire", "NH", BBox{-72.56, 42.70, -70.61, 45.31}},
+	{"New Jersey", "NJ", BBox{-75.56, 38.93, -73.89, 41.36}},
+	{"New Mexico", "NM", BBox{-109.05, 31.33, -103.00, 37.00}},
+	{"New York", "NY", BBox{-79.76, 40.50, -71.86, 45.02}},
+	{"North Carolina", "NC", BBox{-84.32, 33.84, -75.46, 36.59}},
+	{"North Dakota", "ND", BBox{-104.05, 45.94, -96.55, 49.00}},
+	{"Ohio", "OH", BBox{-84.82, 38.40, -80.52, 41.98}},
+	{"Oklahoma", "OK", BBox{-103.00, 33.62, -94.43, 37.00}},
+	{"Oregon", "OR", BBox{-124.57, 41.99, -116.46, 46.29}},
+	{"Pennsylvania", "PA", BBox{-80.52, 39.72, -74.69, 42.27}},
+	{"Rhode Island", "RI", BBox{-71.91, 41.15, -71.12, 42.02}},
+	{"South Carolina", "SC", BBox{-83.35, 32.03, -78.54, 35.22}},
+	{"South Dakota", "SD", BBox{-104.06, 42.48, -96.44, 45.95}},
+	{"Tennessee", "TN", BBox{-90.31, 34.98, -81.65, 36.68}},
+	{"Texas", "TX", BBox{-106.65, 25.84, -93.51, 36.50}},
+	{"Utah", "UT", BBox{-114.05, 37.00, -109.04, 42.00}},
+	{"Vermont", "VT", BBox{-73.44, 42.73, -71.46, 45.02}},
+	{"Virginia", "VA", BBox{-83.68, 36.54, -75.24, 39.47}},
+	{"Washington", "WA", BBox{-124.85, 45.54, -116.92, 49.00}},
+	{"West Virginia", "WV", BBox{-82.64, 37.20, -77.72, 40.64}},
+	{"Wisconsin", "WI", BBox{-92.89, 42.49, -86.25, 47.08}},
+	{"Wyoming", "WY", BBox{-111.06, 40.99, -104.05, 45.01}},
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package crawler
+
+// freeBytes cannot determine the free space on this system, so the minimum
+// free space check is skipped.
+func freeBytes(dir string) int64 {
+	return -1
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package crawler
+
+import "syscall"
+
+// freeBytes returns the space available to unprivileged users on the file
+// system holding dir, or -1 if it cannot be determined.
+func freeBytes(dir string) int64 {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return -1
+	}
+	free := int64(st.Bavail) * int64(st.Bsize)
+	if free < 0 {
+		return 0
+	}
+	return free
+}
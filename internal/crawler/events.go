@@ -0,0 +1,134 @@
+package crawler
+
+// Event kinds reported while a crawl runs.
+const (
+	EventCandidate = "candidate" // a downloadable dataset was found
+	EventPage      = "page"      // a page was fetched and parsed
+	EventError     = "error"     // fetching or parsing a page failed
+	EventDownload  = "download"  // a file download made progress or finished
+)
+
+// Progress counts the work of a crawl so far.
+type Progress struct {
+	Fetched int `json:"fetched"` // pages fetched and parsed
+	Queued  int `json:"queued"`  // pages scheduled but not yet finished
+	Found   int `json:"found"`   // candidates found
+	Errors  int `json:"errors"`  // pages that failed
+	Reused  int `json:"reused"`  // fetched pages whose links came from the page cache
+	// Duplicates counts fetched pages whose links were not followed because
+	// a page with nearly the same text was crawled already.
+	Duplicates int `json:"duplicates"`
+	// Filtered counts links not crawled because they were out of the scope
+	// of their seed or its budgets were spent.
+	Filtered int `json:"filtered"`
+}
+
+// Event is passed to Options.OnEvent as the crawl makes progress. Events are
+// delivered one at a time, so the callback needs no locking of its own, but
+// it runs on the crawl goroutines and should return quickly.
+type Event struct {
+	Kind     string
+	Node     WebNode  // the candidate, the page that was fetched or failed, or the file downloaded
+	Err      error    // set for EventError
+	Transfer Transfer // set for EventDownload
+	Progress Progress // totals including this event
+}
+
+// Transfer is the progress of one file download.
+type Transfer struct {
+	Bytes    int64 // received so far
+	Total    int64 // size of the file; -1 when the server did not say
+	Segments int   // connections the file is downloaded over
+	Done     bool  // the download finished or failed
+}
+
+// Progress returns the crawl totals so far.
+func (m *Manager) Progress() Progress {
+	m.eventMu.Lock()
+	defer m.eventMu.Unlock()
+	return m.progress
+}
+
+// addCandidate records a downloadable link and reports it, unless the same
+// URL, compared by canonical key, was already found through another page.
+func (m *Manager) addCandidate(n WebNode) {
+	m.linkChan <- struct{}{}
+	if m.candidates == nil {
+		m.candidates = make(map[string]bool)
+	}
+	key := canonicalKey(n.Url)
+	dup := m.candidates[key]
+	if !dup {
+		m.candidates[key] = true
+		m.downloadURLs = append(m.downloadURLs, n)
+	}
+	<-m.linkChan
+	if !dup {
+		m.emit(Event{Kind: EventCandidate, Node: n})
+	}
+}
+
+// describeCandidate gives the stored candidate with the URL of n the
+// description of n, which grows as its download is inspected.
+func (m *Manager) describeCandidate(n *WebNode) {
+	key := canonicalKey(n.Url)
+	m.linkChan <- struct{}{}
+	for i := range m.downloadURLs {
+		if canonicalKey(m.downloadURLs[i].Url) == key {
+			m.downloadURLs[i].context.Description = n.context.Description
+			break
+		}
+	}
+	<-m.linkChan
+}
+
+// emitReused counts a page answered from the page cache, either unchanged
+// since the last crawl or too recent to fetch again. The page itself is
+// reported by Crawl2.
+func (m *Manager) emitReused() {
+	m.eventMu.Lock()
+	m.progress.Reused++
+	m.eventMu.Unlock()
+}
+
+// emitDuplicate counts a page skipped as a near-duplicate.
+func (m *Manager) emitDuplicate() {
+	m.eventMu.Lock()
+	m.progress.Duplicates++
+	m.eventMu.Unlock()
+}
+
+// emitFiltered counts a link left out of the crawl by its seed's scope.
+func (m *Manager) emitFiltered() {
+	m.eventMu.Lock()
+	m.progress.Filtered++
+	m.eventMu.Unlock()
+}
+
+// queue adjusts the number of pages waiting to be fetched.
+func (m *Manager) queue(delta int) {
+	m.eventMu.Lock()
+	m.progress.Queued += delta
+	m.eventMu.Unlock()
+}
+
+// emit updates the progress counters for ev and passes it to the callback.
+func (m *Manager) emit(ev Event) {
+	m.eventMu.Lock()
+	defer m.eventMu.Unlock()
+	switch ev.Kind {
+	case EventCandidate:
+		m.progress.Found++
+	case EventPage:
+		m.progress.Fetched++
+		m.progress.Queued--
+	case EventError:
+		m.progress.Errors++
+		m.progress.Queued--
+		m.countError(ev)
+	}
+	if m.onEvent != nil {
+		ev.Progress = m.progress
+		m.onEvent(ev)
+	}
+}
@@ -0,0 +1,133 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hostGate enforces the concurrency and delay overrides of one host.
+type hostGate struct {
+	slots chan struct{} // nil when concurrency is unlimited
+	mu    sync.Mutex
+	next  time.Time // earliest start of the next request
+}
+
+var (
+	hostGatesMu sync.Mutex
+	hostGates   = map[string]*hostGate{}
+)
+
+func resetHostGates() {
+	hostGatesMu.Lock()
+	hostGates = map[string]*hostGate{}
+	hostGatesMu.Unlock()
+}
+
+// acquireHost waits until a request to host may start and returns the
+// function that releases its slot.
+func acquireHost(host string, hc HostConfig) func() {
+	if hc.Concurrency == 0 && hc.Delay == 0 {
+		return func() {}
+	}
+	hostGatesMu.Lock()
+	g, ok := hostGates[host]
+	if !ok {
+		g = &hostGate{}
+		if hc.Concurrency > 0 {
+			g.slots = make(chan struct{}, hc.Concurrency)
+		}
+		hostGates[host] = g
+	}
+	hostGatesMu.Unlock()
+
+	if g.slots != nil {
+		g.slots <- struct{}{}
+	}
+	if hc.Delay > 0 {
+		g.mu.Lock()
+		now := time.Now()
+		start := g.next
+		if start.Before(now) {
+			start = now
+		}
+		g.next = start.Add(hc.Delay)
+		g.mu.Unlock()
+		time.Sleep(time.Until(start))
+	}
+	return func() {
+		if g.slots != nil {
+			<-g.slots
+		}
+	}
+}
+
+// httpGet fetches rawURL with the configured user agent, honouring the
+// per-host overrides. A host slot is held until the response headers arrive.
+func httpGet(rawURL string) (*http.Response, error) {
+	return httpGetConditional(rawURL, "", "")
+}
+
+// httpGetConditional is httpGetContext without a deadline.
+func httpGetConditional(rawURL, etag, lastModified string) (*http.Response, error) {
+	return httpGetContext(context.Background(), rawURL, etag, lastModified)
+}
+
+// httpGetContext is httpGet under ctx, with the validators of an earlier
+// response: the server answers 304 Not Modified if the resource has not changed since.
+// Unsafe destinations are refused with ErrUnsafeURL, and requests that end
+// on a login page with ErrLoginRequired. Timeouts, dropped connections and
+// responses such as 503 are retried up to maxRetries times, after a backoff
+// that honours Retry-After; the host slot is not held while waiting.
+func httpGetContext(ctx context.Context, rawURL, etag, lastModified string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	hc := activeConfig.Host(req.URL.Hostname())
+	req.Header.Set("User-Agent", hc.UserAgent)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	for attempt := 0; ; attempt++ {
+		release := acquireHost(req.URL.Hostname(), hc)
+		resp, err := fetchClient.Do(req)
+		release()
+		if err == nil {
+			if err := checkLogin(resp); err != nil {
+				return nil, err
+			}
+		}
+		var reason string
+		switch {
+		case attempt >= maxRetries:
+		case err != nil && retryable(err):
+			reason = err.Error()
+		case err == nil && retryStatus[resp.StatusCode]:
+			reason = resp.Status
+		}
+		if reason == "" {
+			return resp, err
+		}
+		wait := retryDelay(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		log.Printf("retrying %s in %v (%d of %d): %s", rawURL, wait.Round(time.Millisecond), attempt+1, maxRetries, reason)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
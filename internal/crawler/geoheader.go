@@ -0,0 +1,1121 @@
+package crawler
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BBox is an axis-aligned bounding box in the coordinate system of the file
+// it was read from.
+type BBox struct {
+	MinX float64 `json:"min_x"`
+	MinY float64 `json:"min_y"`
+	MaxX float64 `json:"max_x"`
+	MaxY float64 `json:"max_y"`
+}
+
+// Intersects reports whether b and o overlap.
+func (b BBox) Intersects(o BBox) bool {
+	return b.MinX <= o.MaxX && o.MinX <= b.MaxX && b.MinY <= o.MaxY && o.MinY <= b.MaxY
+}
+
+// Contains reports whether o lies entirely inside b.
+func (b BBox) Contains(o BBox) bool {
+	return b.MinX <= o.MinX && b.MinY <= o.MinY && b.MaxX >= o.MaxX && b.MaxY >= o.MaxY
+}
+
+// extend grows b to include the point (x, y).
+func (b *BBox) extend(x, y float64) {
+	b.MinX = math.Min(b.MinX, x)
+	b.MinY = math.Min(b.MinY, y)
+	b.MaxX = math.Max(b.MaxX, x)
+	b.MaxY = math.Max(b.MaxY, y)
+}
+
+func emptyBBox() BBox {
+	return BBox{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+}
+
+func (b BBox) valid() bool {
+	return !math.IsInf(b.MinX, 0) && b.MinX <= b.MaxX && b.MinY <= b.MaxY
+}
+
+// GeoInfo holds metadata read from the header of a geospatial file. Only the
+// fields relevant to the format are set.
+type GeoInfo struct {
+	Format       string            `json:"format"`
+	BBox         *BBox             `json:"bbox,omitempty"`
+	CRS          string            `json:"crs,omitempty"` // EPSG code or WKT name
+	GeometryType string            `json:"geometry_type,omitempty"`
+	FeatureCount int64             `json:"feature_count,omitempty"`
+	Fields       []string          `json:"fields,omitempty"`
+	Width        int               `json:"width,omitempty"`
+	Height       int               `json:"height,omitempty"`
+	PixelScale   []float64         `json:"pixel_scale,omitempty"`
+	Dimensions   map[string]int64  `json:"dimensions,omitempty"`
+	Variables    []string          `json:"variables,omitempty"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+// geographicCRS lists coordinate systems whose coordinates are longitude and
+// latitude in degrees.
+var geographicCRS = map[string]bool{
+	"EPSG:4326": true, "EPSG:4269": true, "EPSG:4267": true, "EPSG:4258": true,
+	"GCS_WGS_1984": true, "GCS_North_American_1983": true, "WGS 84": true, "NAD83": true,
+	"urn:ogc:def:crs:OGC:1.3:CRS84": true, "urn:ogc:def:crs:EPSG::4326": true,
+}
+
+// LonLatBBox returns the extent in longitude/latitude when the file's CRS is
+// geographic, or when no CRS is known and the extent fits in degree ranges.
+func (g *GeoInfo) LonLatBBox() (BBox, bool) {
+	if g == nil || g.BBox == nil {
+		return BBox{}, false
+	}
+	b := *g.BBox
+	if geographicCRS[g.CRS] {
+		return b, true
+	}
+	if g.CRS == "" && b.MinX >= -180 && b.MaxX <= 180 && b.MinY >= -90 && b.MaxY <= 90 {
+		return b, true
+	}
+	return BBox{}, false
+}
+
+// CoversArea reports whether the file's extent overlaps area, given in
+// longitude/latitude. Files without a usable extent report false.
+func (g *GeoInfo) CoversArea(area BBox) bool {
+	b, ok := g.LonLatBBox()
+	return ok && b.Intersects(area)
+}
+
+// ErrUnsupportedFormat is returned by ReadGeoInfo for files it cannot read.
+var ErrUnsupportedFormat = errors.New("unsupported geospatial format")
+
+// ReadGeoInfo reads the header of the file at p and returns its extent, CRS
+// and schema. The reader is chosen by file extension.
+func ReadGeoInfo(p string) (*GeoInfo, error) {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".shp":
+		return ReadShapefileInfo(p)
+	case ".geojson":
+		return ReadGeoJSONInfo(p)
+	case ".json":
+		// Most JSON files are API responses and configuration, not GeoJSON.
+		if format, err := sniffFile(p); err != nil {
+			return nil, err
+		} else if format != FormatGeoJSON {
+			return nil, ErrUnsupportedFormat
+		}
+		return ReadGeoJSONInfo(p)
+	case ".tif", ".tiff":
+		return ReadGeoTIFFInfo(p)
+	case ".kml":
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ReadKMLInfo(f)
+	case ".kmz":
+		return ReadKMZInfo(p)
+	case ".las", ".laz":
+		return ReadLASInfo(p)
+	case ".nc":
+		return ReadNetCDFInfo(p)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// sniffFile returns the format of the file at p, sniffed from its first
+// bytes.
+func sniffFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return SniffFormat(head[:n]), nil
+}
+
+// ------------------------------------------------------------------
+// Shapefile
+// ------------------------------------------------------------------
+
+var shapeTypes = map[int32]string{
+	0: "Null", 1: "Point", 3: "PolyLine", 5: "Polygon", 8: "MultiPoint",
+	11: "PointZ", 13: "PolyLineZ", 15: "PolygonZ", 18: "MultiPointZ",
+	21: "PointM", 23: "PolyLineM", 25: "PolygonM", 28: "MultiPointM",
+	31: "MultiPatch",
+}
+
+// ReadShapefileInfo reads the 100-byte .shp header for the bounding box and
+// geometry type, the .prj sidecar for the CRS, and the .dbf sidecar for the
+// record count and field names.
+func ReadShapefileInfo(p string) (*GeoInfo, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hdr [100]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading shapefile header: %w", err)
+	}
+	if binary.BigEndian.Uint32(hdr[0:4]) != 9994 {
+		return nil, fmt.Errorf("%s is not a shapefile", p)
+	}
+	info := &GeoInfo{Format: "shapefile"}
+	shapeType := int32(binary.LittleEndian.Uint32(hdr[32:36]))
+	info.GeometryType = shapeTypes[shapeType]
+	info.BBox = &BBox{
+		MinX: math.Float64frombits(binary.LittleEndian.Uint64(hdr[36:44])),
+		MinY: math.Float64frombits(binary.LittleEndian.Uint64(hdr[44:52])),
+		MaxX: math.Float64frombits(binary.LittleEndian.Uint64(hdr[52:60])),
+		MaxY: math.Float64frombits(binary.LittleEndian.Uint64(hdr[60:68])),
+	}
+
+	base := strings.TrimSuffix(p, filepath.Ext(p))
+	if wkt, err := readSidecar(base, ".prj"); err == nil {
+		info.CRS = WKTName(string(wkt))
+	}
+	if dbf, err := openSidecar(base, ".dbf"); err == nil {
+		defer dbf.Close()
+		if count, fields, err := ReadDBFHeader(dbf); err == nil {
+			info.FeatureCount = count
+			info.Fields = fields
+		}
+	}
+	return info, nil
+}
+
+// openSidecar opens base+ext, also trying the upper-case extension.
+func openSidecar(base, ext string) (*os.File, error) {
+	f, err := os.Open(base + ext)
+	if err != nil {
+		f, err = os.Open(base + strings.ToUpper(ext))
+	}
+	return f, err
+}
+
+func readSidecar(base, ext string) ([]byte, error) {
+	f, err := openSidecar(base, ext)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, 1<<20))
+}
+
+var wktNameRe = regexp.MustCompile(`^\s*(PROJCS|GEOGCS|PROJCRS|GEOGCRS|GEODCRS|COMPD_CS)\s*\[\s*"([^"]*)"`)
+var wktAuthorityRe = regexp.MustCompile(`AUTHORITY\s*\[\s*"EPSG"\s*,\s*"?(\d+)"?\s*\]\s*\]\s*$`)
+
+// WKTName returns "EPSG:<code>" when a WKT string carries a top-level EPSG
+// authority, and otherwise the name of its outermost coordinate system.
+func WKTName(wkt string) string {
+	wkt = strings.TrimSpace(wkt)
+	if m := wktAuthorityRe.FindStringSubmatch(wkt); m != nil {
+		return "EPSG:" + m[1]
+	}
+	if m := wktNameRe.FindStringSubmatch(wkt); m != nil {
+		return m[2]
+	}
+	return ""
+}
+
+// ReadDBFHeader reads a dBASE table header and returns the record count and
+// the field names.
+func ReadDBFHeader(r io.Reader) (int64, []string, error) {
+	var hdr [32]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, fmt.Errorf("reading dbf header: %w", err)
+	}
+	count := int64(binary.LittleEndian.Uint32(hdr[4:8]))
+	headerLen := int(binary.LittleEndian.Uint16(hdr[8:10]))
+	var fields []string
+	for read := 32; read+32 <= headerLen; read += 32 {
+		var desc [32]byte
+		if _, err := io.ReadFull(r, desc[:1]); err != nil {
+			return count, fields, err
+		}
+		if desc[0] == 0x0D {
+			break
+		}
+		if _, err := io.ReadFull(r, desc[1:]); err != nil {
+			return count, fields, err
+		}
+		name := string(bytes.TrimRight(desc[0:11], "\x00 "))
+		fields = append(fields, name)
+	}
+	return count, fields, nil
+}
+
+// ------------------------------------------------------------------
+// GeoJSON
+// ------------------------------------------------------------------
+
+// ReadGeoJSONInfo streams a GeoJSON document and returns its bounding box,
+// feature count and geometry type. A "bbox" member on the top-level object is
+// used when present, otherwise the extent is computed from all coordinates.
+// Features are read one token at a time, so memory does not grow with the
+// size of the file.
+func ReadGeoJSONInfo(p string) (*GeoInfo, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readGeoJSON(bufio.NewReader(f))
+}
+
+func readGeoJSON(r io.Reader) (*GeoInfo, error) {
+	w := &geoJSONWalker{dec: json.NewDecoder(r), box: emptyBBox(), geomTypes: make(map[string]bool)}
+	if err := w.document(); err != nil {
+		return nil, fmt.Errorf("decoding geojson: %w", err)
+	}
+	info := &GeoInfo{Format: "geojson", CRS: w.crs}
+	if info.CRS == "" {
+		info.CRS = "EPSG:4326"
+	}
+	switch w.typ {
+	case "FeatureCollection":
+		info.FeatureCount = w.features
+	case "":
+		return nil, errors.New("not a geojson document")
+	default:
+		info.FeatureCount = 1
+	}
+
+	if len(w.bbox) >= 4 {
+		n := len(w.bbox) / 2
+		info.BBox = &BBox{MinX: w.bbox[0], MinY: w.bbox[1], MaxX: w.bbox[n], MaxY: w.bbox[n+1]}
+	} else if w.box.valid() {
+		info.BBox = &w.box
+	}
+	if len(w.geomTypes) == 1 {
+		for t := range w.geomTypes {
+			info.GeometryType = t
+		}
+	} else if len(w.geomTypes) > 1 {
+		info.GeometryType = "Mixed"
+	}
+	return info, nil
+}
+
+// geoJSONWalker reads a GeoJSON document token by token, counting features
+// and folding coordinates into box as they go by.
+type geoJSONWalker struct {
+	dec       *json.Decoder
+	typ       string // type of the top-level object
+	crs       string
+	bbox      []float64
+	features  int64
+	box       BBox
+	geomTypes map[string]bool
+}
+
+// document reads the top-level object. Its members may come in any order,
+// so a bare geometry's type is only recorded once the whole object is read.
+func (w *geoJSONWalker) document() error {
+	if err := w.expect('{'); err != nil {
+		return err
+	}
+	for w.dec.More() {
+		key, err := w.key()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "type":
+			err = w.dec.Decode(&w.typ)
+		case "bbox":
+			err = w.dec.Decode(&w.bbox)
+		case "crs":
+			var crs struct {
+				Properties struct {
+					Name string `json:"name"`
+				} `json:"properties"`
+			}
+			if err = w.dec.Decode(&crs); err == nil {
+				w.crs = crs.Properties.Name
+			}
+		case "features":
+			err = w.array(w.feature)
+		case "geometry":
+			err = w.geometry()
+		case "coordinates":
+			err = w.coordinates()
+		case "geometries":
+			err = w.array(w.geometry)
+		default:
+			err = w.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.dec.Token(); err != nil {
+		return err
+	}
+	switch w.typ {
+	case "FeatureCollection", "Feature", "":
+	default:
+		w.geomTypes[w.typ] = true
+	}
+	return nil
+}
+
+// feature reads one element of a "features" array.
+func (w *geoJSONWalker) feature() error {
+	w.features++
+	return w.object(func(key string) error {
+		if key == "geometry" {
+			return w.geometry()
+		}
+		return w.skip()
+	})
+}
+
+// geometry reads a geometry object, or null.
+func (w *geoJSONWalker) geometry() error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return w.skipRest(tok)
+	}
+	var typ string
+	for w.dec.More() {
+		key, err := w.key()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "type":
+			err = w.dec.Decode(&typ)
+		case "coordinates":
+			err = w.coordinates()
+		case "geometries":
+			err = w.array(w.geometry)
+		default:
+			err = w.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if typ != "" {
+		w.geomTypes[typ] = true
+	}
+	_, err = w.dec.Token()
+	return err
+}
+
+// coordinates reads nested coordinate arrays and grows box by each
+// position in them.
+func (w *geoJSONWalker) coordinates() error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return w.skipRest(tok)
+	}
+	return w.positions()
+}
+
+// positions reads the rest of a coordinate array whose '[' has been read.
+// An array starting with two numbers is a position; any other holds more
+// arrays.
+func (w *geoJSONWalker) positions() error {
+	var pos []float64
+	for w.dec.More() {
+		tok, err := w.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch v := tok.(type) {
+		case float64:
+			pos = append(pos, v)
+		case json.Delim:
+			if v == '[' {
+				err = w.positions()
+			} else {
+				err = w.skipRest(tok)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(pos) >= 2 {
+		w.box.extend(pos[0], pos[1])
+	}
+	_, err := w.dec.Token()
+	return err
+}
+
+// array calls elem for each element of an array; any other value is
+// skipped.
+func (w *geoJSONWalker) array(elem func() error) error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return w.skipRest(tok)
+	}
+	for w.dec.More() {
+		if err := elem(); err != nil {
+			return err
+		}
+	}
+	_, err = w.dec.Token()
+	return err
+}
+
+// object calls member with the key of each member of an object, which must
+// read the value; any other value is skipped.
+func (w *geoJSONWalker) object(member func(key string) error) error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return w.skipRest(tok)
+	}
+	for w.dec.More() {
+		key, err := w.key()
+		if err != nil {
+			return err
+		}
+		if err := member(key); err != nil {
+			return err
+		}
+	}
+	_, err = w.dec.Token()
+	return err
+}
+
+func (w *geoJSONWalker) expect(d json.Delim) error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return fmt.Errorf("expected %v, found %v", d, tok)
+	}
+	return nil
+}
+
+func (w *geoJSONWalker) key() (string, error) {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("expected an object key, found %v", tok)
+	}
+	return key, nil
+}
+
+// skip reads a value without keeping it.
+func (w *geoJSONWalker) skip() error {
+	tok, err := w.dec.Token()
+	if err != nil {
+		return err
+	}
+	return w.skipRest(tok)
+}
+
+// skipRest reads the rest of a value whose first token is tok.
+func (w *geoJSONWalker) skipRest(tok json.Token) error {
+	if tok != json.Delim('{') && tok != json.Delim('[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := w.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------
+// GeoTIFF
+// ------------------------------------------------------------------
+
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffModelPixelScale = 33550
+	tiffModelTiepoint   = 33922
+	tiffGeoKeyDirectory = 34735
+	geoKeyGeographic    = 2048
+	geoKeyProjected     = 3072
+)
+
+// ReadGeoTIFFInfo reads the first image file directory of a (Big)TIFF and
+// returns the raster size, pixel scale, EPSG code and, when a tie point is
+// present, the bounding box.
+func ReadGeoTIFFInfo(p string) (*GeoInfo, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readGeoTIFF(f)
+}
+
+func readGeoTIFF(r io.ReaderAt) (*GeoInfo, error) {
+	var hdr [16]byte
+	if _, err := r.ReadAt(hdr[:8], 0); err != nil {
+		return nil, fmt.Errorf("reading tiff header: %w", err)
+	}
+	var bo binary.ByteOrder
+	switch string(hdr[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, errors.New("not a tiff file")
+	}
+	big := false
+	var ifdOff int64
+	switch bo.Uint16(hdr[2:4]) {
+	case 42:
+		ifdOff = int64(bo.Uint32(hdr[4:8]))
+	case 43:
+		big = true
+		if _, err := r.ReadAt(hdr[:16], 0); err != nil {
+			return nil, err
+		}
+		ifdOff = int64(bo.Uint64(hdr[8:16]))
+	default:
+		return nil, errors.New("not a tiff file")
+	}
+
+	countSize, entrySize := int64(2), int64(12)
+	if big {
+		countSize, entrySize = 8, 20
+	}
+	buf := make([]byte, countSize)
+	if _, err := r.ReadAt(buf, ifdOff); err != nil {
+		return nil, fmt.Errorf("reading tiff ifd: %w", err)
+	}
+	var n int64
+	if big {
+		n = int64(bo.Uint64(buf))
+	} else {
+		n = int64(bo.Uint16(buf))
+	}
+	if n > 4096 {
+		return nil, errors.New("tiff ifd too large")
+	}
+	entries := make([]byte, n*entrySize)
+	if _, err := r.ReadAt(entries, ifdOff+countSize); err != nil {
+		return nil, fmt.Errorf("reading tiff ifd: %w", err)
+	}
+
+	info := &GeoInfo{Format: "geotiff"}
+	var tiepoint, scale []float64
+	var geoKeys []uint16
+	for i := int64(0); i < n; i++ {
+		e := entries[i*entrySize : (i+1)*entrySize]
+		tag := bo.Uint16(e[0:2])
+		typ := bo.Uint16(e[2:4])
+		var count int64
+		var valueField []byte
+		if big {
+			count = int64(bo.Uint64(e[4:12]))
+			valueField = e[12:20]
+		} else {
+			count = int64(bo.Uint32(e[4:8]))
+			valueField = e[8:12]
+		}
+		switch tag {
+		case tiffImageWidth, tiffImageLength:
+			v, err := tiffInt(bo, typ, big, valueField)
+			if err != nil {
+				return nil, err
+			}
+			if tag == tiffImageWidth {
+				info.Width = v
+			} else {
+				info.Height = v
+			}
+		case tiffModelPixelScale, tiffModelTiepoint:
+			vals, err := tiffDoubles(r, bo, big, count, valueField)
+			if err != nil {
+				continue
+			}
+			if tag == tiffModelPixelScale {
+				scale = vals
+			} else {
+				tiepoint = vals
+			}
+		case tiffGeoKeyDirectory:
+			keys, err := tiffShorts(r, bo, big, count, valueField)
+			if err == nil {
+				geoKeys = keys
+			}
+		}
+	}
+
+	if len(scale) >= 2 {
+		info.PixelScale = scale[:2]
+	}
+	if code := geoKeyEPSG(geoKeys); code != 0 {
+		info.CRS = "EPSG:" + strconv.Itoa(code)
+	}
+	if len(tiepoint) >= 6 && len(scale) >= 2 && info.Width > 0 && info.Height > 0 {
+		// Tie point maps raster (I,J) to model (X,Y); rows grow southwards.
+		originX := tiepoint[3] - tiepoint[0]*scale[0]
+		originY := tiepoint[4] + tiepoint[1]*scale[1]
+		info.BBox = &BBox{
+			MinX: originX,
+			MaxX: originX + float64(info.Width)*scale[0],
+			MaxY: originY,
+			MinY: originY - float64(info.Height)*scale[1],
+		}
+	}
+	return info, nil
+}
+
+// tiffInt returns the integer stored inline in the value field v. LONG8
+// values only fit in the 8-byte fields of a BigTIFF.
+func tiffInt(bo binary.ByteOrder, typ uint16, big bool, v []byte) (int, error) {
+	switch {
+	case typ == 3 && len(v) >= 2: // SHORT
+		return int(bo.Uint16(v[0:2])), nil
+	case typ == 4 && len(v) >= 4: // LONG
+		return int(bo.Uint32(v[0:4])), nil
+	case typ == 16 && big && len(v) >= 8: // LONG8
+		return int(bo.Uint64(v[0:8])), nil
+	}
+	return 0, fmt.Errorf("tiff image size of type %d", typ)
+}
+
+// tiffOffset returns where an out-of-line tag value starts.
+func tiffOffset(bo binary.ByteOrder, big bool, v []byte) int64 {
+	if big {
+		return int64(bo.Uint64(v))
+	}
+	return int64(bo.Uint32(v))
+}
+
+func tiffDoubles(r io.ReaderAt, bo binary.ByteOrder, big bool, count int64, v []byte) ([]float64, error) {
+	if count <= 0 || count > 1<<16 {
+		return nil, errors.New("bad tiff value count")
+	}
+	buf := make([]byte, count*8)
+	if _, err := r.ReadAt(buf, tiffOffset(bo, big, v)); err != nil {
+		return nil, err
+	}
+	out := make([]float64, count)
+	for i := range out {
+		out[i] = math.Float64frombits(bo.Uint64(buf[i*8:]))
+	}
+	return out, nil
+}
+
+func tiffShorts(r io.ReaderAt, bo binary.ByteOrder, big bool, count int64, v []byte) ([]uint16, error) {
+	if count <= 0 || count > 1<<16 {
+		return nil, errors.New("bad tiff value count")
+	}
+	buf := make([]byte, count*2)
+	inline := int64(4)
+	if big {
+		inline = 8
+	}
+	if count*2 <= inline {
+		copy(buf, v)
+	} else if _, err := r.ReadAt(buf, tiffOffset(bo, big, v)); err != nil {
+		return nil, err
+	}
+	out := make([]uint16, count)
+	for i := range out {
+		out[i] = bo.Uint16(buf[i*2:])
+	}
+	return out, nil
+}
+
+// geoKeyEPSG returns the projected or geographic EPSG code stored inline in a
+// GeoKeyDirectory, or 0 when none is present.
+func geoKeyEPSG(keys []uint16) int {
+	if len(keys) < 4 {
+		return 0
+	}
+	var geographic int
+	for i := 4; i+3 < len(keys); i += 4 {
+		id, loc, val := keys[i], keys[i+1], keys[i+3]
+		if loc != 0 || val == 0 || val == 32767 {
+			continue
+		}
+		switch id {
+		case geoKeyProjected:
+			return int(val)
+		case geoKeyGeographic:
+			geographic = int(val)
+		}
+	}
+	return geographic
+}
+
+// ------------------------------------------------------------------
+// KML / KMZ
+// ------------------------------------------------------------------
+
+// ReadKMLInfo scans a KML document and returns the extent of all coordinates
+// and the number of placemarks.
+func ReadKMLInfo(r io.Reader) (*GeoInfo, error) {
+	dec := xml.NewDecoder(r)
+	info := &GeoInfo{Format: "kml", CRS: "EPSG:4326"}
+	box := emptyBBox()
+	inCoords := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding kml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "Placemark":
+				info.FeatureCount++
+			case "coordinates":
+				inCoords = true
+			}
+		case xml.EndElement:
+			if t.Name.Local == "coordinates" {
+				inCoords = false
+			}
+		case xml.CharData:
+			if !inCoords {
+				continue
+			}
+			for _, tuple := range strings.Fields(string(t)) {
+				parts := strings.Split(tuple, ",")
+				if len(parts) < 2 {
+					continue
+				}
+				x, errX := strconv.ParseFloat(parts[0], 64)
+				y, errY := strconv.ParseFloat(parts[1], 64)
+				if errX == nil && errY == nil {
+					box.extend(x, y)
+				}
+			}
+		}
+	}
+	if box.valid() {
+		info.BBox = &box
+	}
+	return info, nil
+}
+
+// ReadKMZInfo opens a KMZ archive and reads its main KML document.
+func ReadKMZInfo(p string) (*GeoInfo, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, fmt.Errorf("opening kmz: %w", err)
+	}
+	defer zr.Close()
+	var doc *zip.File
+	for _, f := range zr.File {
+		if strings.EqualFold(filepath.Ext(f.Name), ".kml") && (doc == nil || f.Name == "doc.kml") {
+			doc = f
+		}
+	}
+	if doc == nil {
+		return nil, errors.New("kmz contains no kml document")
+	}
+	rc, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	info, err := ReadKMLInfo(rc)
+	if info != nil {
+		info.Format = "kmz"
+	}
+	return info, err
+}
+
+// ------------------------------------------------------------------
+// LAS / LAZ
+// ------------------------------------------------------------------
+
+// ReadLASInfo reads the public header block of a LAS or LAZ point cloud and
+// returns the point count and 3D bounds (as a 2D box plus Z range attributes).
+func ReadLASInfo(p string) (*GeoInfo, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hdr [375]byte
+	n, err := io.ReadFull(f, hdr[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("reading las header: %w", err)
+	}
+	if n < 227 || string(hdr[0:4]) != "LASF" {
+		return nil, fmt.Errorf("%s is not a LAS file", p)
+	}
+	le := binary.LittleEndian
+	f64 := func(off int) float64 { return math.Float64frombits(le.Uint64(hdr[off : off+8])) }
+	major, minor := hdr[24], hdr[25]
+	format := "las"
+	if strings.EqualFold(filepath.Ext(p), ".laz") || hdr[104]&0x80 != 0 {
+		format = "laz"
+	}
+	info := &GeoInfo{
+		Format:       format,
+		FeatureCount: int64(le.Uint32(hdr[107:111])),
+		BBox:         &BBox{MaxX: f64(179), MinX: f64(187), MaxY: f64(195), MinY: f64(203)},
+		Attributes: map[string]string{
+			"version":      fmt.Sprintf("%d.%d", major, minor),
+			"point_format": strconv.Itoa(int(hdr[104] & 0x3f)),
+			"max_z":        strconv.FormatFloat(f64(211), 'f', -1, 64),
+			"min_z":        strconv.FormatFloat(f64(219), 'f', -1, 64),
+		},
+	}
+	// LAS 1.4 moved the point count to a 64-bit field; the legacy field is
+	// zero for files with more than 2^32 points or extended point formats.
+	if major == 1 && minor >= 4 && n >= 255 {
+		if c := int64(le.Uint64(hdr[247:255])); c > 0 {
+			info.FeatureCount = c
+		}
+	}
+	return info, nil
+}
+
+// ------------------------------------------------------------------
+// NetCDF classic
+// ------------------------------------------------------------------
+
+const (
+	ncDimension = 0x0A
+	ncVariable  = 0x0B
+	ncAttribute = 0x0C
+)
+
+// ReadNetCDFInfo parses the header of a NetCDF classic (CDF-1) or 64-bit
+// offset (CDF-2) file and returns its dimensions, variables and global
+// attributes. NetCDF-4 files are HDF5 containers and are not handled here.
+func ReadNetCDFInfo(p string) (*GeoInfo, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readNetCDF(bufio.NewReader(f), st.Size())
+}
+
+type ncReader struct {
+	r       io.Reader
+	size    int64 // size of the file, or -1 if unknown
+	version byte
+	err     error
+}
+
+func (nc *ncReader) u32() uint32 {
+	var b [4]byte
+	if nc.err == nil {
+		_, nc.err = io.ReadFull(nc.r, b[:])
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func (nc *ncReader) u64() uint64 {
+	var b [8]byte
+	if nc.err == nil {
+		_, nc.err = io.ReadFull(nc.r, b[:])
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// bytes reads n bytes followed by padding to a 4-byte boundary. Fields
+// larger than the file, or than 16 MiB, are refused before anything is
+// allocated.
+func (nc *ncReader) bytes(n uint64) []byte {
+	if nc.err != nil {
+		return nil
+	}
+	if n > 1<<24 || (nc.size >= 0 && n > uint64(nc.size)) {
+		nc.err = errors.New("netcdf header field too large")
+		return nil
+	}
+	b := make([]byte, (n+3)&^3)
+	_, nc.err = io.ReadFull(nc.r, b)
+	return b[:n]
+}
+
+func (nc *ncReader) name() string {
+	return string(nc.bytes(uint64(nc.u32())))
+}
+
+var ncTypeSize = map[uint32]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 4, 6: 8}
+
+func (nc *ncReader) attributes() map[string]string {
+	tag, n := nc.u32(), nc.u32()
+	if tag != ncAttribute && !(tag == 0 && n == 0) {
+		nc.err = errors.New("malformed netcdf attribute list")
+	}
+	attrs := make(map[string]string)
+	for i := uint32(0); i < n && nc.err == nil; i++ {
+		key := nc.name()
+		typ, count := nc.u32(), nc.u32()
+		size, ok := ncTypeSize[typ]
+		if !ok {
+			nc.err = fmt.Errorf("unknown netcdf type %d", typ)
+			break
+		}
+		raw := nc.bytes(uint64(count) * uint64(size))
+		if typ == 2 { // NC_CHAR
+			attrs[key] = strings.TrimRight(string(raw), "\x00")
+		} else {
+			attrs[key] = ncValueString(typ, raw)
+		}
+	}
+	return attrs
+}
+
+func ncValueString(typ uint32, raw []byte) string {
+	var vals []string
+	be := binary.BigEndian
+	switch typ {
+	case 1:
+		for _, b := range raw {
+			vals = append(vals, strconv.Itoa(int(int8(b))))
+		}
+	case 3:
+		for i := 0; i+2 <= len(raw); i += 2 {
+			vals = append(vals, strconv.Itoa(int(int16(be.Uint16(raw[i:])))))
+		}
+	case 4:
+		for i := 0; i+4 <= len(raw); i += 4 {
+			vals = append(vals, strconv.Itoa(int(int32(be.Uint32(raw[i:])))))
+		}
+	case 5:
+		for i := 0; i+4 <= len(raw); i += 4 {
+			vals = append(vals, strconv.FormatFloat(float64(math.Float32frombits(be.Uint32(raw[i:]))), 'g', -1, 32))
+		}
+	case 6:
+		for i := 0; i+8 <= len(raw); i += 8 {
+			vals = append(vals, strconv.FormatFloat(math.Float64frombits(be.Uint64(raw[i:])), 'g', -1, 64))
+		}
+	}
+	return strings.Join(vals, " ")
+}
+
+func readNetCDF(r io.Reader, size int64) (*GeoInfo, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("reading netcdf header: %w", err)
+	}
+	if string(magic[:3]) != "CDF" || (magic[3] != 1 && magic[3] != 2) {
+		return nil, errors.New("not a netcdf classic file")
+	}
+	nc := &ncReader{r: r, size: size, version: magic[3]}
+	info := &GeoInfo{Format: "netcdf", Dimensions: make(map[string]int64)}
+	numRecs := nc.u32()
+
+	tag, n := nc.u32(), nc.u32()
+	if tag != ncDimension && !(tag == 0 && n == 0) {
+		return nil, errors.New("malformed netcdf dimension list")
+	}
+	var dimNames []string
+	for i := uint32(0); i < n && nc.err == nil; i++ {
+		name := nc.name()
+		length := int64(nc.u32())
+		if length == 0 { // the record (unlimited) dimension
+			length = int64(numRecs)
+		}
+		info.Dimensions[name] = length
+		dimNames = append(dimNames, name)
+	}
+	info.Attributes = nc.attributes()
+
+	tag, n = nc.u32(), nc.u32()
+	if tag != ncVariable && !(tag == 0 && n == 0) && nc.err == nil {
+		return nil, errors.New("malformed netcdf variable list")
+	}
+	for i := uint32(0); i < n && nc.err == nil; i++ {
+		name := nc.name()
+		ndims := nc.u32()
+		var dims []string
+		for j := uint32(0); j < ndims && nc.err == nil; j++ {
+			id := nc.u32()
+			if int(id) < len(dimNames) {
+				dims = append(dims, dimNames[id])
+			}
+		}
+		nc.attributes()
+		nc.u32() // nc_type
+		nc.u32() // vsize
+		if nc.version == 2 {
+			nc.u64()
+		} else {
+			nc.u32()
+		}
+		info.Variables = append(info.Variables, fmt.Sprintf("%s(%s)", name, strings.Join(dims, ",")))
+	}
+	if nc.err != nil {
+		return nil, fmt.Errorf("parsing netcdf header: %w", nc.err)
+	}
+	if crs := info.Attributes["crs"]; crs != "" {
+		info.CRS = crs
+	}
+	// Files following the ACDD conventions advertise their extent globally.
+	var box [4]float64
+	ok := true
+	for i, key := range []string{"geospatial_lon_min", "geospatial_lat_min", "geospatial_lon_max", "geospatial_lat_max"} {
+		v, err := strconv.ParseFloat(strings.TrimSpace(info.Attributes[key]), 64)
+		if err != nil {
+			ok = false
+			break
+		}
+		box[i] = v
+	}
+	if ok {
+		info.BBox = &BBox{MinX: box[0], MinY: box[1], MaxX: box[2], MaxY: box[3]}
+		if info.CRS == "" {
+			info.CRS = "EPSG:4326"
+		}
+	}
+	return info, nil
+}
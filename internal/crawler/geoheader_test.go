@@ -0,0 +1,267 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadShapefileInfo(t *testing.T) {
+	dir := t.TempDir()
+	hdr := make([]byte, 100)
+	binary.BigEndian.PutUint32(hdr[0:4], 9994)
+	binary.LittleEndian.PutUint32(hdr[28:32], 1000)
+	binary.LittleEndian.PutUint32(hdr[32:36], 5)
+	for i, v := range []float64{-84.82, 38.40, -80.52, 41.98} {
+		binary.LittleEndian.PutUint64(hdr[36+i*8:], math.Float64bits(v))
+	}
+	os.WriteFile(filepath.Join(dir, "ohio.shp"), hdr, 0644)
+	os.WriteFile(filepath.Join(dir, "ohio.prj"), []byte(`GEOGCS["GCS_North_American_1983",DATUM["D_North_American_1983",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`), 0644)
+
+	var dbf bytes.Buffer
+	dbfHdr := make([]byte, 32)
+	binary.LittleEndian.PutUint32(dbfHdr[4:8], 88)
+	binary.LittleEndian.PutUint16(dbfHdr[8:10], 32+2*32+1)
+	dbf.Write(dbfHdr)
+	for _, name := range []string{"COUNTY", "FIPS"} {
+		field := make([]byte, 32)
+		copy(field, name)
+		dbf.Write(field)
+	}
+	dbf.WriteByte(0x0D)
+	os.WriteFile(filepath.Join(dir, "ohio.dbf"), dbf.Bytes(), 0644)
+
+	info, err := ReadGeoInfo(filepath.Join(dir, "ohio.shp"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if info.GeometryType != "Polygon" || info.FeatureCount != 88 || len(info.Fields) != 2 || info.Fields[1] != "FIPS" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.CRS != "GCS_North_American_1983" || info.BBox.MinX != -84.82 {
+		t.Fatalf("unexpected crs/bbox %q %+v", info.CRS, info.BBox)
+	}
+	if !info.CoversArea(BBox{MinX: -83, MinY: 39, MaxX: -82, MaxY: 40}) {
+		t.Fatalf("expected extent to cover central Ohio")
+	}
+}
+
+func TestWKTName(t *testing.T) {
+	wkt := `PROJCS["NAD83 / UTM zone 17N",GEOGCS["NAD83",AUTHORITY["EPSG","4269"]],UNIT["metre",1],AUTHORITY["EPSG","26917"]]`
+	if got := WKTName(wkt); got != "EPSG:26917" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestReadGeoJSONInfo(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "pts.geojson")
+	os.WriteFile(p, []byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[-82.9,40.0]}},
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[-81.6,41.5]}}]}`), 0644)
+	info, err := ReadGeoInfo(p)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want := BBox{MinX: -82.9, MinY: 40.0, MaxX: -81.6, MaxY: 41.5}
+	if info.FeatureCount != 2 || info.GeometryType != "Point" || *info.BBox != want {
+		t.Fatalf("unexpected info %+v %+v", info, info.BBox)
+	}
+}
+
+func TestReadGeoJSONStream(t *testing.T) {
+	dir := t.TempDir()
+	// The type comes last, after features with nested coordinates, a null
+	// geometry and properties that must be skipped.
+	p := filepath.Join(dir, "parcels.json")
+	os.WriteFile(p, []byte(`{"name":"parcels","features":[
+		{"type":"Feature","properties":{"ids":[[1,2],[3]],"note":{"a":[]}},
+		 "geometry":{"type":"Polygon","coordinates":[[[-83,40],[-82,40],[-82,41.5],[-83,40]]]}},
+		{"type":"Feature","geometry":null},
+		{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[-84.5,39],[-84,39],[-84,39.5],[-84.5,39]]]}}
+	],"type":"FeatureCollection"}`), 0644)
+	info, err := ReadGeoInfo(p)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want := BBox{MinX: -84.5, MinY: 39, MaxX: -82, MaxY: 41.5}
+	if info.FeatureCount != 3 || info.GeometryType != "Polygon" || *info.BBox != want {
+		t.Fatalf("unexpected info %+v %+v", info, info.BBox)
+	}
+
+	p = filepath.Join(dir, "bad.geojson")
+	os.WriteFile(p, []byte(`{"type":"FeatureCollection","features":[{"geometry":`), 0644)
+	if _, err := ReadGeoInfo(p); err == nil {
+		t.Fatal("truncated document accepted")
+	}
+
+	p = filepath.Join(dir, "results.json")
+	os.WriteFile(p, []byte(`{"results":[{"id":1}]}`), 0644)
+	if _, err := ReadGeoInfo(p); err != ErrUnsupportedFormat {
+		t.Fatalf("plain JSON: %v", err)
+	}
+}
+
+func TestReadGeoTIFFInfo(t *testing.T) {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	buf.WriteString("II")
+	binary.Write(&buf, le, uint16(42))
+	binary.Write(&buf, le, uint32(8))
+
+	const entries = 5
+	dataOff := uint32(8 + 2 + entries*12 + 4)
+	scaleOff := dataOff
+	tieOff := scaleOff + 3*8
+	keysOff := tieOff + 6*8
+	binary.Write(&buf, le, uint16(entries))
+	entry := func(tag, typ uint16, count, value uint32) {
+		binary.Write(&buf, le, tag)
+		binary.Write(&buf, le, typ)
+		binary.Write(&buf, le, count)
+		binary.Write(&buf, le, value)
+	}
+	entry(256, 3, 1, 100)
+	entry(257, 3, 1, 50)
+	entry(33550, 12, 3, scaleOff)
+	entry(33922, 12, 6, tieOff)
+	entry(34735, 3, 8, keysOff)
+	binary.Write(&buf, le, uint32(0))
+	for _, v := range []float64{30, 30, 0} {
+		binary.Write(&buf, le, v)
+	}
+	for _, v := range []float64{0, 0, 0, 500000, 4500000, 0} {
+		binary.Write(&buf, le, v)
+	}
+	for _, v := range []uint16{1, 1, 0, 1, 3072, 0, 1, 26917} {
+		binary.Write(&buf, le, v)
+	}
+
+	info, err := readGeoTIFF(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want := BBox{MinX: 500000, MinY: 4498500, MaxX: 503000, MaxY: 4500000}
+	if info.Width != 100 || info.Height != 50 || info.CRS != "EPSG:26917" || *info.BBox != want {
+		t.Fatalf("unexpected info %+v %+v", info, info.BBox)
+	}
+}
+
+// TestReadGeoTIFFMalformed reads a classic TIFF whose last entry claims an
+// 8-byte image width, which only fits in a BigTIFF.
+func TestReadGeoTIFFMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	buf.WriteString("II")
+	binary.Write(&buf, le, uint16(42))
+	binary.Write(&buf, le, uint32(8))
+	binary.Write(&buf, le, uint16(1))
+	binary.Write(&buf, le, uint16(256))
+	binary.Write(&buf, le, uint16(16)) // LONG8
+	binary.Write(&buf, le, uint32(1))
+	binary.Write(&buf, le, uint32(100))
+
+	if _, err := readGeoTIFF(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatal("LONG8 in a classic tiff accepted")
+	}
+}
+
+func TestReadKMLInfo(t *testing.T) {
+	kml := `<kml><Document><Placemark><LineString><coordinates>-83.0,40.0,0 -82.0,41.0,0</coordinates></LineString></Placemark></Document></kml>`
+	info, err := ReadKMLInfo(strings.NewReader(kml))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if info.FeatureCount != 1 || info.BBox.MinX != -83 || info.BBox.MaxY != 41 {
+		t.Fatalf("unexpected info %+v %+v", info, info.BBox)
+	}
+}
+
+func TestReadLASInfo(t *testing.T) {
+	hdr := make([]byte, 375)
+	copy(hdr, "LASF")
+	hdr[24], hdr[25] = 1, 4
+	hdr[104] = 6
+	le := binary.LittleEndian
+	for i, v := range []float64{510000, 500000, 4510000, 4500000, 320, 180} {
+		le.PutUint64(hdr[179+i*8:], math.Float64bits(v))
+	}
+	le.PutUint64(hdr[247:], 123456789)
+	p := filepath.Join(t.TempDir(), "tile.las")
+	os.WriteFile(p, hdr, 0644)
+
+	info, err := ReadGeoInfo(p)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if info.FeatureCount != 123456789 || info.BBox.MinX != 500000 || info.BBox.MaxY != 4510000 || info.Attributes["max_z"] != "320" {
+		t.Fatalf("unexpected info %+v %+v", info, info.BBox)
+	}
+}
+
+func TestReadNetCDFInfo(t *testing.T) {
+	var buf bytes.Buffer
+	be := binary.BigEndian
+	u32 := func(v uint32) { binary.Write(&buf, be, v) }
+	name := func(s string) {
+		u32(uint32(len(s)))
+		buf.WriteString(s)
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	buf.WriteString("CDF\x01")
+	u32(0) // numrecs
+	u32(ncDimension)
+	u32(2)
+	name("lat")
+	u32(180)
+	name("lon")
+	u32(360)
+	u32(ncAttribute)
+	u32(1)
+	name("title")
+	u32(2)
+	u32(4)
+	buf.WriteString("SST!")
+	u32(ncVariable)
+	u32(1)
+	name("sst")
+	u32(2)
+	u32(0)
+	u32(1)
+	u32(0) // no variable attributes
+	u32(0)
+	u32(5) // NC_FLOAT
+	u32(180 * 360 * 4)
+	u32(1024) // begin
+
+	info, err := readNetCDF(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if info.Dimensions["lon"] != 360 || info.Attributes["title"] != "SST!" || len(info.Variables) != 1 || info.Variables[0] != "sst(lat,lon)" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	// An attribute count whose size overflows 32 bits, or runs past the end
+	// of the file, is refused.
+	for _, count := range []uint32{1 << 30, 1 << 20} {
+		buf.Reset()
+		buf.WriteString("CDF\x01")
+		u32(0)
+		u32(0) // no dimensions
+		u32(0)
+		u32(ncAttribute)
+		u32(1)
+		name("history")
+		u32(6) // NC_DOUBLE
+		u32(count)
+		if _, err := readNetCDF(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+			t.Errorf("count %d accepted", count)
+		}
+	}
+}
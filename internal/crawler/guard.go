@@ -0,0 +1,131 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// The guard settings, replaced by Config.Apply.
+var (
+	allowPrivate   = activeConfig.AllowPrivate
+	allowedSchemes = activeConfig.AllowedSchemes
+	maxRedirects   = activeConfig.MaxRedirects
+	maxPageBytes   = activeConfig.MaxPageBytes
+)
+
+// ErrUnsafeURL is returned for requests to schemes that are not allowed and
+// to private, loopback, link-local or otherwise internal addresses.
+var ErrUnsafeURL = errors.New("unsafe destination")
+
+// ErrPageTooLarge is returned when a page is larger than http.max_page_bytes.
+var ErrPageTooLarge = errors.New("page too large")
+
+// blockedPrefixes are special-purpose ranges not covered by the netip
+// predicates used in unsafeAddr.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+}
+
+// unsafeAddr reports whether ip is an address the crawler must not connect
+// to, such as 127.0.0.1, 10.0.0.1 or the cloud metadata service at
+// 169.254.169.254.
+func unsafeAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL rejects URLs the crawler must not request: schemes outside
+// http.allowed_schemes, and, unless http.allow_private is set, hosts that
+// are internal addresses or names. Names that resolve to internal addresses
+// are caught when dialing.
+func checkURL(u *url.URL) error {
+	if !slices.Contains(allowedSchemes, strings.ToLower(u.Scheme)) {
+		return fmt.Errorf("%s: scheme %q: %w", u.Redacted(), u.Scheme, ErrUnsafeURL)
+	}
+	if allowPrivate {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip, err := netip.ParseAddr(host); err == nil && unsafeAddr(ip) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%s: %w", u.Redacted(), ErrUnsafeURL)
+	}
+	return nil
+}
+
+// allowedURL reports whether rawURL may be requested, without resolving it.
+func allowedURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && checkURL(u) == nil
+}
+
+// dialControl runs after DNS resolution, on the address actually dialed, so
+// a public name resolving to an internal address is refused as well.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	if allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || unsafeAddr(ip) {
+		return fmt.Errorf("connecting to %s: %w", address, ErrUnsafeURL)
+	}
+	return nil
+}
+
+// checkRedirect applies checkURL to every redirect and stops after
+// http.max_redirects of them.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return checkURL(req.URL)
+}
+
+// limitPage returns a reader of r that fails with ErrPageTooLarge after
+// http.max_page_bytes, so oversized pages are not parsed into memory.
+func limitPage(r io.Reader) io.Reader {
+	return &pageLimitReader{r: r, left: maxPageBytes}
+}
+
+type pageLimitReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *pageLimitReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		return 0, fmt.Errorf("more than %d bytes: %w", maxPageBytes, ErrPageTooLarge)
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,119 @@
+package crawler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The tests crawl httptest servers on the loopback interface.
+func TestMain(m *testing.M) {
+	allowPrivate = true
+	os.Exit(m.Run())
+}
+
+// blockPrivate turns the private address check on for the rest of the test.
+func blockPrivate(t *testing.T) {
+	allowPrivate = false
+	t.Cleanup(func() { allowPrivate = true })
+}
+
+func TestUnsafeAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"::1":              true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"::ffff:127.0.0.1": true,
+		"8.8.8.8":          false,
+		"2607:f8b0::1":     false,
+		"::ffff:8.8.8.8":   false,
+	} {
+		if got := unsafeAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("unsafeAddr(%s) = %v", addr, got)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	blockPrivate(t)
+	for raw, want := range map[string]bool{
+		"https://www.usgs.gov/data":               true,
+		"http://169.254.169.254/latest/meta-data": false,
+		"http://localhost:8080/":                  false,
+		"http://api.localhost/":                   false,
+		"http://[::1]/":                           false,
+		"http://10.0.0.5/a.zip":                   false,
+		"file:///etc/passwd":                      false,
+		"ftp://ftp.example.com/a.zip":             false,
+	} {
+		u, _ := url.Parse(raw)
+		if err := checkURL(u); (err == nil) != want || err != nil && !errors.Is(err, ErrUnsafeURL) {
+			t.Errorf("checkURL(%s) = %v", raw, err)
+		}
+	}
+	if err := dialControl("tcp", "127.0.0.1:80", nil); !errors.Is(err, ErrUnsafeURL) {
+		t.Errorf("dialing loopback: %v", err)
+	}
+	if err := dialControl("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("dialing public address: %v", err)
+	}
+
+	mg := NewManager(Options{})
+	var links []WebNode
+	for _, u := range []string{"http://169.254.169.254/latest/", "file:///etc/passwd", "https://www.usgs.gov/data"} {
+		mg.follow(WebNode{Url: u, Depth: 1}, &links)
+	}
+	if len(links) != 1 {
+		t.Fatalf("followed %v", links)
+	}
+}
+
+func TestRedirectsAreChecked(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Write([]byte("<html>" + strings.Repeat("<p>padding</p>", 100) + "</html>"))
+		}
+	}))
+	defer site.Close()
+
+	// Only the redirect target is internal here, so the check is made
+	// on redirects directly.
+	req, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/", nil)
+	blockPrivate(t)
+	if err := checkRedirect(req, []*http.Request{{}}); !errors.Is(err, ErrUnsafeURL) {
+		t.Fatalf("redirect to metadata service: %v", err)
+	}
+	allowPrivate = true
+
+	old := maxRedirects
+	maxRedirects = 3
+	defer func() { maxRedirects = old }()
+	if _, err := httpGet(site.URL + "/loop"); err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Fatalf("redirect loop: %v", err)
+	}
+
+	oldSize := maxPageBytes
+	maxPageBytes = 256
+	defer func() { maxPageBytes = oldSize }()
+	mg := NewManager(Options{})
+	if _, err := mg.Extract2(&WebNode{Url: site.URL + "/big"}); err == nil || !strings.Contains(err.Error(), ErrPageTooLarge.Error()) {
+		t.Fatalf("oversized page: %v", err)
+	}
+}
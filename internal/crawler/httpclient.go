@@ -0,0 +1,228 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestTimeout bounds a page request, retries and body included; replaced
+// by Config.Apply. Downloads are not bounded, as large files take long.
+var requestTimeout = activeConfig.RequestTimeout
+
+// transportErr is why the transport of the last Config.Apply could not be
+// built as configured, such as an unreadable CA bundle.
+var transportErr error
+
+// fetchClient requests every crawled page and downloaded file, with the
+// credentials of each host and the cookies of earlier responses. Its
+// transport is replaced by Config.Apply.
+var fetchClient = &http.Client{Transport: authTransport{defaultTransport()}, CheckRedirect: checkRedirect, Jar: newCookieJar()}
+
+// setTransport gives fetchClient the transport of c and closes the idle
+// connections of the one it replaces.
+func setTransport(c *Config) error {
+	t, err := newTransport(c)
+	if old, ok := fetchClient.Transport.(authTransport); ok {
+		if old, ok := old.base.(*http.Transport); ok {
+			old.CloseIdleConnections()
+		}
+	}
+	fetchClient.Transport = authTransport{t}
+	return err
+}
+
+func defaultTransport() *http.Transport {
+	t, _ := newTransport(activeConfig)
+	return t
+}
+
+// newTransport returns the transport shared by every request, with the
+// timeouts, proxy, CA bundle, HTTP/2 and connection pool settings of c.
+// Connections are dialed through dialControl, except those to the proxy,
+// which may well be on an internal network; with a proxy, destinations are
+// only checked by name, as the proxy resolves them. If part of c cannot be
+// used, the transport leaves it at its default and the error says why.
+func newTransport(c *Config) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	proxies := &sync.Map{} // addresses of the proxies, which dial directly
+	guarded := &net.Dialer{Timeout: c.ConnectTimeout, KeepAlive: 30 * time.Second, Control: dialControl}
+	direct := &net.Dialer{Timeout: c.ConnectTimeout, KeepAlive: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if _, ok := proxies.Load(addr); ok {
+			return direct.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+	t.TLSHandshakeTimeout = c.ConnectTimeout
+	t.ResponseHeaderTimeout = c.HeaderTimeout
+	t.MaxConnsPerHost = c.MaxConnsPerHost
+	t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetHTTP1(true)
+	t.Protocols.SetHTTP2(c.HTTP2)
+	t.HTTP2 = &http.HTTP2Config{SendPingTimeout: c.HTTP2Ping, PingTimeout: 15 * time.Second}
+
+	var errs []error
+	proxy, err := proxyFunc(c.Proxy)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if proxy != nil {
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			u, err := proxy(req)
+			if u != nil {
+				proxies.Store(proxyAddr(u), true)
+			}
+			return u, err
+		}
+	} else {
+		t.Proxy = nil
+	}
+	if c.CABundle != "" {
+		pool, err := loadCABundle(c.CABundle)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			t.TLSClientConfig = &tls.Config{RootCAs: pool}
+		}
+	}
+	return t, errors.Join(errs...)
+}
+
+// proxyFunc returns the proxy selection of http.proxy: nil for none, the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables for "env", or else every
+// request through the proxy at that URL.
+func proxyFunc(v string) (func(*http.Request) (*url.URL, error), error) {
+	switch v {
+	case "":
+		return nil, nil
+	case "env":
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := parseProxy(v)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyURL(u), nil
+}
+
+// parseProxy parses the URL of a proxy, which must be http, https or
+// socks5.
+func parseProxy(v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("proxy %q is not a URL such as http://proxy.example.gov:3128", v)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("proxy %q: scheme %q is not http, https or socks5", v, u.Scheme)
+}
+
+// proxyAddr returns the address the transport dials for proxy u.
+func proxyAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[u.Scheme]
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// loadCABundle returns the system roots with the PEM certificates of path
+// added, for networks whose TLS is intercepted by an agency's own CA.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s: no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// setProxy validates the value of http.proxy.
+func setProxy(dst *string, v string) error {
+	if v != "" && v != "env" {
+		if _, err := parseProxy(v); err != nil {
+			return err
+		}
+	}
+	*dst = v
+	return nil
+}
+
+// userAgent returns ua with the contact address, if there is one, in the
+// form crawler operators expect, e.g. "godl/0.1 (+mailto:gis@example.gov)".
+func userAgent(ua, contact string) string {
+	if contact == "" {
+		return ua
+	}
+	if strings.Contains(contact, "@") && !strings.Contains(contact, ":") {
+		contact = "mailto:" + contact
+	}
+	return fmt.Sprintf("%s (+%s)", ua, contact)
+}
+
+// errPageTimeout ends a page request that took longer than http.timeout.
+var errPageTimeout = fmt.Errorf("page took longer than http.timeout: %w", context.DeadlineExceeded)
+
+// pageDeadline cancels a page request, body included, after requestTimeout.
+type pageDeadline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+	kept   bool
+}
+
+func newPageDeadline() *pageDeadline {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	d := &pageDeadline{ctx: ctx, cancel: cancel}
+	if requestTimeout > 0 {
+		d.timer = time.AfterFunc(requestTimeout, func() { cancel(errPageTimeout) })
+	}
+	return d
+}
+
+// err returns err, or errPageTimeout with err if the deadline passed, as the
+// client and the parser only see the cancellation.
+func (d *pageDeadline) err(err error) error {
+	if err != nil && errors.Is(context.Cause(d.ctx), errPageTimeout) {
+		return fmt.Errorf("%w (%v)", errPageTimeout, err)
+	}
+	return err
+}
+
+// keep lifts the deadline of a response that turned out to be a file, whose
+// body is downloaded rather than parsed.
+func (d *pageDeadline) keep() {
+	d.kept = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// close ends the request, unless it was kept.
+func (d *pageDeadline) close() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	if !d.kept {
+		d.cancel(nil)
+	}
+}
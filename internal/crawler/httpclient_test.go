@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTransport(t *testing.T) {
+	c := DefaultConfig()
+	for k, v := range map[string]string{
+		"http.connect_timeout":    "3s",
+		"http.header_timeout":     "7s",
+		"http.http2":              "false",
+		"http.max_conns_per_host": "5",
+		"http.max_idle_per_host":  "2",
+	} {
+		if err := c.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr, err := newTransport(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.TLSHandshakeTimeout != 3*time.Second || tr.ResponseHeaderTimeout != 7*time.Second ||
+		tr.MaxConnsPerHost != 5 || tr.MaxIdleConnsPerHost != 2 || tr.Proxy != nil {
+		t.Fatalf("transport %+v", tr)
+	}
+	if tr.Protocols.HTTP2() || !tr.Protocols.HTTP1() {
+		t.Fatalf("protocols %v", tr.Protocols)
+	}
+
+	for _, v := range []string{"ftp://proxy:21", "proxy.example.gov", "http://"} {
+		if err := c.Set("http.proxy", v); err == nil {
+			t.Errorf("proxy %q accepted", v)
+		}
+	}
+	c.CABundle = filepath.Join(t.TempDir(), "none.pem")
+	if _, err := newTransport(c); err == nil {
+		t.Fatal("missing CA bundle accepted")
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	c := DefaultConfig()
+	tr, _ := newTransport(c)
+	if _, err := (&http.Client{Transport: tr}).Get(srv.URL); errorClass(err) != ClassTLS {
+		t.Fatalf("untrusted certificate: %v", err)
+	}
+
+	c.CABundle = filepath.Join(t.TempDir(), "agency.pem")
+	os.WriteFile(c.CABundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644)
+	tr, err := newTransport(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+// TestProxy sends a request through a proxy on the loopback interface, which
+// the address check must let through although it refuses the destination.
+func TestProxy(t *testing.T) {
+	var got string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.String()
+		fmt.Fprint(w, "ok")
+	}))
+	defer proxy.Close()
+	blockPrivate(t)
+
+	c := DefaultConfig()
+	if err := c.Set("http.proxy", proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := newTransport(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: tr}).Get("http://data.example.gov/x.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "http://data.example.gov/x.zip" {
+		t.Fatalf("proxy got %q", got)
+	}
+
+	tr, _ = newTransport(DefaultConfig())
+	if _, err := (&http.Client{Transport: tr}).Get(proxy.URL); !errors.Is(err, ErrUnsafeURL) {
+		t.Fatalf("direct request to loopback: %v", err)
+	}
+}
+
+func TestContactUserAgent(t *testing.T) {
+	c := DefaultConfig()
+	c.Set("hosts.example.com.user_agent", "agency-bot/2")
+	for contact, want := range map[string]string{
+		"":                          "godl/0.1",
+		"gis@example.gov":           "godl/0.1 (+mailto:gis@example.gov)",
+		"https://example.gov/crawl": "godl/0.1 (+https://example.gov/crawl)",
+	} {
+		c.Contact = contact
+		if got := c.Host("data.gov").UserAgent; got != want {
+			t.Errorf("contact %q: %q, want %q", contact, got, want)
+		}
+	}
+	c.Contact = "https://example.gov/crawl"
+	if got := c.Host("example.com").UserAgent; got != "agency-bot/2 (+https://example.gov/crawl)" {
+		t.Errorf("host override: %q", got)
+	}
+}
+
+// TestPageTimeout crawls a page that sends its headers and then stalls.
+func TestPageTimeout(t *testing.T) {
+	useRetries(t, 0, time.Millisecond)
+	old := requestTimeout
+	requestTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { requestTimeout = old })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	mg := NewManager(Options{})
+	mg.pages = NewPageCache()
+	start := time.Now()
+	_, err := mg.Extract2(&WebNode{Url: srv.URL + "/slow", Depth: 1})
+	if !errors.Is(err, errPageTimeout) || errorClass(err) != ClassTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("took %v", d)
+	}
+	if !strings.Contains(err.Error(), "http.timeout") {
+		t.Errorf("err %q", err)
+	}
+}
@@ -0,0 +1,266 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The download layout settings, replaced by Config.Apply.
+var (
+	downloadLayout = activeConfig.Layout
+	overwriteFiles = activeConfig.Overwrite
+)
+
+// partPattern names the temporary files downloads are written to before
+// placeFile gives them their name.
+const partPattern = ".godl-*.part"
+
+// layoutPresets are the named layouts download.layout accepts besides
+// templates.
+var layoutPresets = map[string]string{
+	"flat":   "{name}",
+	"host":   "{host}/{path}/{name}",
+	"source": "{source}/{dataset}/{name}",
+}
+
+// layoutField matches the placeholders of a layout template.
+var layoutField = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// layoutFields are the placeholders a layout template may use:
+//
+//	{host}     host of the file's URL
+//	{path}     directories of the file's URL path
+//	{name}     file name, from Content-Disposition or the URL
+//	{dataset}  file name without its extension
+//	{source}   host of the seed the file was found from
+var layoutFields = map[string]bool{"host": true, "path": true, "name": true, "dataset": true, "source": true}
+
+func setLayout(dst *string, v string) error {
+	tmpl := v
+	if p, ok := layoutPresets[v]; ok {
+		tmpl = p
+	}
+	for _, m := range layoutField.FindAllStringSubmatch(tmpl, -1) {
+		if !layoutFields[m[1]] {
+			return fmt.Errorf("unknown layout field %s", m[0])
+		}
+	}
+	if !strings.Contains(tmpl, "{name}") {
+		return fmt.Errorf("layout %q has no {name}", v)
+	}
+	*dst = v
+	return nil
+}
+
+// layoutName returns the path, relative to the download directory, that the
+// download of node answered by resp is saved under.
+func layoutName(node *WebNode, resp *http.Response) string {
+	tmpl := downloadLayout
+	if p, ok := layoutPresets[tmpl]; ok {
+		tmpl = p
+	}
+	u, err := url.Parse(node.Url)
+	if err != nil {
+		u = &url.URL{}
+	}
+	name := dispositionName(resp.Header)
+	if name == "" {
+		name = cleanSegment(strings.Trim(path.Base(u.Path), "/"))
+	}
+	if name == "" && resp.Request != nil {
+		name = cleanSegment(strings.Trim(path.Base(resp.Request.URL.Path), "/"))
+	}
+	if name == "" {
+		name = "download"
+	}
+	var dirs []string
+	for _, s := range strings.Split(path.Dir(u.Path), "/") {
+		if s = cleanSegment(s); s != "" {
+			dirs = append(dirs, s)
+		}
+	}
+	source := u.Hostname()
+	if seed, err := url.Parse(seedOf(node).Url); err == nil && seed.Hostname() != "" {
+		source = seed.Hostname()
+	}
+
+	values := map[string]string{
+		"host":    cleanSegment(u.Hostname()),
+		"path":    strings.Join(dirs, "/"),
+		"name":    name,
+		"dataset": strings.TrimSuffix(name, archiveExt(name)),
+		"source":  cleanSegment(source),
+	}
+	expanded := layoutField.ReplaceAllStringFunc(tmpl, func(f string) string {
+		return values[f[1:len(f)-1]]
+	})
+	// Empty fields and anything that would climb out of the download
+	// directory are dropped.
+	var parts []string
+	for _, s := range strings.Split(expanded, "/") {
+		if s = cleanSegment(s); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 {
+		return name
+	}
+	return filepath.Join(parts...)
+}
+
+// dispositionName returns the file name suggested by a Content-Disposition
+// header, without any directories, or "" if there is none.
+func dispositionName(h http.Header) string {
+	_, params, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	name := params["filename"]
+	return cleanSegment(name[strings.LastIndexAny(name, `/\`)+1:])
+}
+
+// cleanSegment makes s safe as one element of a path: separators and
+// control characters are replaced, and "." and ".." become empty.
+func cleanSegment(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "." || s == ".." {
+		return ""
+	}
+	if len(s) > 200 {
+		ext := archiveExt(s)
+		if len(ext) > 20 {
+			ext = ""
+		}
+		s = strings.ToValidUTF8(s[:200-len(ext)], "") + ext
+	}
+	return s
+}
+
+// archiveExt returns the extension of name, including a .tar before a
+// compression suffix.
+func archiveExt(name string) string {
+	ext := path.Ext(name)
+	if inner := path.Ext(strings.TrimSuffix(name, ext)); strings.EqualFold(inner, ".tar") {
+		return inner + ext
+	}
+	return ext
+}
+
+// placeFile moves the finished download saved.Path to dest. An existing
+// file is only replaced when overwriteFiles is set: if it has the same
+// content the download is dropped and the existing file reported as a
+// duplicate, otherwise the download gets the first free name of dest-1,
+// dest-2 and so on.
+func placeFile(saved savedFile, dest string) (savedFile, error) {
+	tmp := saved.Path
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		os.Remove(tmp)
+		return savedFile{}, err
+	}
+	if overwriteFiles {
+		if err := os.Rename(tmp, dest); err != nil {
+			os.Remove(tmp)
+			return savedFile{}, err
+		}
+		saved.Path = dest
+		return saved, nil
+	}
+
+	ext := archiveExt(dest)
+	stem := strings.TrimSuffix(dest, ext)
+	for i := 0; ; i++ {
+		p := dest
+		if i > 0 {
+			p = stem + "-" + strconv.Itoa(i) + ext
+		}
+		err := claim(tmp, p)
+		if err == nil {
+			saved.Path = p
+			return saved, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			os.Remove(tmp)
+			return savedFile{}, err
+		}
+		if sameContent(p, saved) {
+			os.Remove(tmp)
+			saved.Path, saved.Duplicate = p, true
+			return saved, nil
+		}
+	}
+}
+
+// claim renames tmp to p unless p exists. Hard links make the check and the
+// rename one step; on file systems without them the name is checked first.
+func claim(tmp, p string) error {
+	err := os.Link(tmp, p)
+	if err == nil {
+		os.Remove(tmp)
+		return nil
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if _, err := os.Lstat(p); err == nil {
+		return fs.ErrExist
+	}
+	return os.Rename(tmp, p)
+}
+
+// sameContent reports whether the file at p is the one described by saved.
+func sameContent(p string, saved savedFile) bool {
+	st, err := os.Stat(p)
+	if err != nil || !st.Mode().IsRegular() || st.Size() != saved.Size {
+		return false
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == saved.SHA256
+}
+
+// place gives a finished download its name below dir. Content already saved
+// in this session is not stored twice: the download is dropped and the
+// earlier file reported as a duplicate.
+func (m *Manager) place(saved savedFile, dir, name string) (savedFile, error) {
+	m.placeMu.Lock()
+	defer m.placeMu.Unlock()
+	if p, ok := m.placed[saved.SHA256]; ok && sameContent(p, saved) {
+		os.Remove(saved.Path)
+		saved.Path, saved.Duplicate = p, true
+		return saved, nil
+	}
+	saved, err := placeFile(saved, filepath.Join(dir, name))
+	if err != nil {
+		return saved, err
+	}
+	if m.placed == nil {
+		m.placed = make(map[string]string)
+	}
+	m.placed[saved.SHA256] = saved.Path
+	return saved, nil
+}
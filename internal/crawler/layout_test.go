@@ -0,0 +1,133 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLayoutName(t *testing.T) {
+	defer func() { downloadLayout = activeConfig.Layout }()
+	seed := &WebNode{Url: "https://www.usgs.gov/"}
+	node := &WebNode{Url: "https://prd-tnm.s3.amazonaws.com/StagedProducts/Elevation/dem.tif?x=1", Parent: seed}
+	for _, tc := range []struct {
+		layout, disposition string
+		node                *WebNode
+		want                string
+	}{
+		{"flat", "", node, "dem.tif"},
+		{"host", "", node, "prd-tnm.s3.amazonaws.com/StagedProducts/Elevation/dem.tif"},
+		{"source", "", node, "www.usgs.gov/dem/dem.tif"},
+		{"{source}/{name}", `attachment; filename="../../etc/ohio dem.tif"`, node, "www.usgs.gov/ohio dem.tif"},
+		{"flat", `attachment; filename*=UTF-8''%C3%A9l%C3%A9vation.tar.gz`, node, "élévation.tar.gz"},
+		{"source", `attachment; filename="roads.tar.gz"`, node, "www.usgs.gov/roads/roads.tar.gz"},
+		{"flat", "", &WebNode{Url: "https://example.com/"}, "download"},
+		{"host", "", &WebNode{Url: "https://example.com/a/../../b/x.zip"}, "example.com/b/x.zip"},
+		{"{path}/{name}", "", &WebNode{Url: "https://example.com/x.zip"}, "x.zip"},
+	} {
+		downloadLayout = tc.layout
+		resp := &http.Response{Header: http.Header{}}
+		if tc.disposition != "" {
+			resp.Header.Set("Content-Disposition", tc.disposition)
+		}
+		if got := layoutName(tc.node, resp); got != filepath.FromSlash(tc.want) {
+			t.Errorf("%s %s %q: %q, want %q", tc.layout, tc.node.Url, tc.disposition, got, tc.want)
+		}
+	}
+
+	var v string
+	for _, bad := range []string{"{host}/{bogus}/{name}", "{host}/{path}"} {
+		if err := setLayout(&v, bad); err == nil {
+			t.Errorf("layout %q accepted", bad)
+		}
+	}
+	if err := setLayout(&v, "{source}/{host}/{name}"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlaceFile(t *testing.T) {
+	dir := t.TempDir()
+	save := func(content string) savedFile {
+		saved, err := saveResponse(sandboxResponse(content, -1), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saved
+	}
+	dest := filepath.Join(dir, "sub", "roads.tar.gz")
+	for i, tc := range []struct {
+		content, want string
+		dup           bool
+	}{
+		{"one", "sub/roads.tar.gz", false},
+		{"two", "sub/roads-1.tar.gz", false},
+		{"one", "sub/roads.tar.gz", true},
+		{"two", "sub/roads-1.tar.gz", true},
+		{"three", "sub/roads-2.tar.gz", false},
+	} {
+		saved, err := placeFile(save(tc.content), dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Path != filepath.Join(dir, tc.want) || saved.Duplicate != tc.dup {
+			t.Errorf("%d: %+v, want %s", i, saved, tc.want)
+		}
+		if data, _ := os.ReadFile(saved.Path); string(data) != tc.content {
+			t.Errorf("%d: %s holds %q", i, saved.Path, data)
+		}
+	}
+
+	overwriteFiles = true
+	defer func() { overwriteFiles = activeConfig.Overwrite }()
+	if saved, err := placeFile(save("four"), dest); err != nil || saved.Path != dest {
+		t.Fatal(saved, err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "four" {
+		t.Fatalf("not overwritten: %q", data)
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dir, ".godl-*")); len(parts) != 0 {
+		t.Fatalf("temporary files left: %v", parts)
+	}
+}
+
+func TestDownloadLayout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a/data.zip", "/mirror/copy.zip":
+			w.Write([]byte("PK\x03\x04 first"))
+		case "/b/data.zip":
+			w.Write([]byte("PK\x03\x04 second"))
+		case "/export":
+			w.Header().Set("Content-Disposition", `attachment; filename="parcels.zip"`)
+			w.Write([]byte("PK\x03\x04 third"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	mg := NewManager(Options{Query: "q", DownloadDir: dir})
+	for _, p := range []string{"/a/data.zip", "/b/data.zip", "/mirror/copy.zip", "/export"} {
+		if err := mg.DownloadURL(&WebNode{Url: srv.URL + p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{
+		"/a/data.zip":      "data.zip",
+		"/b/data.zip":      "data-1.zip",
+		"/mirror/copy.zip": "data.zip",
+		"/export":          "parcels.zip",
+	}
+	for _, e := range mg.FinishManifest(nil).Entries {
+		p := strings.TrimPrefix(e.SourceURL, srv.URL)
+		if e.Error != "" || e.LocalPath != filepath.Join(dir, want[p]) || e.Deduplicated != (p == "/mirror/copy.zip") {
+			t.Errorf("%s: saved at %s, deduplicated %v, error %q", p, e.LocalPath, e.Deduplicated, e.Error)
+		}
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 3 {
+		t.Fatalf("%d files in the download directory", len(files))
+	}
+}
@@ -0,0 +1,280 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Manifest is the machine-readable record of one search/download session. It
+// lists every candidate that was found and every file that was saved, with
+// enough provenance to reproduce or audit the run.
+type Manifest struct {
+	Session     string          `json:"session"`
+	Query       string          `json:"query"`
+	Args        []string        `json:"args,omitempty"`
+	DownloadDir string          `json:"download_dir,omitempty"`
+	StartedAt   time.Time       `json:"started_at"`
+	FinishedAt  time.Time       `json:"finished_at"`
+	Entries     []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry describes a single discovered or downloaded resource.
+type ManifestEntry struct {
+	Query     string   `json:"query"`
+	Seed      string   `json:"seed"`
+	CrawlPath []string `json:"crawl_path"` // seed first, resource last
+	SourceURL string   `json:"source_url"`
+	LocalPath string   `json:"local_path,omitempty"`
+	Size      int64    `json:"size,omitempty"`
+	SHA256    string   `json:"sha256,omitempty"`
+	// Deduplicated is set when the content was already on disk, at
+	// LocalPath, and the download was not stored again.
+	Deduplicated bool                `json:"deduplicated,omitempty"`
+	Format       string              `json:"format,omitempty"` // format sniffed from the first bytes
+	Headers      map[string]string   `json:"headers,omitempty"`
+	FetchedAt    *time.Time          `json:"fetched_at,omitempty"`
+	Metadata     json.RawMessage     `json:"metadata,omitempty"`
+	Archive      *ArchiveReport      `json:"archive,omitempty"`
+	Geo          map[string]*GeoInfo `json:"geo,omitempty"` // header metadata keyed by local file
+	Error        string              `json:"error,omitempty"`
+}
+
+// manifestSkipHeaders lists response headers that are never copied into a
+// manifest because they carry session state rather than provenance.
+var manifestSkipHeaders = map[string]bool{
+	"Set-Cookie": true,
+}
+
+// CrawlPath follows the Parent chain of node and returns the URLs from the
+// seed down to node itself.
+func CrawlPath(node *WebNode) []string {
+	var path []string
+	for n := node; n != nil; n = n.Parent {
+		path = append(path, n.Url)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// NewManifestEntry builds the provenance part of an entry for node. Download
+// details are filled in by the caller once the file is saved.
+func NewManifestEntry(query string, node *WebNode) ManifestEntry {
+	path := CrawlPath(node)
+	entry := ManifestEntry{
+		Query:     query,
+		CrawlPath: path,
+		SourceURL: node.Url,
+	}
+	if len(path) > 0 {
+		entry.Seed = path[0]
+	}
+	if desc := strings.TrimSpace(node.context.Description); desc != "" {
+		if json.Valid([]byte(desc)) {
+			entry.Metadata = json.RawMessage(desc)
+		} else {
+			entry.Metadata, _ = json.Marshal(desc)
+		}
+	}
+	return entry
+}
+
+// AppendDescription adds text to the description of node. Descriptions that
+// hold extracted page metadata keep their JSON shape; text is appended to the
+// description field.
+func AppendDescription(node *WebNode, text string) {
+	desc := strings.TrimSpace(node.context.Description)
+	var md downloadMetadata
+	if desc == "" || json.Unmarshal([]byte(desc), &md) != nil {
+		if desc != "" {
+			desc += " "
+		}
+		node.context.Description = desc + text
+		return
+	}
+	if md.Description != "" {
+		md.Description += " "
+	}
+	md.Description += text
+	out, _ := json.Marshal(md)
+	node.context.Description = string(out)
+}
+
+// Extent returns the union of the lon/lat extents of all files read for the
+// entry.
+func (e *ManifestEntry) Extent() (BBox, bool) {
+	box := emptyBBox()
+	for _, g := range e.Geo {
+		if b, ok := g.LonLatBBox(); ok {
+			box.extend(b.MinX, b.MinY)
+			box.extend(b.MaxX, b.MaxY)
+		}
+	}
+	return box, box.valid()
+}
+
+// manifestHeaders flattens response headers into a single-valued map.
+func manifestHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if manifestSkipHeaders[k] {
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// hashingWriter wraps a writer and computes the SHA-256 and size of everything
+// written through it.
+type hashingWriter struct {
+	w    io.Writer
+	size int64
+	sum  hash.Hash
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, sum: sha256.New()}
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.size += int64(n)
+	h.sum.Write(p[:n])
+	return n, err
+}
+
+// Hex returns the hex-encoded SHA-256 of the bytes written so far.
+func (h *hashingWriter) Hex() string {
+	return hex.EncodeToString(h.sum.Sum(nil))
+}
+
+// addManifestEntry appends entry to the session manifest.
+func (m *Manager) addManifestEntry(entry ManifestEntry) {
+	m.manifestMu.Lock()
+	defer m.manifestMu.Unlock()
+	if m.manifest == nil {
+		m.manifest = &Manifest{}
+	}
+	m.manifest.Entries = append(m.manifest.Entries, entry)
+}
+
+// FinishManifest waits for pending downloads, adds an entry for every
+// candidate that was discovered but not downloaded, and returns the manifest
+// sorted by source URL, with secrets redacted.
+func (m *Manager) FinishManifest(candidates []WebNode) *Manifest {
+	m.downloads.Wait()
+
+	m.manifestMu.Lock()
+	defer m.manifestMu.Unlock()
+	if m.manifest == nil {
+		m.manifest = &Manifest{}
+	}
+	recorded := make(map[string]bool, len(m.manifest.Entries))
+	for _, e := range m.manifest.Entries {
+		recorded[e.SourceURL] = true
+	}
+	for i := range candidates {
+		if recorded[candidates[i].Url] {
+			continue
+		}
+		recorded[candidates[i].Url] = true
+		m.manifest.Entries = append(m.manifest.Entries, NewManifestEntry(m.manifest.Query, &candidates[i]))
+	}
+	for i := range m.manifest.Entries {
+		m.manifest.Entries[i].redact()
+	}
+	for i := range m.manifest.Args {
+		m.manifest.Args[i] = redact(m.manifest.Args[i])
+	}
+	sort.SliceStable(m.manifest.Entries, func(i, j int) bool {
+		return m.manifest.Entries[i].SourceURL < m.manifest.Entries[j].SourceURL
+	})
+	m.manifest.FinishedAt = time.Now().UTC()
+	return m.manifest
+}
+
+// WriteManifest writes the manifest as indented JSON to jsonPath. When csvPath
+// is not empty a flat CSV with one row per entry is written as well.
+func WriteManifest(mf *Manifest, jsonPath, csvPath string) error {
+	if err := os.MkdirAll(filepath.Dir(jsonPath), 0755); err != nil {
+		return fmt.Errorf("creating manifest directory: %w", err)
+	}
+	data, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	if err := os.WriteFile(jsonPath, data, 0644); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	if csvPath == "" {
+		return nil
+	}
+
+	file, err := os.Create(csvPath)
+	if err != nil {
+		return fmt.Errorf("creating manifest csv: %w", err)
+	}
+	w := csv.NewWriter(file)
+	rows := [][]string{{"session", "query", "seed", "crawl_path", "source_url", "local_path", "size", "sha256", "content_type", "fetched_at", "error"}}
+	for _, e := range mf.Entries {
+		var fetched string
+		if e.FetchedAt != nil {
+			fetched = e.FetchedAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			mf.Session,
+			e.Query,
+			e.Seed,
+			strings.Join(e.CrawlPath, " > "),
+			e.SourceURL,
+			e.LocalPath,
+			strconv.FormatInt(e.Size, 10),
+			e.SHA256,
+			e.Headers["Content-Type"],
+			fetched,
+			e.Error,
+		})
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			file.Close()
+			return fmt.Errorf("writing manifest csv: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return fmt.Errorf("writing manifest csv: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writing manifest csv: %w", err)
+	}
+	return nil
+}
+
+// ReadManifest loads a manifest previously written by WriteManifest.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mf Manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("decoding manifest %s: %w", path, err)
+	}
+	return &mf, nil
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCrawlPath(t *testing.T) {
+	seed := &WebNode{Url: "seed"}
+	page := &WebNode{Url: "page", Parent: seed, Depth: 1}
+	file := &WebNode{Url: "file.zip", Parent: page, Depth: 2}
+	got := CrawlPath(file)
+	want := []string{"seed", "page", "file.zip"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestExtract2_DownloadRecordedInManifest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte("zipdata"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	query := "counties"
+	mg := setupManager()
+	mg.downloadPath = &dir
+	mg.searchQuery = &query
+	seed := &WebNode{Url: "https://example.com/"}
+	node := &WebNode{Url: ts.URL + "/counties.zip", Parent: seed, Depth: 1}
+	if _, err := mg.Extract2(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mf := mg.FinishManifest(nil)
+	if len(mf.Entries) != 1 {
+		t.Fatalf("expected 1 manifest entry, got %d", len(mf.Entries))
+	}
+	e := mf.Entries[0]
+	if e.Seed != seed.Url || len(e.CrawlPath) != 2 {
+		t.Fatalf("unexpected provenance %+v", e)
+	}
+	if e.Size != 7 || e.SHA256 == "" || e.LocalPath != filepath.Join(dir, "counties.zip") {
+		t.Fatalf("unexpected download details %+v", e)
+	}
+	if e.Headers["Content-Type"] != "application/zip" {
+		t.Fatalf("content type not recorded: %v", e.Headers)
+	}
+	if _, ok := e.Headers["Set-Cookie"]; ok {
+		t.Fatalf("Set-Cookie must not be recorded")
+	}
+
+	jsonPath := filepath.Join(dir, "manifest.json")
+	csvPath := filepath.Join(dir, "manifest.csv")
+	if err := WriteManifest(mf, jsonPath, csvPath); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	back, err := ReadManifest(jsonPath)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if back.Entries[0].SHA256 != e.SHA256 {
+		t.Fatalf("checksum did not round-trip")
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatalf("open csv: %v", err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	if len(rows) != 2 || rows[1][4] != node.Url {
+		t.Fatalf("unexpected csv rows %v", rows)
+	}
+
+	// A full disk fails the write instead of leaving a truncated CSV.
+	if _, err := os.Stat("/dev/full"); err == nil {
+		if err := WriteManifest(mf, jsonPath, "/dev/full"); err == nil {
+			t.Fatal("writing to a full disk succeeded")
+		}
+	}
+}
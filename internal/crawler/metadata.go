@@ -178,7 +178,7 @@ func ExtractMetadata(doc *html.Node, pageURL, downloadURL string) string {
 	walk(doc)
 
 	// Secondary XML harvest (RSS/Atom) – single client with timeout.
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: fetchClient.Transport, CheckRedirect: checkRedirect}
 	base, _ := url.Parse(pageURL)
 	for _, l := range xmlLinks {
 		u, err := base.Parse(l)
@@ -186,12 +186,16 @@ func ExtractMetadata(doc *html.Node, pageURL, downloadURL string) string {
 			continue
 		}
 		_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if checkURL(u) != nil {
+			cancel()
+			continue
+		}
 		resp, err := client.Get(u.String())
 		if err != nil {
 			cancel()
 			continue
 		}
-		data, err := io.ReadAll(resp.Body)
+		data, err := io.ReadAll(limitPage(resp.Body))
 		resp.Body.Close()
 		cancel()
 		if err != nil {
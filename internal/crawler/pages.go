@@ -0,0 +1,192 @@
+package crawler
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recrawlAge is how old a cached page must be before godl recrawl fetches it
+// again. It is replaced by Config.Apply.
+var recrawlAge = activeConfig.RecrawlAge
+
+// pagesPath returns the path of the page cache stored alongside the
+// embedding cache.
+func pagesPath() string {
+	return strings.TrimSuffix(dataPath, filepath.Ext(dataPath)) + ".pages"
+}
+
+// PageLink is a link extracted from a crawled page. Description holds the
+// metadata of links to geospatial files and is empty for other pages.
+type PageLink struct {
+	URL         string
+	Description string
+}
+
+// PageEntry is what the page cache remembers about one crawled page: the
+// validators of the last response and the links it contained.
+type PageEntry struct {
+	ETag         string
+	LastModified string
+	Fetched      time.Time // when the page was last fetched or revalidated
+	Fingerprint  uint64    // SimHash of the page text; 0 if too short
+	Links        []PageLink
+}
+
+// PageCache maps page URLs to their validators and extracted links, so later
+// crawls can send conditional requests and reuse the links on 304 Not
+// Modified. It is safe for concurrent use.
+type PageCache struct {
+	mu      sync.Mutex
+	entries map[string]PageEntry
+}
+
+// NewPageCache returns an empty page cache.
+func NewPageCache() *PageCache {
+	return &PageCache{entries: make(map[string]PageEntry)}
+}
+
+// LoadPageCache reads a page cache written by Save.
+func LoadPageCache(p string) (*PageCache, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	pc := NewPageCache()
+	if err := gob.NewDecoder(file).Decode(&pc.entries); err != nil {
+		return nil, fmt.Errorf("decoding page cache %s: %w", p, err)
+	}
+	return pc, nil
+}
+
+// openPageCache loads the page cache stored alongside the embedding cache,
+// or starts an empty one.
+func openPageCache() *PageCache {
+	pc, err := LoadPageCache(pagesPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("starting with an empty page cache: %v", err)
+		}
+		return NewPageCache()
+	}
+	return pc
+}
+
+// Save writes the page cache to p.
+func (pc *PageCache) Save(p string) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return WriteToGob(p, pc.entries)
+}
+
+// Len returns the number of cached pages.
+func (pc *PageCache) Len() int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return len(pc.entries)
+}
+
+// Get returns the entry for rawURL.
+func (pc *PageCache) Get(rawURL string) (PageEntry, bool) {
+	if pc == nil {
+		return PageEntry{}, false
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e, ok := pc.entries[rawURL]
+	return e, ok
+}
+
+// Put stores the entry for rawURL.
+func (pc *PageCache) Put(rawURL string, e PageEntry) {
+	if pc == nil {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.entries[rawURL] = e
+}
+
+// Touch records that rawURL was revalidated at t.
+func (pc *PageCache) Touch(rawURL string, t time.Time) {
+	if pc == nil {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if e, ok := pc.entries[rawURL]; ok {
+		e.Fetched = t
+		pc.entries[rawURL] = e
+	}
+}
+
+// replayPage returns the stored links of a page as if it had been fetched.
+func (m *Manager) replayPage(node *WebNode, e PageEntry) []WebNode {
+	m.emitReused()
+	found := make([]WebNode, len(e.Links))
+	for i, l := range e.Links {
+		found[i] = WebNode{Url: l.URL, Parent: node, Depth: node.Depth + 1, context: DataContext{Description: l.Description}}
+	}
+	return m.followPage(found, e.Fingerprint)
+}
+
+// followPage follows the links found on a page and returns the pages to
+// crawl next. Mirrors and duplicate listings are only crawled further once,
+// but the datasets they link to are still candidates: listings that differ
+// only in their file rows have nearly the same fingerprint.
+func (m *Manager) followPage(found []WebNode, fingerprint uint64) []WebNode {
+	var links []WebNode
+	for _, l := range found {
+		m.follow(l, &links)
+	}
+	if m.duplicatePage(fingerprint) {
+		m.emitDuplicate()
+		return nil
+	}
+	return links
+}
+
+// follow records a link found on a page, in canonical form: links to
+// geospatial files, which carry metadata, are candidates; other http(s)
+// links are pages to crawl next if they are in the scope of their seed.
+// Links beyond maxDepth and to unsafe destinations are dropped.
+func (m *Manager) follow(l WebNode, links *[]WebNode) {
+	if l.Depth >= maxDepth {
+		return
+	}
+	l.Url = CanonicalURL(l.Url)
+	if !allowedURL(l.Url) {
+		return
+	}
+	if l.context.Description != "" {
+		m.addCandidate(l)
+	} else if (strings.HasPrefix(l.Url, "http://") || strings.HasPrefix(l.Url, "https://")) && m.inScope(&l) {
+		*links = append(*links, l)
+	}
+}
+
+// Recrawl crawls from every seed in the cache to refresh the catalog without
+// a query. Pages fetched within maxAge are not fetched again and their stored
+// links are followed instead; older pages are revalidated with conditional
+// requests. It returns every candidate found.
+func (m *Manager) Recrawl(ctx context.Context, maxAge time.Duration) []WebNode {
+	m.recrawlAge = maxAge
+	var seeds []WebNode
+	for u, c := range m.CachedURLEmbeddings {
+		if isSeed(u, c) {
+			seeds = append(seeds, WebNode{Url: u, context: c})
+		}
+	}
+	sort.Slice(seeds, func(i, j int) bool { return seeds[i].Url < seeds[j].Url })
+	log.Printf("recrawling %d seeds, reusing pages fetched within %s", len(seeds), maxAge)
+	m.crawl(ctx, seeds)
+	return m.Found()
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCrawlReusesUnchangedPages(t *testing.T) {
+	var mu sync.Mutex
+	status := map[int]int{}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages := map[string]string{
+			"/":    `<a href="/sub">more</a><a href="/a.zip">a</a>`,
+			"/sub": `<a href="/b.zip">b</a>`,
+		}
+		body, ok := pages[r.URL.Path]
+		code := http.StatusOK
+		switch {
+		case !ok:
+			code = http.StatusNotFound
+		case r.URL.Path == "/" && r.Header.Get("If-None-Match") == `"v1"`,
+			r.URL.Path == "/sub" && r.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT":
+			code = http.StatusNotModified
+		}
+		mu.Lock()
+		status[code]++
+		mu.Unlock()
+		if r.URL.Path == "/" {
+			w.Header().Set("ETag", `"v1"`)
+		} else {
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			w.Write([]byte(body))
+		}
+	}))
+	defer site.Close()
+
+	pages := NewPageCache()
+	cache := map[string]DataContext{site.URL + "/": {Description: "seed"}}
+	run := func(recrawl time.Duration) (*Manager, []string) {
+		t.Helper()
+		mu.Lock()
+		status = map[int]int{}
+		mu.Unlock()
+		mg := NewManager(Options{})
+		mg.CachedURLEmbeddings = cache
+		mg.pages = pages
+		var urls []string
+		for _, n := range mg.Recrawl(context.Background(), recrawl) {
+			urls = append(urls, n.Url)
+		}
+		return mg, urls
+	}
+	want := []string{site.URL + "/a.zip", site.URL + "/b.zip"}
+
+	mg, urls := run(0)
+	if !SlicesEqualUnordered(urls, want) || status[http.StatusOK] != 2 || mg.Progress().Reused != 0 {
+		t.Fatalf("first crawl: found %v, responses %v", urls, status)
+	}
+
+	// Both pages are revalidated and their stored links reused.
+	mg, urls = run(0)
+	if !SlicesEqualUnordered(urls, want) || status[http.StatusNotModified] != 2 || status[http.StatusOK] != 0 || mg.Progress().Reused != 2 {
+		t.Fatalf("second crawl: found %v, responses %v, progress %+v", urls, status, mg.Progress())
+	}
+
+	// Recently fetched pages are not requested at all.
+	mg, urls = run(time.Hour)
+	if !SlicesEqualUnordered(urls, want) || len(status) != 0 || mg.Progress().Fetched != 2 {
+		t.Fatalf("recrawl: found %v, responses %v", urls, status)
+	}
+
+	p := filepath.Join(t.TempDir(), "data.pages")
+	if err := pages.Save(p); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPageCache(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := loaded.Get(site.URL + "/"); !ok || e.ETag != `"v1"` || len(e.Links) != 2 || loaded.Len() != 2 {
+		t.Fatalf("loaded entry %+v (%d pages)", e, loaded.Len())
+	}
+}
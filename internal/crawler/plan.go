@@ -0,0 +1,287 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The download quotas, replaced by Config.Apply. Zero means no limit.
+var (
+	maxFileBytes  = activeConfig.MaxFileBytes
+	maxTotalBytes = activeConfig.MaxTotalBytes
+	minFreeBytes  = activeConfig.MinFreeBytes
+)
+
+// ErrQuota is returned for downloads that would exceed a download quota or
+// leave too little free disk space.
+var ErrQuota = errors.New("download quota exceeded")
+
+// PlanItem is the estimated size of one candidate.
+type PlanItem struct {
+	URL    string `json:"url"`
+	Seed   string `json:"seed"`
+	Format string `json:"format,omitempty"`
+	Size   int64  `json:"size"` // -1 when the server does not say
+	Error  string `json:"error,omitempty"`
+}
+
+// PlanTotal sums the items of a plan that share a seed or a format.
+type PlanTotal struct {
+	Key     string `json:"key"`
+	Files   int    `json:"files"`
+	Bytes   int64  `json:"bytes"`
+	Unknown int    `json:"unknown"` // files of unknown size, not in Bytes
+}
+
+// Plan is what downloading a set of candidates would take, as printed by
+// --dry-run.
+type Plan struct {
+	Items    []PlanItem  `json:"items"`
+	BySeed   []PlanTotal `json:"by_seed"`
+	ByFormat []PlanTotal `json:"by_format"`
+	Total    PlanTotal   `json:"total"`
+	Free     int64       `json:"free"` // bytes free in the download directory; -1 if unknown
+	// OverQuota explains why downloading everything would fail the quotas,
+	// empty if it would not.
+	OverQuota []string `json:"over_quota,omitempty"`
+}
+
+// probeSize asks the server for the size of rawURL without downloading it:
+// first with a HEAD request, then, if that gives no length, with a GET for
+// the first byte, whose Content-Range carries the full length. The format is
+// taken from the Content-Type when the URL does not tell.
+func probeSize(rawURL string) (int64, string, error) {
+	var mediaType string
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+		if err != nil {
+			return -1, "", err
+		}
+		if err := checkURL(req.URL); err != nil {
+			return -1, "", err
+		}
+		hc := activeConfig.Host(req.URL.Hostname())
+		req.Header.Set("User-Agent", hc.UserAgent)
+		if method == http.MethodGet {
+			req.Header.Set("Range", "bytes=0-0")
+		}
+		release := acquireHost(req.URL.Hostname(), hc)
+		resp, err := fetchClient.Do(req)
+		release()
+		if err == nil {
+			err = checkLogin(resp)
+		}
+		if err != nil {
+			return -1, "", err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		if mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			mediaType = strings.ToLower(mt)
+		}
+		switch {
+		case resp.StatusCode == http.StatusPartialContent:
+			if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+				if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+					return n, mediaType, nil
+				}
+			}
+		case resp.StatusCode == http.StatusOK && resp.ContentLength >= 0 && method == http.MethodHead:
+			return resp.ContentLength, mediaType, nil
+		case resp.StatusCode == http.StatusOK && resp.ContentLength > 1:
+			// The server ignored the range; its length is the file's.
+			return resp.ContentLength, mediaType, nil
+		case resp.StatusCode >= 400 && resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented:
+			return -1, mediaType, fmt.Errorf("%s %s: %s", method, rawURL, resp.Status)
+		}
+	}
+	return -1, mediaType, nil
+}
+
+// PlanDownloads probes the size of every candidate, a few at a time, and
+// totals them per seed and format against the quotas and the free space of
+// dir, if it is not empty.
+func PlanDownloads(nodes []WebNode, dir string) *Plan {
+	p := &Plan{Items: make([]PlanItem, len(nodes)), Free: -1}
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			downloadTokens <- struct{}{}
+			defer func() { <-downloadTokens }()
+			n := nodes[i]
+			it := PlanItem{URL: n.Url, Seed: seedOf(&n).Url, Format: candidateFormat(n)}
+			size, mediaType, err := probeSize(n.Url)
+			it.Size = size
+			if err != nil {
+				it.Error = err.Error()
+			}
+			if it.Format == "" && mediaType != "" {
+				it.Format = mediaType
+			}
+			p.Items[i] = it
+		}(i)
+	}
+	wg.Wait()
+
+	bySeed, byFormat := map[string]*PlanTotal{}, map[string]*PlanTotal{}
+	add := func(totals map[string]*PlanTotal, key string, it PlanItem) {
+		t := totals[key]
+		if t == nil {
+			t = &PlanTotal{Key: key}
+			totals[key] = t
+		}
+		t.add(it)
+	}
+	p.Total.Key = "total"
+	for _, it := range p.Items {
+		format := it.Format
+		if format == "" {
+			format = "unknown"
+		}
+		add(bySeed, it.Seed, it)
+		add(byFormat, format, it)
+		p.Total.add(it)
+		if maxFileBytes > 0 && it.Size > maxFileBytes {
+			p.OverQuota = append(p.OverQuota, fmt.Sprintf("%s is %s, more than --max-file-bytes %s", it.URL, FormatBytes(it.Size), FormatBytes(maxFileBytes)))
+		}
+	}
+	p.BySeed, p.ByFormat = sortedTotals(bySeed), sortedTotals(byFormat)
+
+	if maxTotalBytes > 0 && p.Total.Bytes > maxTotalBytes {
+		p.OverQuota = append(p.OverQuota, fmt.Sprintf("%s in total, more than --max-total-bytes %s", FormatBytes(p.Total.Bytes), FormatBytes(maxTotalBytes)))
+	}
+	if dir != "" {
+		p.Free = freeBytes(dir)
+		if p.Free >= 0 && p.Total.Bytes > p.Free-minFreeBytes {
+			p.OverQuota = append(p.OverQuota, fmt.Sprintf("%s would leave less than --min-free-bytes %s of the %s free in %s", FormatBytes(p.Total.Bytes), FormatBytes(minFreeBytes), FormatBytes(p.Free), dir))
+		}
+	}
+	return p
+}
+
+func (t *PlanTotal) add(it PlanItem) {
+	t.Files++
+	if it.Size < 0 {
+		t.Unknown++
+	} else {
+		t.Bytes += it.Size
+	}
+}
+
+// sortedTotals orders totals by size, largest first.
+func sortedTotals(totals map[string]*PlanTotal) []PlanTotal {
+	out := make([]PlanTotal, 0, len(totals))
+	for _, t := range totals {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// FormatBytes renders n as a size such as "3.4 GB", in multiples of 1024
+// like the quota settings.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// checkFreeSpace fails if dir already has less than the minimum free space,
+// so a session does not start downloads that cannot finish.
+func checkFreeSpace(dir string) error {
+	if free := freeBytes(dir); free >= 0 && free < minFreeBytes {
+		return fmt.Errorf("%w: only %s free in %s, less than --min-free-bytes %s", ErrQuota, FormatBytes(free), dir, FormatBytes(minFreeBytes))
+	}
+	return nil
+}
+
+// reserveQuota accounts for a download of size bytes (-1 if unknown) before
+// it starts, and fails if it is larger than the file quota, would exceed the
+// total quota, or would leave less than the minimum free space in dir.
+func (m *Manager) reserveQuota(size int64, dir string) error {
+	if size < 0 {
+		size = 0
+	}
+	if maxFileBytes > 0 && size > maxFileBytes {
+		return fmt.Errorf("%w: %s is more than the file limit of %s", ErrQuota, FormatBytes(size), FormatBytes(maxFileBytes))
+	}
+	if free := freeBytes(dir); free >= 0 && free-size < minFreeBytes {
+		return fmt.Errorf("%w: %s would leave less than %s free in %s", ErrQuota, FormatBytes(size), FormatBytes(minFreeBytes), dir)
+	}
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	if maxTotalBytes > 0 && m.quotaUsed+size > maxTotalBytes {
+		return fmt.Errorf("%w: %s would bring the session past its limit of %s", ErrQuota, FormatBytes(size), FormatBytes(maxTotalBytes))
+	}
+	m.quotaUsed += size
+	return nil
+}
+
+// releaseQuota gives back n bytes of the total quota, reserved for a
+// download that failed, was a copy of an earlier file or was smaller than
+// announced.
+func (m *Manager) releaseQuota(n int64) {
+	m.quotaMu.Lock()
+	m.quotaUsed -= n
+	m.quotaMu.Unlock()
+}
+
+// quotaBody enforces the quotas while a body is read: bytes beyond the
+// reserved size, which servers that do not announce a length always send,
+// count against the total as they arrive.
+func (m *Manager) quotaBody(r io.Reader, reserved int64) *quotaReader {
+	if reserved < 0 {
+		reserved = 0
+	}
+	return &quotaReader{m: m, r: r, reserved: reserved}
+}
+
+type quotaReader struct {
+	m        *Manager
+	r        io.Reader
+	reserved int64 // bytes accounted for in quotaUsed
+	read     int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.read += int64(n)
+	if maxFileBytes > 0 && q.read > maxFileBytes {
+		return n, fmt.Errorf("%w: more than the file limit of %s", ErrQuota, FormatBytes(maxFileBytes))
+	}
+	if extra := q.read - q.reserved; extra > 0 {
+		q.reserved = q.read
+		q.m.quotaMu.Lock()
+		q.m.quotaUsed += extra
+		over := maxTotalBytes > 0 && q.m.quotaUsed > maxTotalBytes
+		q.m.quotaMu.Unlock()
+		if over {
+			return n, fmt.Errorf("%w: the session passed its limit of %s", ErrQuota, FormatBytes(maxTotalBytes))
+		}
+	}
+	return n, err
+}
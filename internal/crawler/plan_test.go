@@ -0,0 +1,128 @@
+package crawler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quotaServer serves zip files of the size their name ends in, with the
+// same content for the same name. HEAD is refused
+// for /ranged/ files, which only reveal their size to range requests, and
+// /chunked/ files are sent without a length.
+func quotaServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var size int
+		switch {
+		case strings.HasSuffix(r.URL.Path, "1k.zip"):
+			size = 1 << 10
+		case strings.HasSuffix(r.URL.Path, "4k.zip"):
+			size = 4 << 10
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		name := path.Base(r.URL.Path)
+		body := "PK\x03\x04" + name + strings.Repeat("x", size-4-len(name))
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/ranged/") && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case strings.HasPrefix(r.URL.Path, "/chunked/"):
+			w.Header().Set("Content-Type", "application/zip")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(body))
+			w.(http.Flusher).Flush()
+		default:
+			w.Header().Set("Content-Type", "application/zip")
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPlanDownloads(t *testing.T) {
+	srv := quotaServer(t)
+	seed := &WebNode{Url: srv.URL + "/"}
+	nodes := []WebNode{
+		{Url: srv.URL + "/4k.zip", Parent: seed, Depth: 1},
+		{Url: srv.URL + "/ranged/1k.zip", Parent: seed, Depth: 1},
+		{Url: srv.URL + "/chunked/1k.zip", Parent: seed, Depth: 1},
+		{Url: srv.URL + "/missing.tif"},
+	}
+	old := maxTotalBytes
+	maxTotalBytes = 4 << 10
+	defer func() { maxTotalBytes = old }()
+
+	p := PlanDownloads(nodes, t.TempDir())
+	sizes := []int64{4 << 10, 1 << 10, -1, -1}
+	for i, it := range p.Items {
+		if it.Size != sizes[i] {
+			t.Errorf("%s: size %d, want %d", it.URL, it.Size, sizes[i])
+		}
+	}
+	if p.Items[3].Error == "" || p.Items[0].Seed != seed.Url {
+		t.Errorf("items %+v", p.Items)
+	}
+	if p.Total.Files != 4 || p.Total.Bytes != 5<<10 || p.Total.Unknown != 2 {
+		t.Errorf("total %+v", p.Total)
+	}
+	if len(p.BySeed) != 2 || p.BySeed[0].Key != seed.Url || p.BySeed[0].Files != 3 {
+		t.Errorf("by seed %+v", p.BySeed)
+	}
+	if p.ByFormat[0].Key != FormatZip || p.ByFormat[0].Bytes != 5<<10 {
+		t.Errorf("by format %+v", p.ByFormat)
+	}
+	if p.Free <= 0 || len(p.OverQuota) != 1 || !strings.Contains(p.OverQuota[0], "--max-total-bytes") {
+		t.Errorf("free %d, over quota %v", p.Free, p.OverQuota)
+	}
+}
+
+func TestDownloadQuotas(t *testing.T) {
+	srv := quotaServer(t)
+	oldFile, oldTotal := maxFileBytes, maxTotalBytes
+	maxFileBytes, maxTotalBytes = 2<<10, 3<<10
+	defer func() { maxFileBytes, maxTotalBytes = oldFile, oldTotal }()
+
+	dir := t.TempDir()
+	mg := NewManager(Options{Query: "q", DownloadDir: dir})
+	urls := []string{"/4k.zip", "/chunked/big-4k.zip", "/1k.zip", "/copy/1k.zip", "/ranged/b-1k.zip", "/chunked/c-1k.zip", "/d-1k.zip"}
+	for _, u := range urls {
+		if err := mg.DownloadURL(&WebNode{Url: srv.URL + u}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := map[string]string{}
+	for _, e := range mg.FinishManifest(nil).Entries {
+		errs[strings.TrimPrefix(e.SourceURL, srv.URL)] = e.Error
+	}
+	// The file limit stops both large files, the announced one before it
+	// starts. Neither the failed download nor the copy of 1k.zip counts
+	// against the session limit, which only the last small file passes.
+	for u, failed := range map[string]bool{"/4k.zip": true, "/chunked/big-4k.zip": true, "/1k.zip": false, "/copy/1k.zip": false,
+		"/ranged/b-1k.zip": false, "/chunked/c-1k.zip": false, "/d-1k.zip": true} {
+		if (errs[u] != "") != failed {
+			t.Errorf("%s: error %q", u, errs[u])
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 3 || mg.quotaUsed != 3<<10 {
+		t.Fatalf("files left: %v, quota used %d", files, mg.quotaUsed)
+	}
+
+	old := minFreeBytes
+	minFreeBytes = 1 << 62
+	defer func() { minFreeBytes = old }()
+	if err := checkFreeSpace(dir); !errors.Is(err, ErrQuota) {
+		t.Fatalf("free space check: %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,239 @@
+package crawler
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Score is the relevance of a candidate and how it was made up. Each signal
+// is in [0, 1] except Lexical; for crawl candidates Total is the weighted sum
+// of the signals, for catalog entries ranked by RankHybrid it is the
+// reciprocal rank fusion of the lexical and semantic rankings.
+type Score struct {
+	Total    float64 `json:"total"`
+	Semantic float64 `json:"semantic"`          // cosine similarity of description and query
+	Format   float64 `json:"format"`            // the file is in a format the query asks for
+	Spatial  float64 `json:"spatial"`           // share of the place named in the query that is covered
+	Temporal float64 `json:"temporal"`          // the years of the file overlap those of the query
+	Depth    float64 `json:"depth"`             // 1/(1+depth): links close to a seed rank higher
+	Lexical  float64 `json:"lexical,omitempty"` // BM25 score of the catalog entry
+}
+
+// rankWeights weight the signals of a Score. Signals the query gives no
+// hint about (no format, place or year named) score 0 for every candidate
+// and so do not change the order.
+var rankWeights = Score{Semantic: 0.6, Format: 0.15, Spatial: 0.1, Temporal: 0.1, Depth: 0.05}
+
+// queryFormats maps words in a query to the formats they ask for.
+var queryFormats = map[string][]string{
+	"shapefile": {FormatShapefile}, "shapefiles": {FormatShapefile}, "shp": {FormatShapefile},
+	"geotiff": {FormatTIFF}, "tiff": {FormatTIFF}, "tif": {FormatTIFF}, "raster": {FormatTIFF}, "dem": {FormatTIFF},
+	"geojson": {FormatGeoJSON}, "kml": {FormatKML}, "kmz": {FormatKML},
+	"csv": {FormatCSV}, "tabular": {FormatCSV},
+	"netcdf": {FormatNetCDF}, "grib": {FormatGRIB},
+	"lidar": {FormatLAS}, "las": {FormatLAS}, "laz": {FormatLAS},
+	"geopackage": {FormatGeoPackage}, "gpkg": {FormatGeoPackage},
+}
+
+// FormatCSV is the format of comma-separated tables. It is recognised by
+// extension only; SniffFormat reports plain text as FormatUnknown.
+const FormatCSV = "csv"
+
+// extensionFormats maps file extensions to formats.
+var extensionFormats = map[string]string{
+	".shp": FormatShapefile, ".tif": FormatTIFF, ".tiff": FormatTIFF, ".geojson": FormatGeoJSON,
+	".json": FormatJSON, ".kml": FormatKML, ".kmz": FormatKML, ".csv": FormatCSV, ".nc": FormatNetCDF,
+	".grib": FormatGRIB, ".grb": FormatGRIB, ".grib2": FormatGRIB, ".las": FormatLAS, ".laz": FormatLAS,
+	".gpkg": FormatGeoPackage, ".zip": FormatZip, ".xml": FormatXML,
+}
+
+// queryIntent is what a query says about format, place and time.
+type queryIntent struct {
+	formats  map[string]bool
+	places   []usState
+	minYear  int
+	maxYear  int
+	hasYears bool
+}
+
+func parseQueryIntent(q string) queryIntent {
+	in := queryIntent{formats: map[string]bool{}}
+	for _, w := range strings.FieldsFunc(strings.ToLower(q), notAlnum) {
+		for _, f := range queryFormats[w] {
+			in.formats[f] = true
+		}
+	}
+	in.places = placesIn(q)
+	in.minYear, in.maxYear, in.hasYears = yearRange(q)
+	return in
+}
+
+func notAlnum(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+}
+
+// placesIn returns the US states named in text, by full name or by upper-case
+// postal abbreviation.
+func placesIn(text string) []usState {
+	lower := " " + strings.Join(strings.FieldsFunc(strings.ToLower(text), notAlnum), " ") + " "
+	tokens := map[string]bool{}
+	for _, w := range strings.FieldsFunc(text, notAlnum) {
+		tokens[w] = true
+	}
+	var found []usState
+	for _, st := range usStates {
+		if tokens[st.Abbr] || strings.Contains(lower, " "+strings.ToLower(st.Name)+" ") {
+			found = append(found, st)
+		}
+	}
+	return found
+}
+
+// yearRange returns the smallest and largest year (1800-2099) mentioned in
+// text. Years may be embedded in file names such as ohio_lidar_2010.laz.
+func yearRange(text string) (min, max int, ok bool) {
+	for _, m := range strings.FieldsFunc(text, func(r rune) bool { return r < '0' || r > '9' }) {
+		y, err := strconv.Atoi(m)
+		if len(m) != 4 || err != nil || y < 1800 || y > 2099 {
+			continue
+		}
+		if !ok || y < min {
+			min = y
+		}
+		if !ok || y > max {
+			max = y
+		}
+		ok = true
+	}
+	return min, max, ok
+}
+
+// candidateFormat guesses the format of a candidate from the portal
+// metadata or the URL.
+func candidateFormat(n WebNode) string {
+	var md downloadMetadata
+	if json.Unmarshal([]byte(n.context.Description), &md) == nil && md.Format != "" {
+		if f, ok := queryFormats[strings.ToLower(md.Format)]; ok {
+			return f[0]
+		}
+		return strings.ToLower(md.Format)
+	}
+	p := n.Url
+	if u, err := url.Parse(n.Url); err == nil {
+		p = u.Path
+	}
+	f := extensionFormats[strings.ToLower(path.Ext(p))]
+	if f == FormatZip && strings.Contains(strings.ToLower(path.Base(p)), "shp") {
+		return FormatShapefile
+	}
+	return f
+}
+
+// scoreCandidate computes every signal except the semantic one.
+func scoreCandidate(in queryIntent, n WebNode) Score {
+	var s Score
+	if len(in.formats) > 0 && in.formats[candidateFormat(n)] {
+		s.Format = 1
+	}
+	text := n.Url + " " + n.context.Description
+	if len(in.places) > 0 {
+		if n.context.Extent != nil {
+			for _, p := range in.places {
+				s.Spatial = math.Max(s.Spatial, coverage(*n.context.Extent, p.BBox))
+			}
+		} else {
+			for _, st := range placesIn(text) {
+				for _, p := range in.places {
+					if st.Abbr == p.Abbr {
+						s.Spatial = 1
+					}
+				}
+			}
+		}
+	}
+	if in.hasYears {
+		if lo, hi, ok := yearRange(text); ok && lo <= in.maxYear && hi >= in.minYear {
+			s.Temporal = 1
+		}
+	}
+	s.Depth = 1 / float64(1+n.Depth)
+	return s
+}
+
+// coverage returns the share of area b that extent covers.
+func coverage(extent, b BBox) float64 {
+	w := math.Min(extent.MaxX, b.MaxX) - math.Max(extent.MinX, b.MinX)
+	h := math.Min(extent.MaxY, b.MaxY) - math.Max(extent.MinY, b.MinY)
+	area := (b.MaxX - b.MinX) * (b.MaxY - b.MinY)
+	if w <= 0 || h <= 0 || area <= 0 {
+		return 0
+	}
+	return math.Min(1, w*h/area)
+}
+
+func (s *Score) total() {
+	s.Total = rankWeights.Semantic*s.Semantic + rankWeights.Format*s.Format +
+		rankWeights.Spatial*s.Spatial + rankWeights.Temporal*s.Temporal + rankWeights.Depth*s.Depth
+}
+
+// RankCandidates scores the candidates found by a crawl against the query
+// and returns them best first, with Relevance and CosineSimilarity set.
+// Descriptions that are not cached yet are embedded in batches; if the
+// embedding service fails the candidates are ranked on the other signals.
+func (m *Manager) RankCandidates(queryEmbedding []float64, candidates []WebNode) []WebNode {
+	const batchSize = 50
+	ranked := append([]WebNode(nil), candidates...)
+
+	var pending []int
+	for i, n := range ranked {
+		if cached, ok := m.CachedURLEmbeddings[n.Url]; ok && len(cached.Embedding) > 0 {
+			ranked[i].context.Embedding = cached.Embedding
+			if ranked[i].context.Extent == nil {
+				ranked[i].context.Extent = cached.Extent
+			}
+		} else if n.context.Embedding == nil {
+			pending = append(pending, i)
+		}
+	}
+	for start := 0; start < len(pending); start += batchSize {
+		batch := pending[start:min(start+batchSize, len(pending))]
+		texts := make([]string, len(batch))
+		for j, i := range batch {
+			texts[j] = ranked[i].context.Description
+			if texts[j] == "" {
+				texts[j] = ranked[i].Url
+			}
+		}
+		res, err := GetBatchedEmbeddings(texts)
+		if err != nil || len(res.Embeddings) != len(batch) {
+			log.Printf("embedding candidates for ranking failed: %v", err)
+			break
+		}
+		for j, i := range batch {
+			ranked[i].context.Embedding = res.Embeddings[j]
+		}
+	}
+
+	in := parseQueryIntent(*m.searchQuery)
+	for i := range ranked {
+		s := scoreCandidate(in, ranked[i])
+		if ranked[i].context.Embedding != nil {
+			if c, err := Cosine(queryEmbedding, ranked[i].context.Embedding); err == nil {
+				s.Semantic = math.Max(0, c)
+			}
+		}
+		s.total()
+		ranked[i].CosineSimilarity = s.Semantic
+		ranked[i].Relevance = &s
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Relevance.Total > ranked[j].Relevance.Total
+	})
+	return ranked
+}
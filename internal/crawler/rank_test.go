@@ -0,0 +1,58 @@
+package crawler
+
+import "testing"
+
+func TestRankCandidates(t *testing.T) {
+	query := "Ohio lidar 2004-2020"
+	m := &Manager{
+		searchQuery: &query,
+		CachedURLEmbeddings: map[string]DataContext{
+			"https://x/ohio_lidar_2010.laz": {Embedding: []float64{1, 0}},
+			"https://x/texas.csv":           {Embedding: []float64{1, 0}},
+			"https://x/other.zip":           {Embedding: []float64{0, 1}},
+			"https://x/statewide.zip": {Embedding: []float64{0, 1},
+				Extent: &BBox{MinX: -85, MinY: 38, MaxX: -80, MaxY: 42}},
+		},
+	}
+	candidates := []WebNode{
+		{Url: "https://x/other.zip", Depth: 1},
+		{Url: "https://x/texas.csv", Depth: 1},
+		{Url: "https://x/statewide.zip", Depth: 1},
+		{Url: "https://x/ohio_lidar_2010.laz", Depth: 2},
+	}
+	ranked := m.RankCandidates([]float64{1, 0}, candidates)
+
+	want := []string{"https://x/ohio_lidar_2010.laz", "https://x/texas.csv", "https://x/statewide.zip", "https://x/other.zip"}
+	for i, u := range want {
+		if ranked[i].Url != u {
+			t.Fatalf("rank %d: got %s want %s", i, ranked[i].Url, u)
+		}
+	}
+	top := ranked[0].Relevance
+	if top.Semantic != 1 || top.Format != 1 || top.Spatial != 1 || top.Temporal != 1 || ranked[0].CosineSimilarity != 1 {
+		t.Fatalf("breakdown: %+v", top)
+	}
+	if s := ranked[2].Relevance; s.Spatial != 1 || s.Format != 0 {
+		t.Fatalf("extent covering Ohio: %+v", s)
+	}
+	if s := ranked[1].Relevance; s.Spatial != 0 || s.Temporal != 0 {
+		t.Fatalf("texas.csv: %+v", s)
+	}
+}
+
+func TestParseQueryIntent(t *testing.T) {
+	in := parseQueryIntent("NLCD land cover shapefile for OH and New York in 2016")
+	if !in.formats[FormatShapefile] || len(in.formats) != 1 {
+		t.Fatalf("formats: %v", in.formats)
+	}
+	if len(in.places) != 2 || in.places[0].Abbr != "NY" && in.places[1].Abbr != "NY" {
+		t.Fatalf("places: %+v", in.places)
+	}
+	if !in.hasYears || in.minYear != 2016 || in.maxYear != 2016 {
+		t.Fatalf("years: %+v", in)
+	}
+	// Lower-case words that happen to be postal codes are not places.
+	if p := placesIn("data in or near me"); len(p) != 0 {
+		t.Fatalf("got places %+v", p)
+	}
+}
@@ -0,0 +1,225 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The retry settings, replaced by Config.Apply.
+var (
+	maxRetries = activeConfig.Retries
+	retryBase  = activeConfig.RetryBase
+	retryMax   = activeConfig.RetryMax
+)
+
+// The classes errors are grouped by in the per-seed totals.
+const (
+	ClassDNS        = "dns"        // the host name did not resolve
+	ClassTLS        = "tls"        // the certificate or handshake was rejected
+	ClassTimeout    = "timeout"    // connecting or reading took too long
+	ClassConnection = "connection" // the connection was refused, reset or cut short
+	ClassClient     = "4xx"        // the server refused the request
+	ClassServer     = "5xx"        // the server failed
+	ClassParse      = "parse"      // the page could not be read as HTML
+	ClassRefused    = "refused"    // godl refused the destination, or a login is required
+	ClassOther      = "other"
+)
+
+// StatusError is returned for a response whose status is not the one
+// expected.
+type StatusError struct {
+	URL    string
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("getting %s: %s", e.URL, e.Status)
+}
+
+// ParseError is returned for a page that could not be parsed.
+type ParseError struct {
+	URL string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parsing %s as HTML: %v", e.URL, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// errorClass returns the class of err, one of the Class constants.
+func errorClass(err error) string {
+	var status *StatusError
+	var dns *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &status):
+		if status.Code >= 500 {
+			return ClassServer
+		}
+		return ClassClient
+	case errors.Is(err, ErrUnsafeURL), errors.Is(err, ErrLoginRequired):
+		return ClassRefused
+	case errors.As(err, new(*ParseError)), errors.Is(err, ErrPageTooLarge):
+		return ClassParse
+	case errors.As(err, &dns):
+		return ClassDNS
+	case tlsError(err):
+		return ClassTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ClassTimeout
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return ClassConnection
+	}
+	return ClassOther
+}
+
+// tlsError reports whether err is a rejected certificate or a failed TLS
+// handshake.
+func tlsError(err error) bool {
+	var verify *tls.CertificateVerificationError
+	var record tls.RecordHeaderError
+	var alert tls.AlertError
+	var host x509.HostnameError
+	var authority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &verify) || errors.As(err, &record) || errors.As(err, &alert) ||
+		errors.As(err, &host) || errors.As(err, &authority) || errors.As(err, &invalid)
+}
+
+// retryStatus are the statuses of responses worth asking for again.
+var retryStatus = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooEarly:            true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// retryable reports whether a request that failed with err may succeed when
+// sent again. Names that do not resolve, rejected certificates and refused
+// destinations fail the same way every time; a DNS server that timed out
+// may answer next time.
+func retryable(err error) bool {
+	var dns *net.DNSError
+	if errors.As(err, &dns) {
+		return dns.IsTimeout || dns.IsTemporary
+	}
+	switch errorClass(err) {
+	case ClassTimeout, ClassConnection:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retry number attempt (from 0):
+// the Retry-After of resp if it has one, else retryBase doubled for every
+// earlier attempt with random jitter, so that workers that failed together
+// do not come back together. Either is capped at retryMax.
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(d, retryMax)
+		}
+	}
+	d := retryBase << attempt
+	if d <= 0 || d > retryMax {
+		d = retryMax
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// SeedErrors counts the pages reached from one seed that failed, by class.
+type SeedErrors struct {
+	Seed    string         `json:"seed"`
+	Total   int            `json:"total"`
+	Classes map[string]int `json:"classes"`
+}
+
+// String lists the classes of s, most frequent first, e.g. "5xx 3, dns 1".
+func (s SeedErrors) String() string {
+	classes := make([]string, 0, len(s.Classes))
+	for c := range s.Classes {
+		classes = append(classes, c)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if s.Classes[classes[i]] != s.Classes[classes[j]] {
+			return s.Classes[classes[i]] > s.Classes[classes[j]]
+		}
+		return classes[i] < classes[j]
+	})
+	parts := make([]string, len(classes))
+	for i, c := range classes {
+		parts[i] = fmt.Sprintf("%s %d", c, s.Classes[c])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// countError adds a failed page to the totals of its seed. The caller holds
+// eventMu.
+func (m *Manager) countError(ev Event) {
+	if m.seedErrors == nil {
+		m.seedErrors = make(map[string]map[string]int)
+	}
+	seed := seedOf(&ev.Node).Url
+	if m.seedErrors[seed] == nil {
+		m.seedErrors[seed] = make(map[string]int)
+	}
+	m.seedErrors[seed][errorClass(ev.Err)]++
+}
+
+// ErrorsBySeed returns the totals of the pages that failed, per seed and
+// class of error, the seed with the most errors first.
+func (m *Manager) ErrorsBySeed() []SeedErrors {
+	m.eventMu.Lock()
+	defer m.eventMu.Unlock()
+	out := make([]SeedErrors, 0, len(m.seedErrors))
+	for seed, classes := range m.seedErrors {
+		s := SeedErrors{Seed: seed, Classes: make(map[string]int, len(classes))}
+		for c, n := range classes {
+			s.Classes[c] = n
+			s.Total += n
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		return out[i].Seed < out[j].Seed
+	})
+	return out
+}
@@ -0,0 +1,162 @@
+package crawler
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useRetries sets the retry settings for the rest of the test.
+func useRetries(t *testing.T, n int, base time.Duration) {
+	oldN, oldBase, oldMax := maxRetries, retryBase, retryMax
+	maxRetries, retryBase, retryMax = n, base, time.Second
+	t.Cleanup(func() { maxRetries, retryBase, retryMax = oldN, oldBase, oldMax })
+}
+
+func TestRetry(t *testing.T) {
+	useRetries(t, 2, time.Millisecond)
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/busy":
+			if n < 3 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+		case "/limited":
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/dropped":
+			if n == 1 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		hits   int
+	}{
+		{"/busy", http.StatusOK, 3},
+		{"/dropped", http.StatusOK, 2},
+		{"/limited", http.StatusTooManyRequests, 3},
+		{"/missing", http.StatusNotFound, 1},
+	} {
+		resp, err := httpGet(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || hits[tc.path] != tc.hits {
+			t.Errorf("%s: %s after %d requests, want %d after %d", tc.path, resp.Status, hits[tc.path], tc.status, tc.hits)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	useRetries(t, 3, 100*time.Millisecond)
+	for attempt, limit := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := retryDelay(attempt, nil); d < limit/2 || d > limit {
+				t.Fatalf("attempt %d: %v, want %v to %v", attempt, d, limit/2, limit)
+			}
+		}
+	}
+	resp := &http.Response{Header: http.Header{}}
+	for v, want := range map[string]time.Duration{
+		"0":                             0,
+		"1":                             time.Second,
+		"120":                           time.Second, // capped at retryMax
+		"soon":                          -1,
+		"Fri, 01 Jan 2010 00:00:00 GMT": 0,
+	} {
+		resp.Header.Set("Retry-After", v)
+		d := retryDelay(0, resp)
+		if want < 0 && (d < 50*time.Millisecond || d > 100*time.Millisecond) || want >= 0 && d != want {
+			t.Errorf("Retry-After %q: %v", v, d)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	urlErr := func(err error) error { return &url.Error{Op: "Get", URL: "https://example.com/", Err: err} }
+	for _, tc := range []struct {
+		err   error
+		class string
+		retry bool
+	}{
+		{urlErr(&net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}), ClassDNS, false},
+		{urlErr(&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}), ClassDNS, true},
+		{urlErr(x509.UnknownAuthorityError{}), ClassTLS, false},
+		{urlErr(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), ClassConnection, true},
+		{urlErr(io.ErrUnexpectedEOF), ClassConnection, true},
+		{urlErr(context.DeadlineExceeded), ClassTimeout, true},
+		{&StatusError{URL: "https://example.com/", Code: 404, Status: "404 Not Found"}, ClassClient, false},
+		{&StatusError{URL: "https://example.com/", Code: 502, Status: "502 Bad Gateway"}, ClassServer, false},
+		{&ParseError{URL: "https://example.com/", Err: ErrPageTooLarge}, ClassParse, false},
+		{fmt.Errorf("%w: 10.0.0.1", ErrUnsafeURL), ClassRefused, false},
+		{errors.New("something else"), ClassOther, false},
+	} {
+		if got := errorClass(tc.err); got != tc.class {
+			t.Errorf("errorClass(%v) = %s, want %s", tc.err, got, tc.class)
+		}
+		if got := retryable(tc.err); got != tc.retry {
+			t.Errorf("retryable(%v) = %v", tc.err, got)
+		}
+	}
+}
+
+func TestErrorsBySeed(t *testing.T) {
+	useRetries(t, 0, time.Millisecond)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/gone">a</a><a href="/moved">b</a><a href="/broken">c</a>`)
+		case "/broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	mg := NewManager(Options{})
+	mg.CachedURLEmbeddings = map[string]DataContext{site.URL + "/": {Description: "seed"}}
+	mg.pages = NewPageCache()
+	mg.crawl(context.Background(), []WebNode{{Url: site.URL + "/"}})
+
+	errs := mg.ErrorsBySeed()
+	if len(errs) != 1 || errs[0].Seed != site.URL+"/" || errs[0].Total != 3 ||
+		errs[0].Classes[ClassClient] != 2 || errs[0].Classes[ClassServer] != 1 {
+		t.Fatalf("errors %+v", errs)
+	}
+	if s := errs[0].String(); s != "4xx 2, 5xx 1" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -0,0 +1,171 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sandboxEnv marks a process started as the sandboxed download helper.
+const sandboxEnv = "GODL_SANDBOX_HELPER"
+
+// quarantineDir is where the helper writes, inside the download directory.
+const quarantineDir = ".quarantine"
+
+// ErrNoSandbox is returned by secure downloads when the helper cannot be
+// isolated on this system.
+var ErrNoSandbox = errors.New("download sandbox unavailable; use --nosec to download without it")
+
+// ErrRejected is returned for downloads that fail validation in quarantine.
+var ErrRejected = errors.New("download rejected")
+
+// The helper is this executable started again with sandboxEnv set. It is
+// intercepted before main runs, so every binary linking the package can
+// serve as its own helper.
+func init() {
+	if os.Getenv(sandboxEnv) == "1" {
+		os.Exit(runSandboxHelper(os.Args[1:]))
+	}
+}
+
+// sandboxResult is what the helper reports on stdout.
+type sandboxResult struct {
+	File   string `json:"file,omitempty"` // name in the quarantine directory
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	Format string `json:"format,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// saveSandboxed saves the body of resp like saveResponse, but the bytes are
+// written by a helper process that is isolated by sandboxCommand: it cannot
+// use the network and may only write in the quarantine directory. The helper
+// checks the size and type of the file against name, the file name it will
+// be saved under. The file stays in quarantine until placeFile moves it,
+// and is only returned if the checks pass.
+func saveSandboxed(resp *http.Response, rawURL, name, downloadDir string) (savedFile, error) {
+	defer resp.Body.Close()
+	quarantine := filepath.Join(downloadDir, quarantineDir)
+	if err := os.MkdirAll(quarantine, 0700); err != nil {
+		return savedFile{}, err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return savedFile{}, fmt.Errorf("%w: %v", ErrNoSandbox, err)
+	}
+	cmd := exec.Command(exe, quarantine, filepath.Base(name), strconv.FormatInt(resp.ContentLength, 10), strconv.FormatInt(maxFileBytes, 10))
+	cmd.Env = append(os.Environ(), sandboxEnv+"=1")
+	cmd.Stdin = resp.Body
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := sandboxCommand(cmd); err != nil {
+		return savedFile{}, err
+	}
+	runErr := cmd.Run()
+
+	var res sandboxResult
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		if runErr != nil && cmd.ProcessState == nil {
+			return savedFile{}, fmt.Errorf("%w: %v", ErrNoSandbox, runErr)
+		}
+		return savedFile{}, fmt.Errorf("download helper for %s failed: %v %s", rawURL, runErr, strings.TrimSpace(stderr.String()))
+	}
+	if res.Error != "" {
+		return savedFile{Size: res.Size}, fmt.Errorf("%s: %w: %s", rawURL, ErrRejected, res.Error)
+	}
+	// Reading the body failed, so the helper saw a short file.
+	if runErr != nil {
+		os.Remove(filepath.Join(quarantine, filepath.Base(res.File)))
+		return savedFile{}, fmt.Errorf("reading %s: %w", rawURL, runErr)
+	}
+
+	// The helper only reports a base name; anything else is not trusted.
+	if res.File != filepath.Base(res.File) || res.File == "." || res.File == ".." {
+		return savedFile{}, fmt.Errorf("download helper for %s reported %q", rawURL, res.File)
+	}
+	return savedFile{Path: filepath.Join(quarantine, res.File), Size: res.Size, SHA256: res.SHA256}, nil
+}
+
+// runSandboxHelper is the helper process. Its arguments are the quarantine
+// directory, the name of the file, the advertised length (-1 if unknown)
+// and the size limit (0 for none); the body arrives on stdin.
+func runSandboxHelper(args []string) int {
+	res := sandboxHelper(args)
+	json.NewEncoder(os.Stdout).Encode(res)
+	if res.Error != "" {
+		return ExitError
+	}
+	return ExitOK
+}
+
+func sandboxHelper(args []string) sandboxResult {
+	if len(args) != 4 {
+		return sandboxResult{Error: "usage: <quarantine> <name> <length> <max>"}
+	}
+	quarantine, name := args[0], args[1]
+	length, err1 := strconv.ParseInt(args[2], 10, 64)
+	limit, err2 := strconv.ParseInt(args[3], 10, 64)
+	if err := errors.Join(err1, err2); err != nil {
+		return sandboxResult{Error: err.Error()}
+	}
+
+	// Everything below runs on the thread the restrictions apply to.
+	if err := restrictWrites(quarantine); err != nil {
+		return sandboxResult{Error: err.Error()}
+	}
+
+	f, err := os.CreateTemp(quarantine, "download-*")
+	if err != nil {
+		return sandboxResult{Error: err.Error()}
+	}
+	res := sandboxResult{File: filepath.Base(f.Name())}
+	reject := func(format string, a ...any) sandboxResult {
+		f.Close()
+		os.Remove(f.Name())
+		res.File, res.Error = "", fmt.Sprintf(format, a...)
+		return res
+	}
+
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(os.Stdin, head)
+	head = head[:n]
+	res.Format = SniffFormat(head)
+
+	body := io.MultiReader(bytes.NewReader(head), os.Stdin)
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	hw := newHashingWriter(f)
+	if _, err := io.Copy(hw, body); err != nil {
+		return reject("writing: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return reject("writing: %v", err)
+	}
+	res.Size, res.SHA256 = hw.size, hw.Hex()
+
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case res.Size == 0:
+		return reject("empty file")
+	case limit > 0 && res.Size > limit:
+		return reject("larger than %d bytes", limit)
+	case length >= 0 && res.Size != length:
+		return reject("got %d of %d bytes", res.Size, length)
+	case nonDataFormats[res.Format]:
+		return reject("content is %s, not a dataset", res.Format)
+	case !geoBinaryFormats[res.Format] && !GeoFileExtensions[ext]:
+		return reject("unrecognised content for %s", name)
+	}
+	return res
+}
@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// sandboxCommand starts the helper in new user, network, PID, IPC and UTS
+// namespaces: it has no network interfaces besides a down loopback, sees no
+// other processes and holds no privileges outside its namespace. It is
+// killed if godl exits.
+func sandboxCommand(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		Pdeathsig:   syscall.SIGKILL,
+	}
+	return nil
+}
+
+// Landlock system calls and flags, from linux/landlock.h.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSRemoveDir  = 1 << 4
+	landlockAccessFSRemoveFile = 1 << 5
+	landlockAccessFSMakeChar   = 1 << 6
+	landlockAccessFSMakeDir    = 1 << 7
+	landlockAccessFSMakeReg    = 1 << 8
+	landlockAccessFSMakeSock   = 1 << 9
+	landlockAccessFSMakeFifo   = 1 << 10
+	landlockAccessFSMakeBlock  = 1 << 11
+	landlockAccessFSMakeSym    = 1 << 12
+	landlockAccessFSRefer      = 1 << 13 // ABI 2
+	landlockAccessFSTruncate   = 1 << 14 // ABI 3
+
+	landlockAccessNetBindTCP    = 1 << 0 // ABI 4
+	landlockAccessNetConnectTCP = 1 << 1 // ABI 4
+
+	// Missing from package syscall.
+	oPath           = 0x200000
+	prSetNoNewPrivs = 38
+)
+
+// restrictWrites locks the calling goroutine to its thread and uses Landlock
+// to deny that thread every write outside dir and, on kernels that support
+// it, every TCP bind and connect. Landlock restricts single threads, so the
+// helper does its writes on the calling goroutine, which stays locked until
+// the helper exits. Kernels without Landlock rely on the namespaces alone.
+func restrictWrites(dir string) error {
+	runtime.LockOSThread()
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno == syscall.ENOSYS || errno == syscall.EOPNOTSUPP {
+		return nil
+	}
+	if errno != 0 {
+		return fmt.Errorf("%w: landlock: %v", ErrNoSandbox, errno)
+	}
+
+	write := uint64(landlockAccessFSWriteFile | landlockAccessFSRemoveDir | landlockAccessFSRemoveFile |
+		landlockAccessFSMakeChar | landlockAccessFSMakeDir | landlockAccessFSMakeReg | landlockAccessFSMakeSock |
+		landlockAccessFSMakeFifo | landlockAccessFSMakeBlock | landlockAccessFSMakeSym)
+	if abi >= 2 {
+		write |= landlockAccessFSRefer
+	}
+	if abi >= 3 {
+		write |= landlockAccessFSTruncate
+	}
+	// struct landlock_ruleset_attr; handled_access_net exists from ABI 4.
+	attr := [2]uint64{write, 0}
+	size := unsafe.Sizeof(attr[0])
+	if abi >= 4 {
+		attr[1] = landlockAccessNetBindTCP | landlockAccessNetConnectTCP
+		size = unsafe.Sizeof(attr)
+	}
+
+	err := func() error {
+		ruleset, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), size, 0)
+		if errno != 0 {
+			return fmt.Errorf("creating ruleset: %w", errno)
+		}
+		defer syscall.Close(int(ruleset))
+
+		fd, err := syscall.Open(dir, oPath|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", dir, err)
+		}
+		defer syscall.Close(fd)
+		// struct landlock_path_beneath_attr is packed: a u64 and an s32.
+		var beneath [12]byte
+		*(*uint64)(unsafe.Pointer(&beneath[0])) = write
+		*(*int32)(unsafe.Pointer(&beneath[8])) = int32(fd)
+		if _, _, errno := syscall.Syscall6(sysLandlockAddRule, ruleset, landlockRulePathBeneath, uintptr(unsafe.Pointer(&beneath[0])), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("adding rule for %s: %w", dir, errno)
+		}
+
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+			return fmt.Errorf("setting no_new_privs: %w", errno)
+		}
+		if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, ruleset, 0, 0); errno != 0 {
+			return fmt.Errorf("restricting: %w", errno)
+		}
+		return nil
+	}()
+	if err != nil {
+		return fmt.Errorf("%w: landlock: %v", ErrNoSandbox, err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package crawler
+
+import "os/exec"
+
+// sandboxCommand reports that the helper cannot be isolated: namespaces and
+// Landlock are only available on Linux.
+func sandboxCommand(cmd *exec.Cmd) error {
+	return ErrNoSandbox
+}
+
+func restrictWrites(dir string) error {
+	return ErrNoSandbox
+}
@@ -0,0 +1,89 @@
+package crawler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sandboxResponse(body string, length int64) *http.Response {
+	return &http.Response{Body: io.NopCloser(strings.NewReader(body)), ContentLength: length}
+}
+
+func TestSaveSandboxed(t *testing.T) {
+	dir := t.TempDir()
+	zip := "PK\x03\x04" + strings.Repeat("x", 2000)
+	saved, err := saveSandboxed(sandboxResponse(zip, int64(len(zip))), "https://example.com/data/roads.zip", "roads.zip", dir)
+	if errors.Is(err, ErrNoSandbox) {
+		t.Skipf("no sandbox on this system: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(saved.Path)
+	if filepath.Dir(saved.Path) != filepath.Join(dir, quarantineDir) || saved.Size != int64(len(zip)) || saved.SHA256 == "" || string(data) != zip {
+		t.Fatalf("saved %+v", saved)
+	}
+	if saved, err = placeFile(saved, filepath.Join(dir, "roads.zip")); err != nil || saved.Path != filepath.Join(dir, "roads.zip") {
+		t.Fatalf("placing: %+v %v", saved, err)
+	}
+
+	old := maxFileBytes
+	maxFileBytes = 1000
+	defer func() { maxFileBytes = old }()
+	for name, resp := range map[string]*http.Response{
+		"page.zip":    sandboxResponse("<!DOCTYPE html><html><body>login</body></html>", -1),
+		"cut.zip":     sandboxResponse("PK\x03\x04abc", 100),
+		"big.zip":     sandboxResponse(zip, -1),
+		"empty.tif":   sandboxResponse("", -1),
+		"install.exe": sandboxResponse("MZ\x90\x00", -1),
+	} {
+		if _, err := saveSandboxed(resp, "https://example.com/"+name, name, dir); !errors.Is(err, ErrRejected) {
+			t.Errorf("%s: %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s left the quarantine", name)
+		}
+	}
+	if left, _ := os.ReadDir(filepath.Join(dir, quarantineDir)); len(left) != 0 {
+		t.Fatalf("quarantine not emptied: %v", left)
+	}
+}
+
+// TestRestrictWrites runs itself in a child process, which restricts its
+// writes to one directory and then tries to write elsewhere.
+func TestRestrictWrites(t *testing.T) {
+	if dir := os.Getenv("GODL_TEST_RESTRICT"); dir != "" {
+		if err := restrictWrites(filepath.Join(dir, "inside")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "inside", "ok"), []byte("ok"), 0644); err != nil {
+			t.Fatalf("writing inside: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "outside"), []byte("escaped"), 0644); err == nil {
+			t.Fatal("wrote outside the allowed directory")
+		}
+		return
+	}
+	if err := sandboxCommand(exec.Command("true")); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "inside"), 0755)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRestrictWrites$")
+	cmd.Env = append(os.Environ(), "GODL_TEST_RESTRICT="+dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "inside", "ok")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside")); err == nil {
+		t.Fatal("file written outside the allowed directory")
+	}
+}
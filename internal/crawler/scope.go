@@ -0,0 +1,293 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ScopeConfig decides which pages a crawl follows from a seed. Links to
+// datasets found on pages in scope are always reported.
+type ScopeConfig struct {
+	SameDomain   bool           // stay on the registrable domain of the seed
+	AllowHosts   []string       // other hosts crawled, with their subdomains
+	Include      *regexp.Regexp // when set, page URLs must match
+	Exclude      *regexp.Regexp // page URLs matching are skipped
+	PathPrefixes []string       // when set, page paths must start with one of them
+	MaxPages     int            // pages crawled from the seed; 0 for no limit
+	MaxBytes     int64          // bytes of pages read while crawling from the seed; 0 for no limit
+}
+
+// ScopeOverrides holds the scope settings given for one seed, by key, which
+// replace the [scope] ones.
+type ScopeOverrides map[string]string
+
+// scopeKeys are the settings of a [scope] or [seeds."host"] table.
+var scopeKeys = []string{"same_domain", "allow_hosts", "include", "exclude", "path_prefixes", "max_pages", "max_bytes"}
+
+func (s *ScopeConfig) set(name, v string) error {
+	switch name {
+	case "same_domain":
+		return setBool(&s.SameDomain, v)
+	case "allow_hosts":
+		s.AllowHosts = nil
+		for _, h := range splitList(v) {
+			s.AllowHosts = append(s.AllowHosts, strings.ToLower(h))
+		}
+	case "include", "exclude":
+		var re *regexp.Regexp
+		if v != "" {
+			var err error
+			if re, err = regexp.Compile(v); err != nil {
+				return err
+			}
+		}
+		if name == "include" {
+			s.Include = re
+		} else {
+			s.Exclude = re
+		}
+	case "path_prefixes":
+		s.PathPrefixes = splitList(v)
+	case "max_pages":
+		return setCount(&s.MaxPages, v)
+	case "max_bytes":
+		n, err := parseBytes(v)
+		if err != nil {
+			return err
+		}
+		s.MaxBytes = n
+	default:
+		return fmt.Errorf("unknown scope setting %q", name)
+	}
+	return nil
+}
+
+func (s *ScopeConfig) get(name string) string {
+	switch name {
+	case "same_domain":
+		return strconv.FormatBool(s.SameDomain)
+	case "allow_hosts":
+		return strings.Join(s.AllowHosts, ",")
+	case "include", "exclude":
+		re := s.Include
+		if name == "exclude" {
+			re = s.Exclude
+		}
+		if re == nil {
+			return ""
+		}
+		return re.String()
+	case "path_prefixes":
+		return strings.Join(s.PathPrefixes, ",")
+	case "max_pages":
+		return strconv.Itoa(s.MaxPages)
+	case "max_bytes":
+		return strconv.FormatInt(s.MaxBytes, 10)
+	}
+	return ""
+}
+
+// parseBytes reads a size such as 5000, 512KB, 50MB or 2GB, in multiples of
+// 1024.
+func parseBytes(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = strings.TrimSpace(rest), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size such as \"50MB\"", v)
+	}
+	return n * mult, nil
+}
+
+func (c *Config) setSeed(host, name, value string) error {
+	var check ScopeConfig
+	if err := check.set(name, value); err != nil {
+		return fmt.Errorf("seeds.%s.%s: %w", host, name, err)
+	}
+	if c.Seeds == nil {
+		c.Seeds = map[string]ScopeOverrides{}
+	}
+	if c.Seeds[host] == nil {
+		c.Seeds[host] = ScopeOverrides{}
+	}
+	c.Seeds[host][name] = value
+	return nil
+}
+
+// SeedScope returns the scope of crawls from a seed on host: the [scope]
+// settings with those of the most specific entry of Seeds matching host or
+// one of its parent domains applied over them.
+func (c *Config) SeedScope(host string) ScopeConfig {
+	s := c.Scope
+	host = strings.ToLower(host)
+	for h := host; h != ""; {
+		if o, ok := c.Seeds[h]; ok {
+			for _, name := range scopeKeys {
+				if v, ok := o[name]; ok {
+					s.set(name, v) // validated by setSeed
+				}
+			}
+			break
+		}
+		i := strings.IndexByte(h, '.')
+		if i < 0 {
+			break
+		}
+		h = h[i+1:]
+	}
+	return s
+}
+
+// registrableDomain returns the part of host its owner registered, e.g.
+// usgs.gov for www.usgs.gov or water.usgs.gov. IP addresses and hosts
+// without a public suffix are returned as they are.
+func registrableDomain(host string) string {
+	host = strings.ToLower(host)
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	d, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return d
+}
+
+// onHost reports whether host is domain or one of its subdomains.
+func onHost(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// seedScope is the scope of one seed during a crawl, with what the crawl
+// has spent from its budgets.
+type seedScope struct {
+	ScopeConfig
+	domain string // registrable domain of the seed
+	pages  int
+	bytes  int64
+}
+
+// reject returns why a link to rawURL is not crawled from this seed, or ""
+// if it is in scope.
+func (s *seedScope) reject(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "unparsable URL"
+	}
+	host := strings.ToLower(u.Hostname())
+	allowed := false
+	for _, h := range s.AllowHosts {
+		if onHost(host, h) {
+			allowed = true
+			break
+		}
+	}
+	switch {
+	case s.SameDomain && !allowed && !onHost(host, s.domain):
+		return "outside " + s.domain
+	case s.Exclude != nil && s.Exclude.MatchString(rawURL):
+		return "matches exclude " + s.Exclude.String()
+	case s.Include != nil && !s.Include.MatchString(rawURL):
+		return "does not match include " + s.Include.String()
+	}
+	if len(s.PathPrefixes) > 0 {
+		for _, p := range s.PathPrefixes {
+			if strings.HasPrefix(u.Path, p) {
+				return ""
+			}
+		}
+		return "outside paths " + strings.Join(s.PathPrefixes, ",")
+	}
+	return ""
+}
+
+// spent returns which budget of the seed is used up, or "".
+func (s *seedScope) spent() string {
+	switch {
+	case s.MaxPages > 0 && s.pages >= s.MaxPages:
+		return fmt.Sprintf("page budget of %d spent", s.MaxPages)
+	case s.MaxBytes > 0 && s.bytes >= s.MaxBytes:
+		return fmt.Sprintf("byte budget of %d spent", s.MaxBytes)
+	}
+	return ""
+}
+
+// seedOf returns the seed n was reached from.
+func seedOf(n *WebNode) *WebNode {
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return n
+}
+
+// scopeOf returns the scope of the seed n was reached from. The caller must
+// hold m.ckMu.
+func (m *Manager) scopeOf(n *WebNode) *seedScope {
+	seed := seedOf(n).Url
+	if s, ok := m.scopes[seed]; ok {
+		return s
+	}
+	if m.scopes == nil {
+		m.scopes = make(map[string]*seedScope)
+	}
+	var host string
+	if u, err := url.Parse(seed); err == nil {
+		host = u.Hostname()
+	}
+	s := &seedScope{ScopeConfig: activeConfig.SeedScope(host), domain: registrableDomain(host)}
+	m.scopes[seed] = s
+	return s
+}
+
+// inScope reports whether the page link l may be crawled, logging why it is
+// not.
+func (m *Manager) inScope(l *WebNode) bool {
+	m.ckMu.Lock()
+	why := m.scopeOf(l).reject(l.Url)
+	m.ckMu.Unlock()
+	if why == "" {
+		return true
+	}
+	m.emitFiltered()
+	log.Printf("out of scope of %s: %s (%s)", seedOf(l).Url, l.Url, why)
+	return false
+}
+
+// countBytes adds the bytes read from r to the budget of node's seed.
+func (m *Manager) countBytes(node *WebNode, r io.Reader) io.Reader {
+	return &budgetReader{r: r, add: func(n int) {
+		m.ckMu.Lock()
+		m.scopeOf(node).bytes += int64(n)
+		m.ckMu.Unlock()
+	}}
+}
+
+type budgetReader struct {
+	r   io.Reader
+	add func(int)
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 {
+		b.add(n)
+	}
+	return n, err
+}
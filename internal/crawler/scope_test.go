@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSeedScope(t *testing.T) {
+	c := DefaultConfig()
+	for k, v := range map[string]string{
+		"scope.exclude":                    `/news/`,
+		"seeds.usgs.gov.allow_hosts":       "prd-tnm.s3.amazonaws.com",
+		"seeds.usgs.gov.max_bytes":         "2MB",
+		"seeds.water.usgs.gov.same_domain": "false",
+	} {
+		if err := c.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, kv := range [][2]string{{"seeds.usgs.gov.include", "("}, {"seeds.usgs.gov.max_pages", "-1"}, {"seeds.usgs.gov.depth", "1"}} {
+		if err := c.Set(kv[0], kv[1]); err == nil {
+			t.Errorf("Set(%q, %q) accepted", kv[0], kv[1])
+		}
+	}
+
+	s := c.SeedScope("www.usgs.gov")
+	if !s.SameDomain || s.MaxBytes != 2<<20 || len(s.AllowHosts) != 1 || s.Exclude == nil {
+		t.Fatalf("www.usgs.gov scope: %+v", s)
+	}
+	if s := c.SeedScope("water.usgs.gov"); s.SameDomain || s.MaxBytes != 0 {
+		t.Fatalf("water.usgs.gov scope: %+v", s)
+	}
+
+	seed := &seedScope{ScopeConfig: c.SeedScope("www.usgs.gov"), domain: registrableDomain("www.usgs.gov")}
+	for u, want := range map[string]bool{
+		"https://www.usgs.gov/programs":                    true,
+		"https://ngmdb.usgs.gov/maps":                      true,
+		"https://prd-tnm.s3.amazonaws.com/index.html":      true,
+		"https://other-bucket.s3.amazonaws.com/index.html": false,
+		"https://twitter.com/usgs":                         false,
+		"https://www.usgs.gov/news/press":                  false,
+	} {
+		if why := seed.reject(u); (why == "") != want {
+			t.Errorf("reject(%q) = %q", u, why)
+		}
+	}
+}
+
+func TestCrawlStaysInScope(t *testing.T) {
+	var mu sync.Mutex
+	var offsite string
+	fetched := map[string]int{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[r.Host+r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/data/a">a</a><a href="/data/b">b</a><a href="/data/c">c</a><a href="/blog/">blog</a>`+
+				`<a href="`+offsite+`/data/d">elsewhere</a>`)
+		default:
+			fmt.Fprint(w, `<a href="/x.zip">x</a>`)
+		}
+	}
+	site := httptest.NewServer(http.HandlerFunc(handler))
+	defer site.Close()
+	other := httptest.NewServer(http.HandlerFunc(handler))
+	defer other.Close()
+	offsite = strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	old := activeConfig
+	t.Cleanup(func() { activeConfig = old })
+	activeConfig = DefaultConfig()
+	for k, v := range map[string]string{
+		"seeds.127.0.0.1.exclude":   "/blog/",
+		"seeds.127.0.0.1.max_pages": "3",
+	} {
+		if err := activeConfig.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mg := NewManager(Options{})
+	mg.CachedURLEmbeddings = map[string]DataContext{site.URL + "/": {Description: "seed"}}
+	mg.pages = NewPageCache()
+	mg.crawl(context.Background(), []WebNode{{Url: site.URL + "/"}})
+
+	mu.Lock()
+	defer mu.Unlock()
+	pages := 0
+	for p, n := range fetched {
+		if strings.HasPrefix(p, "localhost") || strings.Contains(p, "/blog/") {
+			t.Errorf("fetched out of scope page %s", p)
+		}
+		pages += n
+	}
+	// The seed and two of its pages fit the budget.
+	if pages != 3 || mg.Progress().Filtered != 3 {
+		t.Fatalf("fetched %v, progress %+v", fetched, mg.Progress())
+	}
+}
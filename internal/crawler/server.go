@@ -0,0 +1,590 @@
+package crawler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states reported by the service.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+)
+
+// Server exposes search, background crawl jobs, downloads and the catalog as
+// a JSON HTTP API. It keeps one warm Manager whose embedding cache is loaded
+// once and shared by every request.
+type Server struct {
+	warm        *Manager
+	cacheMu     sync.RWMutex  // protects warm.CachedURLEmbeddings and the cache file
+	downloadDir string        // each job downloads into a subdirectory named after it
+	secure      bool          // passed on to the download managers
+	slots       chan struct{} // limits the number of crawls running at once
+
+	mu    sync.Mutex // protects jobs and order
+	jobs  map[string]*job
+	order []string // job IDs, oldest first
+}
+
+// JobStatus is the JSON view of a crawl job.
+type JobStatus struct {
+	ID          string          `json:"id"`
+	Query       string          `json:"query"`
+	State       string          `json:"state"`
+	Error       string          `json:"error,omitempty"`
+	Found       int             `json:"found"`
+	Progress    Progress        `json:"progress"`
+	Created     time.Time       `json:"created"`
+	Started     *time.Time      `json:"started,omitempty"`
+	Finished    *time.Time      `json:"finished,omitempty"`
+	Downloading bool            `json:"downloading,omitempty"`
+	DownloadDir string          `json:"download_dir,omitempty"`
+	Manifest    string          `json:"manifest,omitempty"`
+	Downloads   []ManifestEntry `json:"downloads,omitempty"`
+}
+
+// job is a crawl running, or waiting to run, in the background.
+type job struct {
+	mu     sync.Mutex // protects status and results
+	status JobStatus
+	mg     *Manager
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the crawl has ended
+	result []WebNode     // final links, set when done is closed
+}
+
+// jobRequest is the body of POST /v1/jobs.
+type jobRequest struct {
+	Query    string `json:"query"`
+	MaxCrawl int    `json:"max_crawl,omitempty"`
+	TopSeeds int    `json:"top_seeds,omitempty"`
+	// CatalogFirst answers from known datasets when enough of them match.
+	CatalogFirst bool `json:"catalog_first,omitempty"`
+}
+
+// downloadRequest is the body of POST /v1/jobs/{id}/downloads. An empty URL
+// list downloads every result of the job.
+type downloadRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// streamEvent is one line of the NDJSON result stream.
+type streamEvent struct {
+	Type   string     `json:"type"` // "result" or "status"
+	Result *Result    `json:"result,omitempty"`
+	Status *JobStatus `json:"status,omitempty"`
+}
+
+// CatalogEntry is one cached seed or dataset as listed by GET /v1/catalog.
+type CatalogEntry struct {
+	URL         string          `json:"url"`
+	Seed        bool            `json:"seed"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Extent      *BBox           `json:"extent,omitempty"`
+	Found       *time.Time      `json:"found,omitempty"`
+	Checked     *time.Time      `json:"checked,omitempty"`
+	Stale       bool            `json:"stale,omitempty"`
+}
+
+// streamPoll is how often a result stream checks a running job for new
+// links.
+var streamPoll = 250 * time.Millisecond
+
+// NewServer returns a Server around warm, whose cache must already be
+// loaded. At most maxJobs crawls run at once; further jobs wait in the
+// queue.
+func NewServer(warm *Manager, downloadDir string, maxJobs int, secure bool) *Server {
+	if maxJobs <= 0 {
+		maxJobs = 1
+	}
+	return &Server{
+		warm:        warm,
+		downloadDir: downloadDir,
+		secure:      secure,
+		slots:       make(chan struct{}, maxJobs),
+		jobs:        make(map[string]*job),
+	}
+}
+
+// Handler returns the HTTP handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/health", s.handleHealth)
+	mux.HandleFunc("GET /v1/search", s.handleSearch)
+	mux.HandleFunc("GET /v1/catalog", s.handleCatalog)
+	mux.HandleFunc("GET /v1/jobs", s.handleListJobs)
+	mux.HandleFunc("POST /v1/jobs", s.handleCreateJob)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /v1/jobs/{id}/results", s.handleResults)
+	mux.HandleFunc("GET /v1/jobs/{id}/stream", s.handleStream)
+	mux.HandleFunc("POST /v1/jobs/{id}/cancel", s.handleCancel)
+	mux.HandleFunc("POST /v1/jobs/{id}/downloads", s.handleDownloads)
+	return mux
+}
+
+// Shutdown cancels every job and waits for the running crawls to stop.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	for _, j := range jobs {
+		j.cancel()
+	}
+	for _, j := range jobs {
+		<-j.done
+	}
+}
+
+func respondJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func respondError(w http.ResponseWriter, code int, msg string) {
+	respondJSON(w, code, map[string]string{"error": msg})
+}
+
+// intParam reads a non-negative integer query parameter.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.New(name + " must be a non-negative integer")
+	}
+	return n, nil
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.cacheMu.RLock()
+	n := len(s.warm.CachedURLEmbeddings)
+	s.cacheMu.RUnlock()
+	respondJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "cache_entries": n})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		respondError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	top, err := intParam(r, "top", 10)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	emb, err := EmbedQuery(q)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, "embedding query: "+err.Error())
+		return
+	}
+	s.cacheMu.RLock()
+	ranked := s.warm.RankHybrid(q, emb, top)
+	s.cacheMu.RUnlock()
+	results := []Result{}
+	for _, n := range ranked {
+		results = append(results, NewResult(n))
+	}
+	respondJSON(w, http.StatusOK, results)
+}
+
+func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := intParam(r, "limit", 100)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := strings.ToLower(r.URL.Query().Get("q"))
+
+	s.cacheMu.RLock()
+	entries := []CatalogEntry{}
+	for u, ctx := range s.warm.CachedURLEmbeddings {
+		if filter != "" && !strings.Contains(strings.ToLower(u+" "+ctx.Description), filter) {
+			continue
+		}
+		r := NewResult(WebNode{Url: u, context: ctx})
+		entries = append(entries, CatalogEntry{URL: u, Seed: isSeed(u, ctx), Metadata: r.Metadata, Description: r.Description,
+			Extent: ctx.Extent, Found: r.Found, Checked: r.Checked, Stale: r.Stale})
+	}
+	s.cacheMu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+	total := len(entries)
+	if offset > total {
+		offset = total
+	}
+	if limit > total-offset {
+		limit = total - offset
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"total":   total,
+		"offset":  offset,
+		"entries": entries[offset : offset+limit],
+	})
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.order))
+	for _, id := range s.order {
+		jobs = append(jobs, s.jobs[id])
+	}
+	s.mu.Unlock()
+	out := make([]JobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		out = append(out, j.snapshot())
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		respondError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+	j := s.startJob(req)
+	w.Header().Set("Location", "/v1/jobs/"+j.status.ID)
+	respondJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// lookup returns the job named in the request path, writing a 404 when it
+// does not exist.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *job {
+	s.mu.Lock()
+	j := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if j == nil {
+		respondError(w, http.StatusNotFound, "no such job")
+	}
+	return j
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if j := s.lookup(w, r); j != nil {
+		respondJSON(w, http.StatusOK, j.snapshot())
+	}
+}
+
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(w, r)
+	if j == nil {
+		return
+	}
+	top, err := intParam(r, "top", 0)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	results := []Result{}
+	for i, n := range j.links() {
+		if top > 0 && i == top {
+			break
+		}
+		results = append(results, NewResult(n))
+	}
+	respondJSON(w, http.StatusOK, results)
+}
+
+// handleStream writes the job's results as NDJSON as they are found,
+// followed by a final status line once the job has ended.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(w, r)
+	if j == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	// The final results are ranked and may add known datasets, so they are
+	// told apart from those already sent by key rather than by position.
+	sent := make(map[string]bool)
+	send := func() error {
+		for _, n := range j.links() {
+			key := canonicalKey(n.Url)
+			if sent[key] {
+				continue
+			}
+			sent[key] = true
+			res := NewResult(n)
+			if err := enc.Encode(streamEvent{Type: "result", Result: &res}); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(streamPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-j.done:
+			if send() == nil {
+				st := j.snapshot()
+				enc.Encode(streamEvent{Type: "status", Status: &st})
+			}
+			return
+		case <-ticker.C:
+			if err := send(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if j := s.lookup(w, r); j != nil {
+		j.cancel()
+		respondJSON(w, http.StatusAccepted, j.snapshot())
+	}
+}
+
+func (s *Server) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(w, r)
+	if j == nil {
+		return
+	}
+	var req downloadRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	links := j.links()
+	byURL := make(map[string]WebNode, len(links))
+	for _, n := range links {
+		byURL[n.Url] = n
+	}
+	var nodes []*WebNode
+	if len(req.URLs) == 0 {
+		for i := range links {
+			nodes = append(nodes, &links[i])
+		}
+	}
+	for _, u := range req.URLs {
+		n, ok := byURL[u]
+		if !ok {
+			respondError(w, http.StatusBadRequest, u+" is not a result of this job")
+			return
+		}
+		nodes = append(nodes, &n)
+	}
+	if len(nodes) == 0 {
+		respondError(w, http.StatusConflict, "the job has no results to download")
+		return
+	}
+
+	j.mu.Lock()
+	if j.status.Downloading {
+		j.mu.Unlock()
+		respondError(w, http.StatusConflict, "a download is already running for this job")
+		return
+	}
+	j.status.Downloading = true
+	j.status.DownloadDir = filepath.Join(s.downloadDir, j.status.ID)
+	st := j.status
+	j.mu.Unlock()
+
+	go s.download(j, nodes, st.DownloadDir)
+	respondJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// startJob registers a crawl for req and starts it in the background.
+func (s *Server) startJob(req jobRequest) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	mg := NewManager(Options{Query: req.Query, CatalogFirst: req.CatalogFirst})
+	if req.MaxCrawl > 0 {
+		mg.maxCrawl = req.MaxCrawl
+	}
+	if req.TopSeeds > 0 {
+		mg.topSeeds = req.TopSeeds
+	}
+	j := &job{
+		status: JobStatus{ID: newJobID(), Query: req.Query, State: JobQueued, Created: time.Now().UTC()},
+		mg:     mg,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.jobs[j.status.ID] = j
+	s.order = append(s.order, j.status.ID)
+	s.mu.Unlock()
+
+	go s.run(ctx, j)
+	return j
+}
+
+// run waits for a crawl slot, crawls with a private copy of the warm cache
+// and merges the newly embedded links back into it.
+func (s *Server) run(ctx context.Context, j *job) {
+	defer close(j.done)
+	defer j.cancel()
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		j.finish(JobCancelled, nil, nil)
+		return
+	}
+	started := time.Now().UTC()
+	j.mu.Lock()
+	j.status.State = JobRunning
+	j.status.Started = &started
+	j.mu.Unlock()
+
+	s.cacheMu.RLock()
+	cache := make(map[string]DataContext, len(s.warm.CachedURLEmbeddings))
+	for u, c := range s.warm.CachedURLEmbeddings {
+		cache[u] = c
+	}
+	s.cacheMu.RUnlock()
+	j.mg.CachedURLEmbeddings = cache
+	j.mg.lexical = s.warm.lexical
+	j.mg.ann = s.warm.ann
+	j.mg.pages = s.warm.pages
+
+	links, err := j.mg.FindLinksContext(ctx)
+	if err != nil {
+		log.Printf("job %s failed: %v", j.status.ID, err)
+		j.finish(JobFailed, nil, err)
+		return
+	}
+	state := JobDone
+	if ctx.Err() != nil {
+		state = JobCancelled
+	}
+	j.finish(state, links, nil)
+
+	j.mg.Learn(links)
+	s.cacheMu.Lock()
+	changed := 0
+	for u, c := range j.mg.CachedURLEmbeddings {
+		if old, ok := s.warm.CachedURLEmbeddings[u]; !ok || c.Checked.After(old.Checked) {
+			s.warm.CachedURLEmbeddings[u] = c
+			changed++
+		}
+	}
+	if changed > 0 {
+		if err := WriteToGob(dataPath, s.warm.CachedURLEmbeddings); err != nil {
+			log.Printf("failed to write cache to %s: %v", dataPath, err)
+		}
+		if s.warm.ann != nil {
+			if err := s.warm.ann.Save(annPath()); err != nil {
+				log.Printf("failed to write nearest neighbour index to %s: %v", annPath(), err)
+			}
+		}
+	}
+	if s.warm.pages != nil {
+		if err := s.warm.pages.Save(pagesPath()); err != nil {
+			log.Printf("failed to write page cache to %s: %v", pagesPath(), err)
+		}
+	}
+	s.cacheMu.Unlock()
+}
+
+// download fetches nodes into dir and records the manifest on the job.
+func (s *Server) download(j *job, nodes []*WebNode, dir string) {
+	mg := NewManager(Options{Query: j.status.Query, DownloadDir: dir, Secure: s.secure})
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("job %s: %v", j.status.ID, err)
+	} else {
+		for _, n := range nodes {
+			if err := mg.DownloadURL(n); err != nil {
+				log.Printf("job %s: %v", j.status.ID, err)
+				entry := NewManifestEntry(j.status.Query, n)
+				entry.Error = err.Error()
+				mg.addManifestEntry(entry)
+			}
+		}
+	}
+	mf := mg.FinishManifest(nil)
+	manifestPath := manifestLocation("", dir, mf.Session)
+	if err := WriteManifest(mf, manifestPath, ""); err != nil {
+		log.Printf("job %s: %v", j.status.ID, err)
+		manifestPath = ""
+	}
+
+	j.mu.Lock()
+	j.status.Downloading = false
+	j.status.Manifest = manifestPath
+	j.status.Downloads = append(j.status.Downloads, mf.Entries...)
+	j.mu.Unlock()
+}
+
+// finish records the outcome of the crawl.
+func (j *job) finish(state string, links []WebNode, err error) {
+	now := time.Now().UTC()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.State = state
+	j.status.Finished = &now
+	j.result = links
+	if err != nil {
+		j.status.Error = err.Error()
+	}
+}
+
+// links returns the links found so far, or the final list once the crawl
+// has ended.
+func (j *job) links() []WebNode {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Finished != nil {
+		return j.result
+	}
+	return j.mg.Found()
+}
+
+func (j *job) snapshot() JobStatus {
+	links := j.links()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := j.status
+	st.Found = len(links)
+	st.Progress = j.mg.Progress()
+	st.Downloads = append([]ManifestEntry(nil), j.status.Downloads...)
+	return st
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
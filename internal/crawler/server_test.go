@@ -0,0 +1,216 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer serves a seed page linking to one zip file, a fake
+// embedding service and the API around a warm Manager caching the seed.
+func newTestServer(t *testing.T) (api *httptest.Server, srv *Server, site string) {
+	t.Helper()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><a href="/data/counties.zip">Counties</a></body></html>`))
+		case "/data/counties.zip":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Write([]byte("PK\x03\x04data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(web.Close)
+	useFakeEmbedder(t)
+
+	oldData, oldPoll := dataPath, streamPoll
+	dataPath, streamPoll = filepath.Join(t.TempDir(), "data.gob"), 10*time.Millisecond
+	t.Cleanup(func() { dataPath, streamPoll = oldData, oldPoll })
+
+	warm := NewManager(Options{})
+	warm.CachedURLEmbeddings = map[string]DataContext{
+		web.URL + "/": {Description: "county boundaries", Embedding: []float64{1, 0}},
+	}
+	srv = NewServer(warm, t.TempDir(), 1, false)
+	api = httptest.NewServer(srv.Handler())
+	t.Cleanup(api.Close)
+	t.Cleanup(srv.Shutdown)
+	return api, srv, web.URL
+}
+
+func postJSON(t *testing.T, url, body string, want int, out interface{}) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		t.Fatalf("post %s: got %s want %d", url, resp.Status, want)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+}
+
+func getJSON(t *testing.T, url string, out interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+}
+
+func TestServerJobLifecycle(t *testing.T) {
+	api, _, site := newTestServer(t)
+
+	var st JobStatus
+	postJSON(t, api.URL+"/v1/jobs", `{"query":"county boundaries"}`, http.StatusAccepted, &st)
+	if st.ID == "" {
+		t.Fatal("job has no id")
+	}
+
+	resp, err := http.Get(api.URL + "/v1/jobs/" + st.ID + "/stream")
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var results []Result
+	var final *JobStatus
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		var ev streamEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("bad stream line %q: %v", sc.Text(), err)
+		}
+		if ev.Result != nil {
+			results = append(results, *ev.Result)
+		}
+		if ev.Status != nil {
+			final = ev.Status
+		}
+	}
+	resp.Body.Close()
+	if final == nil || final.State != JobDone || final.Found != 1 {
+		t.Fatalf("final status: %+v", final)
+	}
+	if len(results) != 1 || results[0].URL != site+"/data/counties.zip" || results[0].Seed != site+"/" {
+		t.Fatalf("results: %+v", results)
+	}
+
+	postJSON(t, api.URL+"/v1/jobs/"+st.ID+"/downloads", `{"urls":["`+site+`/data/counties.zip"]}`, http.StatusAccepted, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	id := st.ID
+	for {
+		st = JobStatus{}
+		getJSON(t, api.URL+"/v1/jobs/"+id, &st)
+		if !st.Downloading || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(st.Downloads) != 1 || st.Downloads[0].Error != "" || st.Manifest == "" {
+		t.Fatalf("downloads: %+v", st)
+	}
+	if _, err := os.Stat(st.Downloads[0].LocalPath); err != nil {
+		t.Fatalf("downloaded file: %v", err)
+	}
+
+	// The dataset found by the job is merged into the warm catalog.
+	var catalog struct {
+		Total   int            `json:"total"`
+		Entries []CatalogEntry `json:"entries"`
+	}
+	for deadline = time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		getJSON(t, api.URL+"/v1/catalog?q=counties", &catalog)
+		if catalog.Total == 1 {
+			break
+		}
+	}
+	if catalog.Total != 1 || catalog.Entries[0].URL != site+"/data/counties.zip" || catalog.Entries[0].Seed {
+		t.Fatalf("catalog: %+v", catalog)
+	}
+}
+
+// TestServerStreamRankedResults streams a job whose final results are in a
+// different order than they were found, with a known dataset added.
+func TestServerStreamRankedResults(t *testing.T) {
+	api, srv, _ := newTestServer(t)
+	a, b, c := WebNode{Url: "https://example.gov/a.zip"}, WebNode{Url: "https://example.gov/b.zip"}, WebNode{Url: "https://example.gov/c.zip"}
+	mg := NewManager(Options{})
+	mg.downloadURLs = []WebNode{a, b}
+	j := &job{mg: mg, cancel: func() {}, done: make(chan struct{})}
+	srv.mu.Lock()
+	srv.jobs["ranked"] = j
+	srv.mu.Unlock()
+
+	resp, err := http.Get(api.URL + "/v1/jobs/ranked/stream")
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer resp.Body.Close()
+	var urls []string
+	finished := false
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		var ev streamEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("bad stream line %q: %v", sc.Text(), err)
+		}
+		if ev.Result != nil {
+			urls = append(urls, ev.Result.URL)
+		}
+		if len(urls) == 2 && !finished {
+			finished = true
+			j.finish(JobDone, []WebNode{c, b, a}, nil)
+			close(j.done)
+		}
+	}
+	if want := []string{a.Url, b.Url, c.Url}; strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Fatalf("streamed %v, want %v", urls, want)
+	}
+}
+
+func TestServerCancelQueuedJob(t *testing.T) {
+	api, srv, _ := newTestServer(t)
+	srv.slots <- struct{}{} // occupy the only crawl slot
+	defer func() { <-srv.slots }()
+
+	var st JobStatus
+	postJSON(t, api.URL+"/v1/jobs", `{"query":"anything"}`, http.StatusAccepted, &st)
+	if st.State != JobQueued {
+		t.Fatalf("got state %q, want queued", st.State)
+	}
+	postJSON(t, api.URL+"/v1/jobs/"+st.ID+"/cancel", ``, http.StatusAccepted, nil)
+	srv.mu.Lock()
+	j := srv.jobs[st.ID]
+	srv.mu.Unlock()
+	<-j.done
+	getJSON(t, api.URL+"/v1/jobs/"+st.ID, &st)
+	if st.State != JobCancelled {
+		t.Fatalf("got state %q, want cancelled", st.State)
+	}
+
+	postJSON(t, api.URL+"/v1/jobs", `{"query":"  "}`, http.StatusBadRequest, nil)
+	resp, _ := http.Get(api.URL + "/v1/jobs/nope")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown job: %s", resp.Status)
+	}
+}
@@ -0,0 +1,197 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Formats recognised by SniffFormat.
+const (
+	FormatUnknown    = ""
+	FormatHTML       = "html"
+	FormatXML        = "xml"
+	FormatJSON       = "json"
+	FormatGeoJSON    = "geojson"
+	FormatTopoJSON   = "topojson"
+	FormatKML        = "kml"
+	FormatGML        = "gml"
+	FormatZip        = "zip"
+	FormatGzip       = "gzip"
+	FormatTIFF       = "tiff"
+	FormatNetCDF     = "netcdf"
+	FormatHDF5       = "hdf5"
+	FormatHDF4       = "hdf4"
+	FormatGRIB       = "grib"
+	FormatSQLite     = "sqlite"
+	FormatGeoPackage = "geopackage"
+	FormatLAS        = "las"
+	FormatOSMPBF     = "osm-pbf"
+	FormatShapefile  = "shapefile"
+	FormatPDF        = "pdf"
+)
+
+// sniffLen is how many bytes of a response are inspected before deciding
+// what it contains.
+const sniffLen = 1024
+
+// geoBinaryFormats are sniffed formats that are always worth downloading.
+var geoBinaryFormats = map[string]bool{
+	FormatZip: true, FormatGzip: true, FormatTIFF: true, FormatNetCDF: true,
+	FormatHDF5: true, FormatHDF4: true, FormatGRIB: true, FormatSQLite: true,
+	FormatGeoPackage: true, FormatLAS: true, FormatOSMPBF: true, FormatShapefile: true,
+	FormatGeoJSON: true, FormatTopoJSON: true, FormatKML: true, FormatGML: true,
+}
+
+// nonDataFormats are sniffed formats that are never datasets.
+var nonDataFormats = map[string]bool{
+	FormatHTML: true, FormatJSON: true, FormatPDF: true,
+}
+
+// SniffFormat identifies a file format from its first bytes. It returns
+// FormatUnknown for plain text and anything it does not recognise.
+func SniffFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return FormatZip
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		return FormatGzip
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")),
+		bytes.HasPrefix(head, []byte("II+\x00")), bytes.HasPrefix(head, []byte("MM\x00+")):
+		return FormatTIFF
+	case bytes.HasPrefix(head, []byte("CDF\x01")), bytes.HasPrefix(head, []byte("CDF\x02")), bytes.HasPrefix(head, []byte("CDF\x05")):
+		return FormatNetCDF
+	case bytes.HasPrefix(head, []byte("\x89HDF\r\n\x1a\n")):
+		return FormatHDF5
+	case bytes.HasPrefix(head, []byte("\x0e\x03\x13\x01")):
+		return FormatHDF4
+	case bytes.HasPrefix(head, []byte("GRIB")):
+		return FormatGRIB
+	case bytes.HasPrefix(head, []byte("SQLite format 3\x00")):
+		// GeoPackages set the SQLite application_id to "GPKG" (or "GP1x").
+		if len(head) >= 72 && bytes.HasPrefix(head[68:72], []byte("GP")) {
+			return FormatGeoPackage
+		}
+		return FormatSQLite
+	case bytes.HasPrefix(head, []byte("LASF")):
+		return FormatLAS
+	case bytes.HasPrefix(head, []byte("%PDF")):
+		return FormatPDF
+	case len(head) >= 4 && binary.BigEndian.Uint32(head) == 9994:
+		return FormatShapefile
+	case len(head) >= 15 && head[4] == 0x0A && bytes.Equal(head[6:15], []byte("OSMHeader")):
+		return FormatOSMPBF
+	}
+	return sniffText(head)
+}
+
+// sniffText classifies textual payloads: HTML, XML dialects and JSON.
+func sniffText(head []byte) string {
+	trimmed := bytes.TrimLeft(head, " \t\r\n\xef\xbb\xbf")
+	lower := bytes.ToLower(trimmed)
+	switch {
+	case bytes.HasPrefix(lower, []byte("<!doctype html")), bytes.HasPrefix(lower, []byte("<html")),
+		bytes.HasPrefix(lower, []byte("<head")), bytes.HasPrefix(lower, []byte("<body")):
+		return FormatHTML
+	case bytes.HasPrefix(lower, []byte("<")):
+		switch {
+		case bytes.Contains(lower, []byte("<kml")):
+			return FormatKML
+		case bytes.Contains(lower, []byte("<html")):
+			return FormatHTML
+		case bytes.Contains(lower, []byte("gml:featurecollection")), bytes.Contains(lower, []byte("wfs:featurecollection")):
+			return FormatGML
+		}
+		return FormatXML
+	case bytes.HasPrefix(trimmed, []byte("{")), bytes.HasPrefix(trimmed, []byte("[")):
+		compact := bytes.Join(bytes.Fields(trimmed), nil)
+		switch {
+		case bytes.Contains(compact, []byte(`"type":"FeatureCollection"`)),
+			bytes.Contains(compact, []byte(`"type":"Feature"`)):
+			return FormatGeoJSON
+		case bytes.Contains(compact, []byte(`"type":"Topology"`)):
+			return FormatTopoJSON
+		}
+		return FormatJSON
+	}
+	return FormatUnknown
+}
+
+// ContentInfo is the result of classifying an HTTP response.
+type ContentInfo struct {
+	MediaType    string // Content-Type without parameters, lower-cased
+	Filename     string // from Content-Disposition, else the last URL path element
+	Format       string // sniffed format, FormatUnknown if not recognised
+	Downloadable bool   // true when the response should be saved rather than parsed
+}
+
+// peekedBody replays bytes already buffered by a bufio.Reader and closes the
+// original body.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// ContentDispositionFilename returns the filename parameter of a
+// Content-Disposition header, stripped of any directory components.
+func ContentDispositionFilename(h http.Header) string {
+	cd := h.Get("Content-Disposition")
+	if cd == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(cd)
+	if err != nil {
+		return ""
+	}
+	name := strings.ReplaceAll(params["filename"], `\`, "/")
+	name = path.Base(name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// ClassifyResponse decides whether resp carries a dataset. It parses the
+// media type, looks at Content-Disposition and sniffs the first bytes of the
+// body; resp.Body is replaced so the sniffed bytes are not lost.
+func ClassifyResponse(resp *http.Response) ContentInfo {
+	var info ContentInfo
+	if mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		info.MediaType = strings.ToLower(mt)
+	}
+	info.Filename = ContentDispositionFilename(resp.Header)
+	if info.Filename == "" && resp.Request != nil && resp.Request.URL != nil {
+		info.Filename = path.Base(resp.Request.URL.Path)
+	}
+
+	if resp.Body != nil && resp.Body != http.NoBody {
+		br := bufio.NewReaderSize(resp.Body, sniffLen)
+		head, _ := br.Peek(sniffLen)
+		info.Format = SniffFormat(head)
+		resp.Body = peekedBody{Reader: br, Closer: resp.Body}
+	}
+
+	switch {
+	case geoBinaryFormats[info.Format]:
+		info.Downloadable = true
+	case info.Format == FormatHTML, info.MediaType == "text/html" || info.MediaType == "application/xhtml+xml":
+		info.Downloadable = false
+	case GeoMIMETypes[info.MediaType]:
+		// An explicit geo type outweighs the sniffed JSON of a document
+		// whose "type" key comes after the first sniffLen bytes.
+		info.Downloadable = true
+	case nonDataFormats[info.Format]:
+		info.Downloadable = false
+	default:
+		// Generic types such as application/octet-stream or text/plain: fall
+		// back to the extension of the advertised filename.
+		ext := strings.ToLower(path.Ext(info.Filename))
+		info.Downloadable = GeoFileExtensions[ext] && ext != ".json" && ext != ".xml"
+	}
+	return info
+}
@@ -0,0 +1,84 @@
+package crawler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSniffFormat(t *testing.T) {
+	gpkg := make([]byte, 100)
+	copy(gpkg, "SQLite format 3\x00")
+	copy(gpkg[68:], "GPKG")
+	pbf := append([]byte{0, 0, 0, 14, 0x0A, 9}, []byte("OSMHeader")...)
+
+	cases := map[string][]byte{
+		FormatZip:        []byte("PK\x03\x04rest"),
+		FormatTIFF:       []byte("II*\x00\x08\x00"),
+		FormatNetCDF:     []byte("CDF\x01\x00"),
+		FormatHDF5:       []byte("\x89HDF\r\n\x1a\n"),
+		FormatGRIB:       []byte("GRIB\x00\x00"),
+		FormatGeoPackage: gpkg,
+		FormatSQLite:     []byte("SQLite format 3\x00"),
+		FormatLAS:        []byte("LASF\x00\x00"),
+		FormatOSMPBF:     pbf,
+		FormatShapefile:  {0x00, 0x00, 0x27, 0x0A, 0, 0},
+		FormatHTML:       []byte("\n  <!DOCTYPE html><html>"),
+		FormatKML:        []byte(`<?xml version="1.0"?><kml xmlns="http://www.opengis.net/kml/2.2">`),
+		FormatGeoJSON:    []byte(`{ "type" : "FeatureCollection", "features": [`),
+		FormatJSON:       []byte(`{"status":"ok","results":[]}`),
+		FormatUnknown:    []byte("id,name\n1,a\n"),
+	}
+	for want, head := range cases {
+		if got := SniffFormat(head); got != want {
+			t.Errorf("SniffFormat(%q) = %q, want %q", head, got, want)
+		}
+	}
+}
+
+func TestClassifyResponse(t *testing.T) {
+	cases := []struct {
+		name         string
+		contentType  string
+		disposition  string
+		body         string
+		downloadable bool
+		filename     string
+	}{
+		{"json api response", "application/json; charset=utf-8", "", `{"results":[]}`, false, "data"},
+		{"geojson as json", "application/json; charset=utf-8", "", `{"type":"FeatureCollection","features":[]}`, true, "data"},
+		{"octet-stream zip", "application/octet-stream", "", "PK\x03\x04....", true, "data"},
+		{"octet-stream csv by filename", "application/octet-stream", `attachment; filename="wells.csv"`, "id,x,y\n", true, "wells.csv"},
+		{"geojson with late type key", "application/geo+json", "", `{"name":"` + strings.Repeat("x", 2*sniffLen) + `","type":"FeatureCollection","features":[]}`, true, "data"},
+		{"html mislabelled as zip", "application/zip", "", "<html><body>login</body></html>", false, "data"},
+		{"html page", "text/html; charset=UTF-8", "", "<p>hello</p>", false, "data"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				if tc.disposition != "" {
+					w.Header().Set("Content-Disposition", tc.disposition)
+				}
+				w.Write([]byte(tc.body))
+			}))
+			defer ts.Close()
+			resp, err := http.Get(ts.URL + "/data")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			defer resp.Body.Close()
+			info := ClassifyResponse(resp)
+			if info.Downloadable != tc.downloadable || info.Filename != tc.filename {
+				t.Fatalf("got %+v", info)
+			}
+			// The sniffed bytes must still be readable by the caller.
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tc.body {
+				t.Fatalf("body changed: %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// socrataCatalogURL is the Socrata Discovery API endpoint used to search
+// datasets across all Socrata-hosted portals.
+var socrataCatalogURL = "https://api.us.socrata.com/api/catalog/v1"
+
+// socrataDomainURL returns the base URL of a Socrata portal. It is a variable
+// so tests can point export and row-count requests at a local server.
+var socrataDomainURL = func(domain string) string {
+	return "https://" + domain
+}
+
+// socrataSearchLimit caps how many catalog entries are requested per seed.
+const socrataSearchLimit = 50
+
+// socrataGeoColumnTypes lists Socrata column datatypes that carry geometry.
+var socrataGeoColumnTypes = map[string]bool{
+	"point":           true,
+	"multipoint":      true,
+	"line":            true,
+	"multiline":       true,
+	"polygon":         true,
+	"multipolygon":    true,
+	"location":        true,
+	"geospatial":      true,
+	"multilinestring": true,
+}
+
+type socrataResource struct {
+	Name            string   `json:"name"`
+	ID              string   `json:"id"`
+	Description     string   `json:"description"`
+	Type            string   `json:"type"`
+	UpdatedAt       string   `json:"updatedAt"`
+	DataUpdatedAt   string   `json:"data_updated_at"`
+	ColumnsDatatype []string `json:"columns_datatype"`
+	LensViewType    string   `json:"lens_view_type"`
+}
+
+type socrataResult struct {
+	Resource       socrataResource `json:"resource"`
+	Classification struct {
+		DomainTags []string `json:"domain_tags"`
+	} `json:"classification"`
+	Metadata struct {
+		Domain string `json:"domain"`
+	} `json:"metadata"`
+	Permalink string `json:"permalink"`
+}
+
+type socrataCatalogResponse struct {
+	Results       []socrataResult `json:"results"`
+	ResultSetSize int             `json:"resultSetSize"`
+}
+
+// SocrataDomain reports whether rawURL is hosted on a known Socrata portal and
+// returns the portal's domain.
+func SocrataDomain(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return host, SocrataDomains[host]
+}
+
+// isGeospatial reports whether a Socrata catalog entry describes a map view or
+// a dataset with at least one geometry column.
+func (r socrataResult) isGeospatial() bool {
+	if r.Resource.Type == "map" || r.Resource.LensViewType == "geo" {
+		return true
+	}
+	for _, dt := range r.Resource.ColumnsDatatype {
+		if socrataGeoColumnTypes[strings.ToLower(dt)] {
+			return true
+		}
+	}
+	return false
+}
+
+// socrataExport is one download format offered for a Socrata entry.
+type socrataExport struct {
+	Format string
+	URL    string
+}
+
+// exportURLs returns the download URLs Socrata offers for the entry, most
+// useful format first.
+func (r socrataResult) exportURLs(domain string) []socrataExport {
+	base := socrataDomainURL(domain)
+	id := url.PathEscape(r.Resource.ID)
+	exports := []socrataExport{
+		{"geojson", fmt.Sprintf("%s/api/geospatial/%s?method=export&format=GeoJSON", base, id)},
+		{"shapefile", fmt.Sprintf("%s/api/geospatial/%s?method=export&format=Shapefile", base, id)},
+	}
+	if r.Resource.Type != "map" {
+		exports = append(exports, socrataExport{"csv", fmt.Sprintf("%s/api/views/%s/rows.csv?accessType=DOWNLOAD", base, id)})
+	}
+	return exports
+}
+
+// SearchSocrata queries the Socrata Discovery API for datasets on domain that
+// match query and returns one candidate per geospatial export. Row counts are
+// looked up through the SODA endpoint of each dataset.
+func SearchSocrata(domain, query string) ([]WebNode, error) {
+	params := url.Values{}
+	params.Set("domains", domain)
+	params.Set("search_context", domain)
+	params.Set("q", query)
+	params.Set("only", "dataset,map")
+	params.Set("limit", strconv.Itoa(socrataSearchLimit))
+
+	resp, err := httpGet(socrataCatalogURL + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("searching socrata catalog for %s: %s", domain, resp.Status)
+	}
+	var catalog socrataCatalogResponse
+	if err := json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("decoding socrata catalog for %s: %v", domain, err)
+	}
+
+	var geo []socrataResult
+	for _, r := range catalog.Results {
+		if r.isGeospatial() {
+			geo = append(geo, r)
+		}
+	}
+
+	// Row counts need one request per dataset, so fetch them with a small
+	// fixed number of workers.
+	counts := make([]int64, len(geo))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 4)
+	for i, r := range geo {
+		if r.Resource.Type == "map" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			n, err := socrataRowCount(domain, id)
+			if err != nil {
+				log.Printf("	row count for socrata dataset %s/%s: %v", domain, id, err)
+				return
+			}
+			counts[i] = n
+		}(i, r.Resource.ID)
+	}
+	wg.Wait()
+
+	var nodes []WebNode
+	for i, r := range geo {
+		updated := r.Resource.DataUpdatedAt
+		if updated == "" {
+			updated = r.Resource.UpdatedAt
+		}
+		for _, export := range r.exportURLs(domain) {
+			md := downloadMetadata{
+				Title:       r.Resource.Name,
+				Description: strings.Join(strings.Fields(r.Resource.Description), " "),
+				Keywords:    r.Classification.DomainTags,
+				URL:         export.URL,
+				Source:      "socrata",
+				Format:      export.Format,
+				RowCount:    counts[i],
+				UpdatedAt:   normalizeSocrataTime(updated),
+			}
+			out, _ := json.Marshal(md)
+			nodes = append(nodes, WebNode{Url: export.URL, context: DataContext{Description: string(out)}})
+		}
+	}
+	log.Printf("	socrata: %d of %d results on %s are geospatial", len(geo), len(catalog.Results), domain)
+	return nodes, nil
+}
+
+// socrataRowCount asks the SODA endpoint of a dataset for its row count.
+func socrataRowCount(domain, id string) (int64, error) {
+	countURL := fmt.Sprintf("%s/resource/%s.json?$select=count(*)", socrataDomainURL(domain), url.PathEscape(id))
+	resp, err := httpGet(countURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("getting %s: %s", countURL, resp.Status)
+	}
+	var rows []map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	for _, v := range rows[0] {
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, nil
+}
+
+// normalizeSocrataTime converts the timestamps returned by the Discovery API
+// to RFC 3339. Unparseable values are returned unchanged.
+func normalizeSocrataTime(ts string) string {
+	if ts == "" {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ts
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ExtractSocrata runs the Socrata adapter for a seed on a Socrata portal and
+// records every geospatial export it finds in m.downloadURLs. No further links
+// are returned because the portal's HTML pages carry little useful content.
+func (m *Manager) ExtractSocrata(node *WebNode, domain string) ([]WebNode, error) {
+	candidates, err := SearchSocrata(domain, *m.searchQuery)
+	if err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		candidates[i].Parent = node
+		candidates[i].Depth = node.Depth + 1
+	}
+	for _, c := range candidates {
+		m.addCandidate(c)
+	}
+	return nil, nil
+}
@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSocrataDomain(t *testing.T) {
+	domain, ok := SocrataDomain("https://data.cityofnewyork.us/browse?q=geospatial&sortBy=relevance")
+	if !ok || domain != "data.cityofnewyork.us" {
+		t.Fatalf("got %q %v", domain, ok)
+	}
+	domain, ok = SocrataDomain("https://www.data.wa.gov/data-categories/geospatial")
+	if !ok || domain != "data.wa.gov" {
+		t.Fatalf("got %q %v", domain, ok)
+	}
+	if _, ok := SocrataDomain("https://www.mrlc.gov/"); ok {
+		t.Fatalf("mrlc.gov is not a socrata portal")
+	}
+}
+
+func TestSearchSocrata(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/catalog/v1", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("domains") != "data.cityofnewyork.us" {
+			t.Errorf("unexpected domains param %q", r.URL.Query().Get("domains"))
+		}
+		w.Write([]byte(`{"results":[
+			{"resource":{"name":"Street Trees","id":"abcd-1234","type":"dataset","description":"Tree census",
+			  "data_updated_at":"2024-03-01T10:00:00.000Z","columns_datatype":["Text","Point"]},
+			 "metadata":{"domain":"data.cityofnewyork.us"}},
+			{"resource":{"name":"Borough Boundaries","id":"map0-0001","type":"map","updatedAt":"2023-01-01T00:00:00Z"},
+			 "metadata":{"domain":"data.cityofnewyork.us"}},
+			{"resource":{"name":"Budget","id":"txt0-0001","type":"dataset","columns_datatype":["Text","Number"]},
+			 "metadata":{"domain":"data.cityofnewyork.us"}}
+		]}`))
+	})
+	mux.HandleFunc("/resource/abcd-1234.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"count":"683788"}]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	origCatalog, origDomain := socrataCatalogURL, socrataDomainURL
+	socrataCatalogURL = ts.URL + "/api/catalog/v1"
+	socrataDomainURL = func(string) string { return ts.URL }
+	defer func() { socrataCatalogURL, socrataDomainURL = origCatalog, origDomain }()
+
+	nodes, err := SearchSocrata("data.cityofnewyork.us", "street trees")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 3 exports for the dataset, 2 for the map view, none for the budget table.
+	if len(nodes) != 5 {
+		t.Fatalf("expected 5 candidates, got %d", len(nodes))
+	}
+	var md downloadMetadata
+	if err := json.Unmarshal([]byte(nodes[0].context.Description), &md); err != nil {
+		t.Fatalf("unmarshal description: %v", err)
+	}
+	if md.Title != "Street Trees" || md.Format != "geojson" || md.RowCount != 683788 {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+	if md.UpdatedAt != "2024-03-01T10:00:00Z" {
+		t.Fatalf("unexpected updated_at %q", md.UpdatedAt)
+	}
+	if !strings.HasPrefix(nodes[0].Url, ts.URL+"/api/geospatial/abcd-1234") {
+		t.Fatalf("unexpected export URL %s", nodes[0].Url)
+	}
+}
@@ -1,11 +1,17 @@
 package crawler
 
+import (
+	"sync"
+	"time"
+)
+
 type WebNode struct {
 	Url              string
 	Parent           *WebNode // node is a parent if parentURL == "root"
 	Depth            int
 	context          DataContext
 	CosineSimilarity float64
+	Relevance        *Score // set by RankCandidates
 }
 
 type Manager struct {
@@ -13,6 +19,7 @@ type Manager struct {
 	downloadPath        *string
 	searchQuery         *string
 	downloadURLs        []WebNode
+	candidates          map[string]bool // canonical keys of the URLs in downloadURLs
 	CachedURLEmbeddings map[string]DataContext
 	searchFrom          map[string]DataContext
 	linkChan            chan struct{}
@@ -20,13 +27,50 @@ type Manager struct {
 	dlTokens            chan struct{}
 	worklist            chan []WebNode
 	done                chan bool
-	seen                map[string]bool
+	seen                map[string]bool // canonical keys of the pages crawled
+	maxCrawl            int             // pages visited before the crawl stops
+	topSeeds            int             // best-matching seeds the crawl starts from
+	catalogFirst        bool            // answer from known datasets before crawling
+
+	manifest   *Manifest      // provenance record of the current session
+	manifestMu sync.Mutex     // protects manifest
+	downloads  sync.WaitGroup // tracks downloads started during the crawl
+
+	inspectArchives bool // list and classify downloaded zip archives
+	extractArchives bool // safely extract downloaded zip archives
+
+	lexical *LexicalIndex // BM25 index over CachedURLEmbeddings; nil until Init
+	ann     *ANNIndex     // nearest neighbour index over CachedURLEmbeddings; nil until Init
+	pages   *PageCache    // validators and links of crawled pages; nil until Init
+
+	recrawlAge time.Duration // pages fetched more recently are not fetched again; 0 revalidates every page
+
+	ckMu           sync.Mutex            // protects seen, crawled, pending, frontier, fingerprints and scopes while crawling
+	crawled        int                   // pages started, counted against maxCrawl
+	pending        map[string]WebNode    // pages being fetched, by canonical key
+	frontier       map[string]WebNode    // links returned by fetched pages and not yet examined, by canonical key
+	fingerprints   []uint64              // SimHashes of the pages crawled
+	scopes         map[string]*seedScope // scope and budgets spent, by seed URL
+	quotaMu        sync.Mutex
+	quotaUsed      int64 // bytes downloaded or reserved this session, against maxTotalBytes
+	placeMu        sync.Mutex
+	placed         map[string]string // path of each file saved this session, by SHA-256
+	checkpointPath string            // where crawl state is saved; empty to not checkpoint
+	resumeFrontier []WebNode         // set by Resume: pages to crawl instead of seeds
+
+	onEvent    func(Event) // receives crawl events; may be nil
+	eventMu    sync.Mutex  // serializes onEvent and protects progress and seedErrors
+	progress   Progress
+	seedErrors map[string]map[string]int // pages that failed, by seed URL and class of error
 }
 
 // DataContext holds metadata about a public data source.
 type DataContext struct {
 	Description string    // human-readable description of the endpoint
 	Embedding   []float64 // placeholder for a future embedding value
+	Extent      *BBox     // lon/lat extent read from downloaded files, if known
+	Found       time.Time // when a crawl first discovered the dataset; zero for seeds
+	Checked     time.Time // when a crawl last found the dataset
 }
 
 // downloadMetadata represents extracted information about a downloadable file.
@@ -35,6 +79,10 @@ type downloadMetadata struct {
 	Description string   `json:"description,omitempty"`
 	Keywords    []string `json:"keywords,omitempty"`
 	URL         string   `json:"url"`
+	Source      string   `json:"source,omitempty"`     // adapter that produced the entry, e.g. "socrata"
+	Format      string   `json:"format,omitempty"`     // export format when known up front
+	RowCount    int64    `json:"row_count,omitempty"`  // number of rows reported by the portal
+	UpdatedAt   string   `json:"updated_at,omitempty"` // last data update reported by the portal
 }
 
 type TextPayload struct {